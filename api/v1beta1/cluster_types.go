@@ -30,6 +30,53 @@ type ClusterSpec struct {
 
 	// Domain name associated with cluster (for ingress filtering).
 	DomainName string `json:"domainName"`
+
+	// Policy applied to images used by microservices in the cluster.
+	//+optional
+	ImagePolicy *ImagePolicySpec `json:"imagePolicy,omitempty"`
+
+	// Images used for workloads created by the operator (defaults used if not set).
+	//+optional
+	SystemImages *SystemImagesSpec `json:"systemImages,omitempty"`
+}
+
+// ImagePolicySpec restricts where images may be pulled from and how tags are resolved
+type ImagePolicySpec struct {
+	// Registry hosts images may be pulled from (all if not set).
+	//+optional
+	AllowedRegistries []string `json:"allowedRegistries,omitempty"`
+
+	// Repositories (in 'registry/repository' form) images may be pulled from. Entries
+	// ending in '/*' allow all repositories with the given prefix (all if not set).
+	//+optional
+	AllowedRepositories []string `json:"allowedRepositories,omitempty"`
+
+	// Resolves image tags to digests when tenant microservices are rolled out.
+	//+optional
+	ResolveDigests bool `json:"resolveDigests,omitempty"`
+
+	// Registry hosts accessed over plain HTTP when resolving digests.
+	//+optional
+	InsecureRegistries []string `json:"insecureRegistries,omitempty"`
+}
+
+// SystemImagesSpec overrides images of workloads created by the operator
+type SystemImagesSpec struct {
+	// Image of the nginx backend answering requests during maintenance and suspension.
+	//+optional
+	Maintenance string `json:"maintenance,omitempty"`
+
+	// Image of Kafka deployed as a stateful set.
+	//+optional
+	Kafka string `json:"kafka,omitempty"`
+
+	// Image of PostgreSQL deployed as a stateful set (also used for datastore jobs).
+	//+optional
+	PostgreSQL string `json:"postgresql,omitempty"`
+
+	// Image of Redis deployed as a stateful set.
+	//+optional
+	Redis string `json:"redis,omitempty"`
 }
 
 // ClusterStatus defines the observed state of Cluster
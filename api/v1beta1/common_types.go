@@ -18,6 +18,9 @@ package v1beta1
 
 import (
 	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // Opaque configuration data specific to an entity.
@@ -27,3 +30,115 @@ type EntityConfiguration struct {
 	//+kubebuilder:pruning:PreserveUnknownFields
 	json.RawMessage `json:",inline"`
 }
+
+// Pod scheduling settings. Settings are merged from instance, microservice and tenant
+// microservice levels (in that order) with later levels taking precedence.
+type SchedulingSpec struct {
+	// Node labels which must match for pods to be scheduled. Merged by key.
+	//+optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Affinity rules. Node, pod and pod anti-affinity sections replace those from earlier levels.
+	//+optional
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+
+	// Tolerations appended to those from earlier levels.
+	//+optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+
+	// Topology spread constraints. Constraints replace those with the same topology key from
+	// earlier levels. If no label selector is given, the tenant microservice labels are used.
+	//+optional
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+
+	// Disables the default anti-affinity between replicas of the same tenant microservice.
+	// Later levels may set false to enable it again.
+	//+optional
+	DisableDefaultAntiAffinity *bool `json:"disableDefaultAntiAffinity,omitempty"`
+}
+
+// CredentialType indicates how a generated credential is formatted
+// +kubebuilder:validation:Enum=password;signingKey;apiToken
+type CredentialType string
+
+const (
+	// Random alphanumeric password.
+	CredentialPassword CredentialType = "password"
+
+	// Random key material encoded as base64.
+	CredentialSigningKey CredentialType = "signingKey"
+
+	// Random token encoded as hex.
+	CredentialApiToken CredentialType = "apiToken"
+)
+
+// CredentialSpec declares a credential generated per tenant
+type CredentialSpec struct {
+	// Name of the credential (unique within the microservice).
+	Name string `json:"name"`
+
+	// Type of credential to generate.
+	Type CredentialType `json:"type"`
+
+	// Length of the generated password, or number of random bytes for keys and tokens.
+	//+optional
+	//+kubebuilder:validation:Minimum=8
+	Length int32 `json:"length,omitempty"`
+
+	// Interval after which the credential is rotated (never rotated automatically if not set).
+	//+optional
+	RotationInterval *metav1.Duration `json:"rotationInterval,omitempty"`
+}
+
+// TenantJobSpec defines a job run for each tenant of a microservice
+type TenantJobSpec struct {
+	// Image run by the job (defaults to the image of the tenant microservice).
+	//+optional
+	Image string `json:"image,omitempty"`
+
+	// Entrypoint of the container (defaults to that of the image).
+	//+optional
+	Command []string `json:"command,omitempty"`
+
+	// Arguments passed to the entrypoint.
+	//+optional
+	Args []string `json:"args,omitempty"`
+
+	// Environment variables added to those identifying the tenant and microservice.
+	//+optional
+	Env []corev1.EnvVar `json:"env,omitempty"`
+
+	// Compute resources of the job container.
+	//+optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// Number of retries before the job is marked as failed (defaults to 3).
+	//+optional
+	//+kubebuilder:validation:Minimum=0
+	BackoffLimit *int32 `json:"backoffLimit,omitempty"`
+
+	// Duration (in seconds) the job may run before it is terminated.
+	//+optional
+	//+kubebuilder:validation:Minimum=1
+	ActiveDeadlineSeconds *int64 `json:"activeDeadlineSeconds,omitempty"`
+}
+
+// JobPhase indicates the progress of a tenant job
+// +kubebuilder:validation:Enum=Running;Succeeded;Failed
+type JobPhase string
+
+const (
+	JobRunning   JobPhase = "Running"
+	JobSucceeded JobPhase = "Succeeded"
+	JobFailed    JobPhase = "Failed"
+)
+
+// DeletionPolicy indicates whether resources generated for a tenant are deleted or retained
+// when its tenant microservice is deleted
+// +kubebuilder:validation:Enum=Delete;Retain
+type DeletionPolicy string
+
+const (
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+)
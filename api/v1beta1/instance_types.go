@@ -17,6 +17,8 @@
 package v1beta1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -33,10 +35,319 @@ type InstanceSpec struct {
 
 	// Instance configuration information.
 	Configuration EntityConfiguration `json:"configuration"`
+
+	// Default pod scheduling settings for all workloads in the instance.
+	//+optional
+	Scheduling *SchedulingSpec `json:"scheduling,omitempty"`
+
+	// Network isolation settings for tenants of the instance.
+	//+optional
+	NetworkIsolation *NetworkIsolationSpec `json:"networkIsolation,omitempty"`
+
+	// Pod Security Admission levels applied to the instance namespace.
+	//+optional
+	PodSecurity *PodSecuritySpec `json:"podSecurity,omitempty"`
+
+	// Image pull secrets used by all workloads in the instance.
+	//+optional
+	ImagePullSecrets []ImagePullSecretSpec `json:"imagePullSecrets,omitempty"`
+
+	// Maintenance mode settings for the instance.
+	//+optional
+	Maintenance *MaintenanceSpec `json:"maintenance,omitempty"`
+
+	// Resource quota applied to the instance namespace.
+	//+optional
+	ResourceQuota *corev1.ResourceQuotaSpec `json:"resourceQuota,omitempty"`
+
+	// Default and allowed compute resources for containers in the instance namespace.
+	//+optional
+	LimitRange *corev1.LimitRangeSpec `json:"limitRange,omitempty"`
+
+	// Limits on the number and naming of tenants created in the instance.
+	//+optional
+	TenantPolicy *TenantPolicySpec `json:"tenantPolicy,omitempty"`
+
+	// Shared infrastructure provisioned for microservices of the instance.
+	//+optional
+	Infrastructure *InfrastructureSpec `json:"infrastructure,omitempty"`
+}
+
+// InfrastructureSpec declares shared infrastructure components required by an instance
+type InfrastructureSpec struct {
+	// Kafka cluster used for event streaming.
+	//+optional
+	Kafka *InfrastructureComponentSpec `json:"kafka,omitempty"`
+
+	// PostgreSQL server used for relational data.
+	//+optional
+	PostgreSQL *InfrastructureComponentSpec `json:"postgresql,omitempty"`
+
+	// Redis server used for caching.
+	//+optional
+	Redis *InfrastructureComponentSpec `json:"redis,omitempty"`
+}
+
+// InfrastructureProvider indicates how an infrastructure component is deployed
+// +kubebuilder:validation:Enum=StatefulSet;Operator
+type InfrastructureProvider string
+
+const (
+	InfrastructureStatefulSet InfrastructureProvider = "StatefulSet"
+	InfrastructureOperator    InfrastructureProvider = "Operator"
+)
+
+// InfrastructureComponentSpec defines how an infrastructure component is deployed
+type InfrastructureComponentSpec struct {
+	// Deploys the component as a stateful set or as a resource managed by its operator
+	// (Strimzi for Kafka, CloudNativePG for PostgreSQL). Defaults to StatefulSet.
+	//+optional
+	Provider InfrastructureProvider `json:"provider,omitempty"`
+
+	// Container image (defaults to the image for the component and provider).
+	//+optional
+	Image string `json:"image,omitempty"`
+
+	// Number of replicas (defaults to 1). Stateful set deployments of PostgreSQL and Redis
+	// always run a single replica. Kafka stateful set replicas are fixed once created since
+	// they form the KRaft controller quorum; changes are reported in status and not applied.
+	//+optional
+	//+kubebuilder:validation:Minimum=1
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// Size of the persistent volume of each replica (defaults to 8Gi).
+	//+optional
+	StorageSize *resource.Quantity `json:"storageSize,omitempty"`
+
+	// Storage class of persistent volumes (cluster default if not set).
+	//+optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+
+	// Compute resources of each replica.
+	//+optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// TenantPolicySpec defines restrictions enforced when tenants are created in an instance
+type TenantPolicySpec struct {
+	// Maximum number of tenants in the instance (unlimited if not set).
+	//+optional
+	//+kubebuilder:validation:Minimum=0
+	MaxTenants *int32 `json:"maxTenants,omitempty"`
+
+	// Prefixes of which tenant ids must start with one (any id if not set).
+	//+optional
+	AllowedPrefixes []string `json:"allowedPrefixes,omitempty"`
+
+	// Regular expression tenant ids must match (any id if not set).
+	//+optional
+	NamePattern string `json:"namePattern,omitempty"`
+}
+
+// MaintenanceSpec defines how an instance is taken offline for maintenance. Maintenance is
+// active while enabled or while within the scheduled window.
+type MaintenanceSpec struct {
+	// Puts the instance into maintenance mode immediately.
+	//+optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Window during which maintenance mode is active.
+	//+optional
+	Window *MaintenanceWindowSpec `json:"window,omitempty"`
+
+	// Message returned by the maintenance backend.
+	//+optional
+	Message string `json:"message,omitempty"`
+
+	// Static response returned instead of the default maintenance message.
+	//+optional
+	StaticResponse *StaticResponseSpec `json:"staticResponse,omitempty"`
+
+	// Scales all tenant workloads to zero while in maintenance.
+	//+optional
+	ScaleDown bool `json:"scaleDown,omitempty"`
+}
+
+// MaintenanceWindowSpec defines a scheduled maintenance window
+type MaintenanceWindowSpec struct {
+	// Time at which maintenance starts.
+	Start metav1.Time `json:"start"`
+
+	// Time at which maintenance ends.
+	End metav1.Time `json:"end"`
+}
+
+// StaticResponseSpec defines a fixed HTTP response returned while in maintenance
+type StaticResponseSpec struct {
+	// HTTP status code of the response (defaults to 503).
+	//+optional
+	//+kubebuilder:validation:Minimum=400
+	//+kubebuilder:validation:Maximum=599
+	StatusCode int32 `json:"statusCode,omitempty"`
+
+	// Content type of the response body (defaults to text/html).
+	//+optional
+	//+kubebuilder:validation:Pattern=`^[A-Za-z0-9.+-]+/[A-Za-z0-9.+-]+$`
+	ContentType string `json:"contentType,omitempty"`
+
+	// Response body.
+	Body string `json:"body"`
+}
+
+// ImagePullSecretSpec identifies a registry pull secret in the instance namespace
+type ImagePullSecretSpec struct {
+	// Name of the pull secret in the instance namespace.
+	Name string `json:"name"`
+
+	// Name of a secret in the operator namespace which is copied to the instance namespace
+	// and kept in sync. If not set, the secret is expected to exist already.
+	//+optional
+	SourceSecret string `json:"sourceSecret,omitempty"`
+}
+
+// PodSecurityLevel is a Pod Security Standards level
+// +kubebuilder:validation:Enum=privileged;baseline;restricted
+type PodSecurityLevel string
+
+const (
+	PodSecurityPrivileged PodSecurityLevel = "privileged"
+	PodSecurityBaseline   PodSecurityLevel = "baseline"
+	PodSecurityRestricted PodSecurityLevel = "restricted"
+)
+
+// PodSecuritySpec defines Pod Security Admission levels for an instance namespace.
+// Levels which are not set default to restricted.
+type PodSecuritySpec struct {
+	// Level enforced for pods in the namespace.
+	//+optional
+	Enforce PodSecurityLevel `json:"enforce,omitempty"`
+
+	// Level for which violations are added to the audit log.
+	//+optional
+	Audit PodSecurityLevel `json:"audit,omitempty"`
+
+	// Level for which violations are returned as warnings.
+	//+optional
+	Warn PodSecurityLevel `json:"warn,omitempty"`
+}
+
+// NetworkIsolationSpec defines how tenant workloads are isolated from each other
+type NetworkIsolationSpec struct {
+	// Disables generation of tenant network policies for the instance.
+	//+optional
+	Disabled bool `json:"disabled,omitempty"`
+
+	// Namespace of the ingress controller allowed to reach tenant pods (defaults to ingress-nginx).
+	//+optional
+	IngressNamespace string `json:"ingressNamespace,omitempty"`
+
+	// Labels of ingress controller pods allowed to reach tenant pods (all pods if not set).
+	//+optional
+	IngressPodLabels map[string]string `json:"ingressPodLabels,omitempty"`
+
+	// Shared instance services which may exchange traffic with all tenants.
+	//+optional
+	SharedServices []SharedServiceSpec `json:"sharedServices,omitempty"`
+}
+
+// SharedServiceSpec identifies pods of a service shared by all tenants in an instance
+type SharedServiceSpec struct {
+	// Name of the shared service.
+	Name string `json:"name"`
+
+	// Selector for pods of the shared service.
+	PodSelector metav1.LabelSelector `json:"podSelector"`
 }
 
 // InstanceStatus defines the observed state of Instance
 type InstanceStatus struct {
+	// Number of tenants in the instance.
+	//+optional
+	Tenants int32 `json:"tenants,omitempty"`
+
+	// Maximum number of tenants allowed in the instance (unlimited if not set).
+	//+optional
+	MaxTenants *int32 `json:"maxTenants,omitempty"`
+
+	// Current maintenance state of the instance.
+	//+optional
+	Maintenance *MaintenanceStatus `json:"maintenance,omitempty"`
+
+	// Dependencies between microservices of the instance in startup order.
+	//+optional
+	DependencyGraph []MicroserviceDependencies `json:"dependencyGraph,omitempty"`
+
+	// Problems with microservice dependencies such as missing functional areas or cycles.
+	//+optional
+	DependencyErrors []string `json:"dependencyErrors,omitempty"`
+
+	// State of shared infrastructure components.
+	//+optional
+	Infrastructure []InfrastructureStatus `json:"infrastructure,omitempty"`
+}
+
+// InfrastructureStatus indicates the observed state of an infrastructure component
+type InfrastructureStatus struct {
+	// Name of the component (kafka, postgresql or redis).
+	Component string `json:"component"`
+
+	// How the component is deployed.
+	Provider InfrastructureProvider `json:"provider"`
+
+	// Indicates whether the component is ready for use.
+	Ready bool `json:"ready"`
+
+	// Address at which the component is reached.
+	//+optional
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Reason the component is not ready or its spec could not be applied.
+	//+optional
+	Message string `json:"message,omitempty"`
+}
+
+// MicroserviceDependencies describes the dependencies of a microservice in an instance
+type MicroserviceDependencies struct {
+	// Id of the microservice.
+	MicroserviceId string `json:"microserviceId"`
+
+	// Functional area of the microservice.
+	FunctionalArea string `json:"functionalArea"`
+
+	// Functional areas the microservice depends on.
+	//+optional
+	DependsOn []string `json:"dependsOn,omitempty"`
+
+	// Startup stage. Microservices start once those of earlier stages they depend on are
+	// ready (not set if part of a dependency cycle).
+	//+optional
+	Stage *int32 `json:"stage,omitempty"`
+}
+
+// MaintenanceStatus indicates the observed maintenance state of an instance
+type MaintenanceStatus struct {
+	// Indicates whether the instance is in maintenance mode.
+	Active bool `json:"active"`
+
+	// Time at which the instance entered maintenance mode.
+	//+optional
+	Since *metav1.Time `json:"since,omitempty"`
+
+	// Start of the scheduled maintenance window.
+	//+optional
+	WindowStart *metav1.Time `json:"windowStart,omitempty"`
+
+	// End of the scheduled maintenance window.
+	//+optional
+	WindowEnd *metav1.Time `json:"windowEnd,omitempty"`
+
+	// Indicates whether tenant workloads are scaled down for maintenance.
+	//+optional
+	ScaledDown bool `json:"scaledDown,omitempty"`
+
+	// Replica counts of tenant deployments (by name) before being scaled down.
+	//+optional
+	ScaledReplicas map[string]int32 `json:"scaledReplicas,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -0,0 +1,72 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1beta1
+
+import (
+	"regexp"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var instancelog = logf.Log.WithName("instance-resource")
+
+func (r *Instance) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-core-devicechain-io-v1beta1-instance,mutating=false,failurePolicy=fail,sideEffects=None,groups=core.devicechain.io,resources=instances,verbs=create;update,versions=v1beta1,name=vinstance.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &Instance{}
+
+// ValidateCreate checks the tenant policy of a new instance.
+func (r *Instance) ValidateCreate() error {
+	instancelog.Info("validate create", "name", r.Name)
+	return r.validateTenantPolicy()
+}
+
+// ValidateUpdate checks the tenant policy of an updated instance.
+func (r *Instance) ValidateUpdate(old runtime.Object) error {
+	instancelog.Info("validate update", "name", r.Name)
+	return r.validateTenantPolicy()
+}
+
+// ValidateDelete allows all deletes.
+func (r *Instance) ValidateDelete() error {
+	return nil
+}
+
+// Reject a tenant name pattern which does not compile.
+func (r *Instance) validateTenantPolicy() error {
+	policy := r.Spec.TenantPolicy
+	if policy == nil || policy.NamePattern == "" {
+		return nil
+	}
+	if _, err := regexp.Compile(policy.NamePattern); err != nil {
+		path := field.NewPath("spec").Child("tenantPolicy").Child("namePattern")
+		errs := field.ErrorList{field.Invalid(path, policy.NamePattern, err.Error())}
+		return apierrors.NewInvalid(GroupVersion.WithKind("Instance").GroupKind(), r.Name, errs)
+	}
+	return nil
+}
@@ -32,18 +32,236 @@ type MicroserviceSpec struct {
 	// Unique functional area of microservice.
 	FunctionalArea string `json:"functionalArea"`
 
+	// Functional areas of microservices which must be ready for a tenant before the
+	// microservice is started for the tenant.
+	//+optional
+	Dependencies []string `json:"dependencies,omitempty"`
+
 	// Docker image information for microservice runtime.
 	Image string `json:"image"`
 
 	// Indicates pull policy used for pulling Docker image.
 	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
 
+	// Image pull secrets (in the instance namespace) used in addition to those of the instance.
+	//+optional
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+
 	// Id of the microservice configuration resource used to load config.
 	ConfigurationId string `json:"configId"`
+
+	// Pod scheduling settings for all tenants of the microservice.
+	//+optional
+	Scheduling *SchedulingSpec `json:"scheduling,omitempty"`
+
+	// Exceptions to the hardened default security context. Exceptions must be admitted by the
+	// pod security level enforced on the instance (restricted unless configured).
+	//+optional
+	SecurityExceptions *SecurityExceptionsSpec `json:"securityExceptions,omitempty"`
+
+	// Credentials generated for each tenant of the microservice. Copied from the microservice
+	// configuration on create and maintained here afterwards.
+	//+optional
+	Credentials []CredentialSpec `json:"credentials,omitempty"`
+
+	// Strategy for rolling out image changes across tenants (all at once if not set).
+	//+optional
+	Rollout *RolloutSpec `json:"rollout,omitempty"`
+
+	// Job run for each tenant before its deployment is first created.
+	//+optional
+	ProvisionJob *TenantJobSpec `json:"provisionJob,omitempty"`
+
+	// Job run for each tenant after its tenant microservice is deleted.
+	//+optional
+	DeprovisionJob *TenantJobSpec `json:"deprovisionJob,omitempty"`
+
+	// Job run for each tenant when the microservice image changes. Deployments are only
+	// updated to the new image once the migration succeeds. The job is not run when pinning a
+	// tag to its digest or when reverting to the image last migrated from, so migrations must
+	// leave data usable by the previous image.
+	//+optional
+	MigrationJob *TenantJobSpec `json:"migrationJob,omitempty"`
+
+	// Kafka topics created for each tenant of the microservice.
+	//+optional
+	Topics []TopicTemplateSpec `json:"topics,omitempty"`
+
+	// PostgreSQL database provisioned for each tenant of the microservice.
+	//+optional
+	Datastore *DatastoreSpec `json:"datastore,omitempty"`
+}
+
+// DatastoreIsolation indicates how tenant data is separated in PostgreSQL
+// +kubebuilder:validation:Enum=Database;Schema
+type DatastoreIsolation string
+
+const (
+	DatastoreDatabase DatastoreIsolation = "Database"
+	DatastoreSchema   DatastoreIsolation = "Schema"
+)
+
+// DatastoreSpec binds a microservice to a database provisioned for each tenant on the
+// instance PostgreSQL server
+type DatastoreSpec struct {
+	// Whether each tenant gets its own database or its own schema in a database shared by
+	// all tenants of the microservice (defaults to Database).
+	//+optional
+	Isolation DatastoreIsolation `json:"isolation,omitempty"`
+
+	// Image with the psql client used by provisioning jobs (defaults to the PostgreSQL image
+	// used for instance infrastructure).
+	//+optional
+	Image string `json:"image,omitempty"`
+
+	// Whether the tenant database (or schema) and role are dropped or retained when the
+	// tenant microservice is deleted (defaults to Retain).
+	//+optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+}
+
+// TopicTemplateSpec defines a Kafka topic created for each tenant of a microservice
+type TopicTemplateSpec struct {
+	// Name of the topic in which '{tenant}' is replaced with the tenant id.
+	//+kubebuilder:validation:MinLength=1
+	//+kubebuilder:validation:Pattern=`^[a-zA-Z0-9._{}-]+$`
+	Name string `json:"name"`
+
+	// Number of partitions (defaults to 1).
+	//+optional
+	//+kubebuilder:validation:Minimum=1
+	Partitions *int32 `json:"partitions,omitempty"`
+
+	// Number of replicas of each partition (broker default if not set).
+	//+optional
+	//+kubebuilder:validation:Minimum=1
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// Time messages are retained (broker default if not set).
+	//+optional
+	Retention *metav1.Duration `json:"retention,omitempty"`
+
+	// Additional topic configuration.
+	//+optional
+	Config map[string]string `json:"config,omitempty"`
+
+	// Whether the topic is deleted or retained when the tenant microservice is deleted
+	// (defaults to Delete).
+	//+optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+}
+
+// RolloutSpec defines how image changes are rolled out across tenants in waves
+type RolloutSpec struct {
+	// Selects tenants (by tenant labels) which are upgraded first as canaries.
+	//+optional
+	CanarySelector *metav1.LabelSelector `json:"canarySelector,omitempty"`
+
+	// Cumulative percentages of tenants upgraded by each wave after the canaries. The last
+	// wave is always extended to include all tenants (defaults to 100).
+	//+optional
+	Waves []int32 `json:"waves,omitempty"`
+
+	// Time a wave may take to become healthy before it is reverted (defaults to 10m).
+	//+optional
+	ProgressDeadline *metav1.Duration `json:"progressDeadline,omitempty"`
+
+	// Pauses the rollout after the current wave.
+	//+optional
+	Paused bool `json:"paused,omitempty"`
+}
+
+// SecurityExceptionsSpec relaxes the hardened security context applied to microservice pods
+type SecurityExceptionsSpec struct {
+	// Allows containers to run as the root user.
+	//+optional
+	RunAsRoot bool `json:"runAsRoot,omitempty"`
+
+	// Allows containers to write to their root filesystem.
+	//+optional
+	WritableRootFilesystem bool `json:"writableRootFilesystem,omitempty"`
+
+	// Allows processes to gain more privileges than their parent.
+	//+optional
+	AllowPrivilegeEscalation bool `json:"allowPrivilegeEscalation,omitempty"`
+
+	// Runs containers without the runtime default seccomp profile.
+	//+optional
+	UnconfinedSeccomp bool `json:"unconfinedSeccomp,omitempty"`
+
+	// Capabilities added back after all others are dropped.
+	//+optional
+	AddCapabilities []corev1.Capability `json:"addCapabilities,omitempty"`
 }
 
 // MicroserviceStatus defines the observed state of Microservice
 type MicroserviceStatus struct {
+	// Security exceptions currently applied to microservice pods.
+	//+optional
+	SecurityExceptions []string `json:"securityExceptions,omitempty"`
+
+	// Reason microservice pods are rejected by the pod security level enforced on the instance
+	// (empty if none). Tenant microservices are not rolled out while set.
+	//+optional
+	PodSecurityError string `json:"podSecurityError,omitempty"`
+
+	// Image running for all tenants once rollouts complete.
+	//+optional
+	CurrentImage string `json:"currentImage,omitempty"`
+
+	// Progress of the latest image rollout.
+	//+optional
+	Rollout *RolloutStatus `json:"rollout,omitempty"`
+}
+
+// RolloutPhase is the phase of an image rollout
+// +kubebuilder:validation:Enum=Progressing;Paused;Failed;Cancelled;Completed
+type RolloutPhase string
+
+const (
+	RolloutProgressing RolloutPhase = "Progressing"
+	RolloutPaused      RolloutPhase = "Paused"
+	RolloutFailed      RolloutPhase = "Failed"
+	RolloutCancelled   RolloutPhase = "Cancelled"
+	RolloutCompleted   RolloutPhase = "Completed"
+)
+
+// RolloutStatus tracks the progress of an image rollout across tenants
+type RolloutStatus struct {
+	// Image being rolled out.
+	TargetImage string `json:"targetImage"`
+
+	// Image tenants are reverted to if a wave fails.
+	PreviousImage string `json:"previousImage"`
+
+	// Current phase of the rollout.
+	Phase RolloutPhase `json:"phase"`
+
+	// Index of the current wave (the canary wave is zero when canaries are selected).
+	Wave int32 `json:"wave"`
+
+	// Total number of waves.
+	TotalWaves int32 `json:"totalWaves"`
+
+	// Time the current wave was started.
+	//+optional
+	WaveStartedAt *metav1.Time `json:"waveStartedAt,omitempty"`
+
+	// Tenants of completed waves which run the target image.
+	//+optional
+	UpdatedTenants []string `json:"updatedTenants,omitempty"`
+
+	// Tenants upgraded by the current wave.
+	//+optional
+	WaveTenants []string `json:"waveTenants,omitempty"`
+
+	// Tenants of a failed wave which were reverted.
+	//+optional
+	FailedTenants []string `json:"failedTenants,omitempty"`
+
+	// Human-readable details about the rollout state.
+	//+optional
+	Message string `json:"message,omitempty"`
 }
 
 //+kubebuilder:object:root=true
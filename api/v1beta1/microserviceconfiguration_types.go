@@ -30,6 +30,11 @@ type MicroserviceConfigurationSpec struct {
 
 	// Instance configuration information.
 	Configuration EntityConfiguration `json:"configuration"`
+
+	// Default credentials copied to microservices created from this configuration. Later
+	// changes only apply to microservices created afterwards.
+	//+optional
+	Credentials []CredentialSpec `json:"credentials,omitempty"`
 }
 
 // MicroserviceConfigurationStatus defines the observed state of MicroserviceConfiguration
@@ -17,6 +17,7 @@
 package v1beta1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -27,10 +28,122 @@ type TenantSpec struct {
 
 	// Human-readable description displayed for tenant.
 	Description string `json:"description"`
+
+	// Id of the tenant plan which determines enabled microservices and limits.
+	//+optional
+	PlanId string `json:"planId,omitempty"`
+
+	// Functional areas of microservices enabled for the tenant (all allowed by the plan if not set).
+	//+optional
+	EnabledFunctionalAreas []string `json:"enabledFunctionalAreas,omitempty"`
+
+	// Functional areas of microservices excluded for the tenant.
+	//+optional
+	ExcludedFunctionalAreas []string `json:"excludedFunctionalAreas,omitempty"`
+
+	// Maximum aggregate resources of tenant workloads, keyed as in resource quotas (such as
+	// 'pods', 'requests.cpu' or 'limits.memory'). Overrides the budget of the tenant plan.
+	//+optional
+	ResourceBudget corev1.ResourceList `json:"resourceBudget,omitempty"`
+
+	// Scales tenant workloads to zero and routes tenant traffic to a maintenance backend.
+	//+optional
+	Suspended bool `json:"suspended,omitempty"`
+
+	// Reason the tenant is suspended (such as non-payment or incident containment).
+	//+optional
+	SuspensionReason string `json:"suspensionReason,omitempty"`
+
+	// Expiry settings for short-lived tenants such as trials and demos.
+	//+optional
+	Expiry *TenantExpirySpec `json:"expiry,omitempty"`
+}
+
+// TenantExpiryPolicy is the action taken when a tenant expires
+// +kubebuilder:validation:Enum=Suspend;Delete
+type TenantExpiryPolicy string
+
+const (
+	TenantExpirySuspend TenantExpiryPolicy = "Suspend"
+	TenantExpiryDelete  TenantExpiryPolicy = "Delete"
+)
+
+// TenantExpirySpec defines when a tenant expires and what happens when it does
+type TenantExpirySpec struct {
+	// Time at which the tenant expires.
+	//+optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+
+	// Time to live after tenant creation (used if no expiry time is set).
+	//+optional
+	TTL *metav1.Duration `json:"ttl,omitempty"`
+
+	// Period before expiry during which a warning is issued (defaults to 24h).
+	//+optional
+	WarningPeriod *metav1.Duration `json:"warningPeriod,omitempty"`
+
+	// Action taken when the tenant expires (defaults to Suspend).
+	//+optional
+	Policy TenantExpiryPolicy `json:"policy,omitempty"`
 }
 
 // TenantStatus defines the observed state of Tenant
 type TenantStatus struct {
+	// Status of generated tenant credentials.
+	//+optional
+	Credentials []CredentialStatus `json:"credentials,omitempty"`
+
+	// Time at which the tenant was suspended (not set if active).
+	//+optional
+	SuspendedAt *metav1.Time `json:"suspendedAt,omitempty"`
+
+	// Reason recorded when the tenant was suspended.
+	//+optional
+	SuspensionReason string `json:"suspensionReason,omitempty"`
+
+	// Replica counts of tenant deployments (by name) before suspension.
+	//+optional
+	SuspendedReplicas map[string]int32 `json:"suspendedReplicas,omitempty"`
+
+	// Id of the tenant plan currently applied.
+	//+optional
+	PlanId string `json:"planId,omitempty"`
+
+	// Reason the tenant plan could not be applied (empty if none).
+	//+optional
+	PlanError string `json:"planError,omitempty"`
+
+	// Aggregate resources of tenant workloads counted against the resource budget.
+	//+optional
+	ResourceUsage corev1.ResourceList `json:"resourceUsage,omitempty"`
+
+	// Tenant microservices which were not scaled as requested due to the resource budget.
+	//+optional
+	BudgetViolations []string `json:"budgetViolations,omitempty"`
+
+	// Time at which the tenant expires (not set if it does not expire).
+	//+optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+
+	// Time at which the expiry warning was issued.
+	//+optional
+	ExpiryWarnedAt *metav1.Time `json:"expiryWarnedAt,omitempty"`
+
+	// Time at which the tenant expired.
+	//+optional
+	ExpiredAt *metav1.Time `json:"expiredAt,omitempty"`
+}
+
+// CredentialStatus indicates when a generated credential was last rotated
+type CredentialStatus struct {
+	// Microservice that declared the credential.
+	MicroserviceId string `json:"microserviceId"`
+
+	// Name of the credential.
+	Name string `json:"name"`
+
+	// Time at which the credential was last generated.
+	LastRotated metav1.Time `json:"lastRotated"`
 }
 
 //+kubebuilder:object:root=true
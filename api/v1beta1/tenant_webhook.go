@@ -0,0 +1,119 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1beta1
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var tenantlog = logf.Log.WithName("tenant-resource")
+
+func (r *Tenant) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-core-devicechain-io-v1beta1-tenant,mutating=false,failurePolicy=fail,sideEffects=None,groups=core.devicechain.io,resources=tenants,verbs=create,versions=v1beta1,name=vtenant.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &Tenant{}
+
+// ValidateCreate enforces the tenant policy of the instance the tenant is created in.
+func (r *Tenant) ValidateCreate() error {
+	tenantlog.Info("validate create", "name", r.Name, "namespace", r.Namespace)
+
+	dci, err := GetInstance(InstanceGetRequest{Id: r.Namespace})
+	if err != nil {
+		// Tenants outside of an instance namespace are not subject to a policy.
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	policy := dci.Spec.TenantPolicy
+	if policy == nil {
+		return nil
+	}
+
+	if errs := validateTenantName(policy, r.Name); len(errs) > 0 {
+		return apierrors.NewInvalid(GroupVersion.WithKind("Tenant").GroupKind(), r.Name, errs)
+	}
+
+	if policy.MaxTenants != nil {
+		tenants, err := ListTenants(TenantListRequest{InstanceId: r.Namespace})
+		if err != nil {
+			return err
+		}
+		if int32(len(tenants.Items)) >= *policy.MaxTenants {
+			return apierrors.NewForbidden(GroupVersion.WithResource("tenants").GroupResource(), r.Name,
+				fmt.Errorf("instance '%s' is limited to %d tenants", r.Namespace, *policy.MaxTenants))
+		}
+	}
+	return nil
+}
+
+// ValidateUpdate allows all updates since tenant ids can not change.
+func (r *Tenant) ValidateUpdate(old runtime.Object) error {
+	return nil
+}
+
+// ValidateDelete allows all deletes.
+func (r *Tenant) ValidateDelete() error {
+	return nil
+}
+
+// Validate a tenant id against the naming rules of a tenant policy.
+func validateTenantName(policy *TenantPolicySpec, name string) field.ErrorList {
+	errs := field.ErrorList{}
+	path := field.NewPath("metadata").Child("name")
+
+	if len(policy.AllowedPrefixes) > 0 {
+		allowed := false
+		for _, prefix := range policy.AllowedPrefixes {
+			if strings.HasPrefix(name, prefix) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			errs = append(errs, field.Invalid(path, name,
+				fmt.Sprintf("must start with one of: %s", strings.Join(policy.AllowedPrefixes, ", "))))
+		}
+	}
+
+	if policy.NamePattern != "" {
+		// Patterns are validated when the instance is admitted, so one that does not compile
+		// predates the instance webhook and is ignored rather than blocking every tenant.
+		pattern, err := regexp.Compile(policy.NamePattern)
+		if err != nil {
+			tenantlog.Info("ignoring invalid tenant name pattern", "pattern", policy.NamePattern, "error", err.Error())
+		} else if !pattern.MatchString(name) {
+			errs = append(errs, field.Invalid(path, name, fmt.Sprintf("must match pattern '%s'", policy.NamePattern)))
+		}
+	}
+	return errs
+}
@@ -18,6 +18,7 @@ package v1beta1
 
 import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // TenantMicroserviceSpec defines the desired state of TenantMicroservice
@@ -30,10 +31,277 @@ type TenantMicroserviceSpec struct {
 
 	// Tenant-specific microservice configuration.
 	Configuration EntityConfiguration `json:"configuration"`
+
+	// Number of configuration revisions retained for rollback (defaults to 10).
+	//+optional
+	//+kubebuilder:validation:Minimum=1
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+
+	// Image used instead of the microservice image (managed by microservice rollouts).
+	//+optional
+	Image string `json:"image,omitempty"`
+
+	// Number of replicas when autoscaling is not enabled (defaults to one).
+	//+optional
+	//+kubebuilder:validation:Minimum=0
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// Disruption budget applied when running more than one replica.
+	//+optional
+	DisruptionBudget *DisruptionBudgetSpec `json:"disruptionBudget,omitempty"`
+
+	// Event-driven autoscaling settings (disabled if not set).
+	//+optional
+	Autoscaling *AutoscalingSpec `json:"autoscaling,omitempty"`
+
+	// Tenant-specific pod scheduling settings.
+	//+optional
+	Scheduling *SchedulingSpec `json:"scheduling,omitempty"`
+}
+
+// DisruptionBudgetSpec defines availability requirements during voluntary disruptions.
+// Only one of the fields may be set. If neither is set, at most one replica may be unavailable.
+//+kubebuilder:validation:MaxProperties=1
+type DisruptionBudgetSpec struct {
+	// Number or percentage of replicas that must remain available.
+	//+optional
+	MinAvailable *intstr.IntOrString `json:"minAvailable,omitempty"`
+
+	// Number or percentage of replicas that may be unavailable.
+	//+optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+// AutoscalingSpec defines event-driven autoscaling for a tenant microservice
+type AutoscalingSpec struct {
+	// Minimum number of replicas. Use zero to scale idle tenants to zero.
+	//+optional
+	//+kubebuilder:validation:Minimum=0
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+
+	// Maximum number of replicas.
+	//+kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"maxReplicas"`
+
+	// Interval (in seconds) at which triggers are checked.
+	//+optional
+	PollingInterval *int32 `json:"pollingInterval,omitempty"`
+
+	// Period (in seconds) to wait after the last active trigger before scaling to zero.
+	//+optional
+	CooldownPeriod *int32 `json:"cooldownPeriod,omitempty"`
+
+	// Triggers that drive scaling decisions.
+	//+kubebuilder:validation:MinItems=1
+	Triggers []AutoscalingTrigger `json:"triggers"`
+}
+
+// AutoscalingTrigger defines a single scaling trigger. Exactly one field should be set.
+type AutoscalingTrigger struct {
+	// Scale based on Kafka consumer group lag.
+	//+optional
+	Kafka *KafkaLagTrigger `json:"kafka,omitempty"`
+
+	// Scale based on the result of a Prometheus query.
+	//+optional
+	Prometheus *PrometheusTrigger `json:"prometheus,omitempty"`
+
+	// Scale to a fixed replica count within a cron schedule.
+	//+optional
+	Cron *CronTrigger `json:"cron,omitempty"`
+}
+
+// KafkaLagTrigger scales based on consumer group lag for a topic
+type KafkaLagTrigger struct {
+	// Comma-separated list of Kafka bootstrap servers.
+	BootstrapServers string `json:"bootstrapServers"`
+
+	// Consumer group used by the microservice.
+	ConsumerGroup string `json:"consumerGroup"`
+
+	// Topic to measure lag for (all topics of the group if not set).
+	//+optional
+	Topic string `json:"topic,omitempty"`
+
+	// Target lag per replica.
+	LagThreshold int64 `json:"lagThreshold"`
+
+	// Lag required to activate a deployment scaled to zero.
+	//+optional
+	ActivationLagThreshold *int64 `json:"activationLagThreshold,omitempty"`
+}
+
+// PrometheusTrigger scales based on a Prometheus query
+type PrometheusTrigger struct {
+	// Address of the Prometheus server.
+	ServerAddress string `json:"serverAddress"`
+
+	// Query which returns a single value.
+	Query string `json:"query"`
+
+	// Target value per replica.
+	Threshold string `json:"threshold"`
+
+	// Value required to activate a deployment scaled to zero.
+	//+optional
+	ActivationThreshold string `json:"activationThreshold,omitempty"`
+}
+
+// CronTrigger scales to a fixed replica count within a schedule
+type CronTrigger struct {
+	// IANA timezone used for the schedule.
+	Timezone string `json:"timezone"`
+
+	// Cron expression indicating the start of the window.
+	Start string `json:"start"`
+
+	// Cron expression indicating the end of the window.
+	End string `json:"end"`
+
+	// Number of replicas while within the window.
+	DesiredReplicas int32 `json:"desiredReplicas"`
 }
 
 // TenantMicroserviceStatus defines the observed state of TenantMicroservice
 type TenantMicroserviceStatus struct {
+	// Image requested by the microservice when last rolled out.
+	//+optional
+	Image string `json:"image,omitempty"`
+
+	// Image reference used by the deployment (pinned to a digest if resolved).
+	//+optional
+	ResolvedImage string `json:"resolvedImage,omitempty"`
+
+	// Digest the image tag resolved to.
+	//+optional
+	ImageDigest string `json:"imageDigest,omitempty"`
+
+	// Reason the image could not be rolled out (empty if none).
+	//+optional
+	ImageError string `json:"imageError,omitempty"`
+
+	// Revision number of the current configuration.
+	//+optional
+	CurrentRevision int64 `json:"currentRevision,omitempty"`
+
+	// Result of the last requested configuration rollback.
+	//+optional
+	RollbackMessage string `json:"rollbackMessage,omitempty"`
+
+	// Reason the requested replica count was limited by the tenant resource budget (empty if none).
+	//+optional
+	BudgetViolation string `json:"budgetViolation,omitempty"`
+
+	// Phase of the job provisioning the tenant (not set if none is required).
+	//+optional
+	ProvisioningPhase JobPhase `json:"provisioningPhase,omitempty"`
+
+	// Details of the provisioning job outcome.
+	//+optional
+	ProvisioningMessage string `json:"provisioningMessage,omitempty"`
+
+	// Phase of the job deprovisioning the tenant after deletion (not set if none has run).
+	//+optional
+	DeprovisioningPhase JobPhase `json:"deprovisioningPhase,omitempty"`
+
+	// Details of the deprovisioning job outcome.
+	//+optional
+	DeprovisioningMessage string `json:"deprovisioningMessage,omitempty"`
+
+	// Functional areas of dependencies and shared infrastructure components which are not yet ready.
+	//+optional
+	WaitingForDependencies []string `json:"waitingForDependencies,omitempty"`
+
+	// Dependencies which can never become ready for the tenant, such as functional areas
+	// which are not enabled for it.
+	//+optional
+	DependencyErrors []string `json:"dependencyErrors,omitempty"`
+
+	// Outcome of the latest migration run for an image change.
+	//+optional
+	Migration *MigrationStatus `json:"migration,omitempty"`
+
+	// State of Kafka topics generated for the tenant.
+	//+optional
+	Topics []TopicStatus `json:"topics,omitempty"`
+
+	// State of the database provisioned for the tenant.
+	//+optional
+	Datastore *DatastoreStatus `json:"datastore,omitempty"`
+}
+
+// DatastoreStatus indicates the observed state of a database provisioned for a tenant
+type DatastoreStatus struct {
+	// How tenant data is separated.
+	Isolation DatastoreIsolation `json:"isolation"`
+
+	// Name of the database.
+	Database string `json:"database"`
+
+	// Name of the schema holding tenant data.
+	Schema string `json:"schema"`
+
+	// Name of the role owning tenant data.
+	Username string `json:"username"`
+
+	// Name of the secret holding credentials of the role.
+	Secret string `json:"secret"`
+
+	// Progress of the provisioning job.
+	//+optional
+	Phase JobPhase `json:"phase,omitempty"`
+
+	// Reason provisioning is not complete.
+	//+optional
+	Message string `json:"message,omitempty"`
+
+	// Progress of the job dropping tenant data after deletion.
+	//+optional
+	DropPhase JobPhase `json:"dropPhase,omitempty"`
+
+	// Details of the drop job outcome.
+	//+optional
+	DropMessage string `json:"dropMessage,omitempty"`
+}
+
+// TopicStatus indicates the observed state of a Kafka topic generated for a tenant
+type TopicStatus struct {
+	// Name of the topic.
+	Name string `json:"name"`
+
+	// Indicates whether the topic is ready for use.
+	Ready bool `json:"ready"`
+
+	// Reason the topic is not ready.
+	//+optional
+	Message string `json:"message,omitempty"`
+}
+
+// MigrationStatus records the outcome of a migration run for an image change
+type MigrationStatus struct {
+	// Image being migrated from.
+	FromImage string `json:"fromImage"`
+
+	// Image being migrated to.
+	Image string `json:"image"`
+
+	// Version migrated to (the image tag or digest).
+	Version string `json:"version"`
+
+	// Phase of the migration job.
+	Phase JobPhase `json:"phase"`
+
+	// Reason the migration failed (empty if none).
+	//+optional
+	Message string `json:"message,omitempty"`
+
+	// Time the migration was started.
+	//+optional
+	StartedAt *metav1.Time `json:"startedAt,omitempty"`
+
+	// Time the migration completed.
+	//+optional
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
 }
 
 //+kubebuilder:object:root=true
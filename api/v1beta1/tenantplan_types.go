@@ -0,0 +1,109 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TenantPlanSpec defines the desired state of TenantPlan
+type TenantPlanSpec struct {
+	// Human-readable name displayed for plan.
+	Name string `json:"name"`
+
+	// Human-readable description displayed for plan.
+	Description string `json:"description"`
+
+	// Functional areas of microservices enabled for tenants on the plan (all if not set).
+	//+optional
+	FunctionalAreas []string `json:"functionalAreas,omitempty"`
+
+	// Compute resources applied to tenant microservice containers by default.
+	//+optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// Compute resources for specific functional areas (overrides the default).
+	//+optional
+	ResourceProfiles []ResourceProfileSpec `json:"resourceProfiles,omitempty"`
+
+	// Bounds applied to the replica count of tenant microservices.
+	//+optional
+	Replicas *ReplicaBoundsSpec `json:"replicas,omitempty"`
+
+	// Maximum aggregate resources of workloads for each tenant on the plan, keyed as in
+	// resource quotas (such as 'pods', 'requests.cpu' or 'limits.memory').
+	//+optional
+	ResourceBudget corev1.ResourceList `json:"resourceBudget,omitempty"`
+
+	// Application quotas (such as maximum device count) made available to tenant
+	// microservices for enforcement.
+	//+optional
+	Quotas map[string]int64 `json:"quotas,omitempty"`
+}
+
+// ResourceProfileSpec defines compute resources for microservices of a functional area
+type ResourceProfileSpec struct {
+	// Functional area the profile applies to.
+	FunctionalArea string `json:"functionalArea"`
+
+	// Compute resources applied to containers.
+	Resources corev1.ResourceRequirements `json:"resources"`
+}
+
+// ReplicaBoundsSpec limits the number of replicas of a tenant microservice
+type ReplicaBoundsSpec struct {
+	// Minimum number of replicas.
+	//+optional
+	//+kubebuilder:validation:Minimum=0
+	Min *int32 `json:"min,omitempty"`
+
+	// Maximum number of replicas.
+	//+optional
+	//+kubebuilder:validation:Minimum=1
+	Max *int32 `json:"max,omitempty"`
+}
+
+// TenantPlanStatus defines the observed state of TenantPlan
+type TenantPlanStatus struct {
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster,shortName=dctp
+//+kubebuilder:subresource:status
+
+// TenantPlan is the Schema for the tenantplans API
+type TenantPlan struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TenantPlanSpec   `json:"spec,omitempty"`
+	Status TenantPlanStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// TenantPlanList contains a list of TenantPlan
+type TenantPlanList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TenantPlan `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TenantPlan{}, &TenantPlanList{})
+}
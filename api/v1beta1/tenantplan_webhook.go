@@ -0,0 +1,74 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1beta1
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var tenantplanlog = logf.Log.WithName("tenantplan-resource")
+
+func (r *TenantPlan) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-core-devicechain-io-v1beta1-tenantplan,mutating=false,failurePolicy=fail,sideEffects=None,groups=core.devicechain.io,resources=tenantplans,verbs=delete,versions=v1beta1,name=vtenantplan.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &TenantPlan{}
+
+// ValidateCreate allows all creates.
+func (r *TenantPlan) ValidateCreate() error {
+	return nil
+}
+
+// ValidateUpdate allows all updates.
+func (r *TenantPlan) ValidateUpdate(old runtime.Object) error {
+	return nil
+}
+
+// ValidateDelete prevents deleting a plan which is referenced by tenants.
+func (r *TenantPlan) ValidateDelete() error {
+	tenantplanlog.Info("validate delete", "name", r.Name)
+
+	tenants, err := ListTenants(TenantListRequest{})
+	if err != nil {
+		return err
+	}
+	inuse := make([]string, 0)
+	for _, tenant := range tenants.Items {
+		if tenant.Spec.PlanId == r.Name || tenant.Status.PlanId == r.Name {
+			inuse = append(inuse, fmt.Sprintf("%s/%s", tenant.Namespace, tenant.Name))
+		}
+	}
+	if len(inuse) == 0 {
+		return nil
+	}
+	sort.Strings(inuse)
+	return apierrors.NewForbidden(GroupVersion.WithResource("tenantplans").GroupResource(), r.Name,
+		fmt.Errorf("plan is used by tenants: %s", strings.Join(inuse, ", ")))
+}
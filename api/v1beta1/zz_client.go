@@ -21,6 +21,9 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -34,6 +37,26 @@ import (
 const (
 	LABEL_TENANT       = "devicechain.io.tenant"
 	LABEL_MICROSERVICE = "devicechain.io.microservice"
+
+	// Requests rotation of tenant credentials ("all" or comma-separated microservice ids).
+	ANNOTATION_ROTATE_CREDENTIALS = "devicechain.io/rotate-credentials"
+
+	// Value of rotation annotation which rotates all tenant credentials.
+	ROTATE_ALL_CREDENTIALS = "all"
+
+	// Requests rollback of tenant microservice configuration to a revision ("previous" or number).
+	ANNOTATION_ROLLBACK_TO_REVISION = "devicechain.io/rollback-to-revision"
+
+	// Value of rollback annotation which restores the revision before the current one.
+	ROLLBACK_PREVIOUS_REVISION = "previous"
+
+	// Requests that failed provisioning, migration, datastore, deprovisioning and datastore drop
+	// jobs of a tenant microservice are run again.
+	ANNOTATION_RETRY_JOBS = "devicechain.io/retry-jobs"
+
+	// Releases a deleted tenant microservice whose deprovisioning or datastore drop failed
+	// without cleaning up tenant resources.
+	ANNOTATION_SKIP_FAILED_CLEANUP = "devicechain.io/skip-failed-cleanup"
 )
 
 var (
@@ -112,6 +135,8 @@ func CreateTenant(request TenantCreateRequest) (*Tenant, error) {
 		Spec: TenantSpec{
 			Name:        request.Name,
 			Description: request.Description,
+			PlanId:      request.PlanId,
+			Expiry:      request.Expiry,
 		},
 	}
 
@@ -145,6 +170,96 @@ func GetTenant(request TenantGetRequest) (*Tenant, error) {
 	return tenant, nil
 }
 
+// List tenants that match the given criteria
+func ListTenants(request TenantListRequest) (*TenantList, error) {
+	tenants := &TenantList{}
+	err := V1Beta1Client.List(context.Background(), tenants, client.InNamespace(request.InstanceId))
+	if err != nil {
+		return nil, err
+	}
+	return tenants, nil
+}
+
+// Request rotation of generated credentials for a tenant
+func RotateTenantCredentials(request TenantCredentialsRotateRequest) (*Tenant, error) {
+	tenant, err := GetTenant(TenantGetRequest{
+		InstanceId: request.InstanceId,
+		TenantId:   request.TenantId})
+	if err != nil {
+		return nil, err
+	}
+
+	value := ROTATE_ALL_CREDENTIALS
+	if len(request.MicroserviceIds) > 0 {
+		value = strings.Join(request.MicroserviceIds, ",")
+	}
+	if tenant.ObjectMeta.Annotations == nil {
+		tenant.ObjectMeta.Annotations = make(map[string]string)
+	}
+	tenant.ObjectMeta.Annotations[ANNOTATION_ROTATE_CREDENTIALS] = value
+
+	// Attempt to update the tenant.
+	err = V1Beta1Client.Update(context.Background(), tenant)
+	if err != nil {
+		return nil, err
+	}
+	return tenant, nil
+}
+
+// Suspend or resume a tenant
+func SuspendTenant(request TenantSuspendRequest) (*Tenant, error) {
+	tenant, err := GetTenant(TenantGetRequest{
+		InstanceId: request.InstanceId,
+		TenantId:   request.TenantId})
+	if err != nil {
+		return nil, err
+	}
+
+	tenant.Spec.Suspended = request.Suspended
+	tenant.Spec.SuspensionReason = ""
+	if request.Suspended {
+		tenant.Spec.SuspensionReason = request.Reason
+	}
+
+	// Attempt to update the tenant.
+	err = V1Beta1Client.Update(context.Background(), tenant)
+	if err != nil {
+		return nil, err
+	}
+	return tenant, nil
+}
+
+// Change the plan applied to a tenant
+func ChangeTenantPlan(request TenantPlanChangeRequest) (*Tenant, error) {
+	tenant, err := GetTenant(TenantGetRequest{
+		InstanceId: request.InstanceId,
+		TenantId:   request.TenantId})
+	if err != nil {
+		return nil, err
+	}
+
+	tenant.Spec.PlanId = request.PlanId
+
+	// Attempt to update the tenant.
+	err = V1Beta1Client.Update(context.Background(), tenant)
+	if err != nil {
+		return nil, err
+	}
+	return tenant, nil
+}
+
+// Get a tenant plan based on request criteria
+func GetTenantPlan(request TenantPlanGetRequest) (*TenantPlan, error) {
+	plan := &TenantPlan{}
+	err := V1Beta1Client.Get(context.Background(), client.ObjectKey{
+		Name: request.Id,
+	}, plan)
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
 // Get an microservice configuration based on request criteria
 func GetMicroserviceConfiguration(request MicroserviceConfigurationGetRequest) (*MicroserviceConfiguration, error) {
 	msconfig := &MicroserviceConfiguration{}
@@ -180,6 +295,7 @@ func CreateMicroservice(request MicroserviceCreateRequest) (*Microservice, error
 			Image:           msc.Spec.Image,
 			ImagePullPolicy: v1.PullIfNotPresent,
 			ConfigurationId: request.ConfigurationId,
+			Credentials:     msc.Spec.Credentials,
 		},
 	}
 
@@ -333,6 +449,54 @@ func DeleteTenantMicroservice(request TenantMicroserviceDeleteRequest) (*TenantM
 	return tms, nil
 }
 
+// Request rollback of tenant microservice configuration to a prior revision
+func RollbackTenantMicroservice(request TenantMicroserviceRollbackRequest) (*TenantMicroservice, error) {
+	tms, err := GetTenantMicroservice(TenantMicroserviceGetRequest{
+		InstanceId:           request.InstanceId,
+		TenantMicroserviceId: request.TenantMicroserviceId})
+	if err != nil {
+		return nil, err
+	}
+
+	value := ROLLBACK_PREVIOUS_REVISION
+	if request.Revision > 0 {
+		value = strconv.FormatInt(request.Revision, 10)
+	}
+	if tms.ObjectMeta.Annotations == nil {
+		tms.ObjectMeta.Annotations = make(map[string]string)
+	}
+	tms.ObjectMeta.Annotations[ANNOTATION_ROLLBACK_TO_REVISION] = value
+
+	// Attempt to update the tenant microservice.
+	err = V1Beta1Client.Update(context.Background(), tms)
+	if err != nil {
+		return nil, err
+	}
+	return tms, nil
+}
+
+// Request that failed jobs of a tenant microservice are run again
+func RetryTenantMicroserviceJobs(request TenantMicroserviceJobRetryRequest) (*TenantMicroservice, error) {
+	tms, err := GetTenantMicroservice(TenantMicroserviceGetRequest{
+		InstanceId:           request.InstanceId,
+		TenantMicroserviceId: request.TenantMicroserviceId})
+	if err != nil {
+		return nil, err
+	}
+
+	if tms.ObjectMeta.Annotations == nil {
+		tms.ObjectMeta.Annotations = make(map[string]string)
+	}
+	tms.ObjectMeta.Annotations[ANNOTATION_RETRY_JOBS] = time.Now().UTC().Format(time.RFC3339)
+
+	// Attempt to update the tenant microservice.
+	err = V1Beta1Client.Update(context.Background(), tms)
+	if err != nil {
+		return nil, err
+	}
+	return tms, nil
+}
+
 // Initialize client configuration
 func initClientConfig() {
 	ClientConfig = config.GetConfigOrDie()
@@ -43,6 +43,8 @@ type TenantCreateRequest struct {
 	TenantId    string
 	Name        string
 	Description string
+	PlanId      string
+	Expiry      *TenantExpirySpec
 }
 
 // Information required to get a tenant.
@@ -51,6 +53,38 @@ type TenantGetRequest struct {
 	TenantId   string
 }
 
+// Information required to list tenants.
+type TenantListRequest struct {
+	InstanceId string
+}
+
+// Information required to rotate tenant credentials.
+type TenantCredentialsRotateRequest struct {
+	InstanceId      string
+	TenantId        string
+	MicroserviceIds []string
+}
+
+// Information required to suspend or resume a tenant.
+type TenantSuspendRequest struct {
+	InstanceId string
+	TenantId   string
+	Suspended  bool
+	Reason     string
+}
+
+// Information required to change the plan of a tenant.
+type TenantPlanChangeRequest struct {
+	InstanceId string
+	TenantId   string
+	PlanId     string
+}
+
+// Information required to get a tenant plan.
+type TenantPlanGetRequest struct {
+	Id string
+}
+
 // ----------------------
 // Microservice Mangement
 // ----------------------
@@ -108,3 +142,16 @@ type TenantMicroserviceDeleteRequest struct {
 	InstanceId           string
 	TenantMicroserviceId string
 }
+
+// Information required to roll back tenant microservice configuration (previous revision if zero).
+type TenantMicroserviceRollbackRequest struct {
+	InstanceId           string
+	TenantMicroserviceId string
+	Revision             int64
+}
+
+// Information required to run failed jobs of a tenant microservice again.
+type TenantMicroserviceJobRetryRequest struct {
+	InstanceId           string
+	TenantMicroserviceId string
+}
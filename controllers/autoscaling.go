@@ -0,0 +1,234 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+// KEDA resource used to autoscale tenant microservice deployments.
+var scaledObjectGVK = schema.GroupVersionKind{
+	Group:   "keda.sh",
+	Version: "v1alpha1",
+	Kind:    "ScaledObject",
+}
+
+// Create an empty unstructured KEDA ScaledObject.
+func newScaledObject() *unstructured.Unstructured {
+	so := &unstructured.Unstructured{}
+	so.SetGroupVersionKind(scaledObjectGVK)
+	return so
+}
+
+// Generate KEDA trigger metadata for a single autoscaling trigger.
+func generateScaledObjectTrigger(trigger v1beta1.AutoscalingTrigger) (map[string]interface{}, error) {
+	switch {
+	case trigger.Kafka != nil:
+		metadata := map[string]interface{}{
+			"bootstrapServers": trigger.Kafka.BootstrapServers,
+			"consumerGroup":    trigger.Kafka.ConsumerGroup,
+			"lagThreshold":     strconv.FormatInt(trigger.Kafka.LagThreshold, 10),
+		}
+		if trigger.Kafka.Topic != "" {
+			metadata["topic"] = trigger.Kafka.Topic
+		}
+		if trigger.Kafka.ActivationLagThreshold != nil {
+			metadata["activationLagThreshold"] = strconv.FormatInt(*trigger.Kafka.ActivationLagThreshold, 10)
+		}
+		return map[string]interface{}{"type": "kafka", "metadata": metadata}, nil
+	case trigger.Prometheus != nil:
+		metadata := map[string]interface{}{
+			"serverAddress": trigger.Prometheus.ServerAddress,
+			"query":         trigger.Prometheus.Query,
+			"threshold":     trigger.Prometheus.Threshold,
+		}
+		if trigger.Prometheus.ActivationThreshold != "" {
+			metadata["activationThreshold"] = trigger.Prometheus.ActivationThreshold
+		}
+		return map[string]interface{}{"type": "prometheus", "metadata": metadata}, nil
+	case trigger.Cron != nil:
+		metadata := map[string]interface{}{
+			"timezone":        trigger.Cron.Timezone,
+			"start":           trigger.Cron.Start,
+			"end":             trigger.Cron.End,
+			"desiredReplicas": strconv.FormatInt(int64(trigger.Cron.DesiredReplicas), 10),
+		}
+		return map[string]interface{}{"type": "cron", "metadata": metadata}, nil
+	}
+	return nil, fmt.Errorf("autoscaling trigger must specify one of kafka, prometheus or cron")
+}
+
+// Generate the spec for a KEDA ScaledObject targeting the tenant microservice deployment.
+// Replica counts are limited to the bounds of the tenant plan.
+func generateScaledObjectSpec(tms *v1beta1.TenantMicroservice, plan *v1beta1.TenantPlan) (map[string]interface{}, error) {
+	as := tms.Spec.Autoscaling
+	triggers := make([]interface{}, 0)
+	for _, trigger := range as.Triggers {
+		generated, err := generateScaledObjectTrigger(trigger)
+		if err != nil {
+			return nil, err
+		}
+		triggers = append(triggers, generated)
+	}
+
+	spec := map[string]interface{}{
+		"scaleTargetRef": map[string]interface{}{
+			"name": getDeploymentName(tms).Name,
+		},
+		"maxReplicaCount": int64(clampPlanReplicas(plan, as.MaxReplicas)),
+		"triggers":        triggers,
+	}
+	if as.MinReplicas != nil {
+		spec["minReplicaCount"] = int64(clampPlanReplicas(plan, *as.MinReplicas))
+	} else if plan != nil && plan.Spec.Replicas != nil && plan.Spec.Replicas.Min != nil {
+		spec["minReplicaCount"] = int64(*plan.Spec.Replicas.Min)
+	}
+	if as.PollingInterval != nil {
+		spec["pollingInterval"] = int64(*as.PollingInterval)
+	}
+	if as.CooldownPeriod != nil {
+		spec["cooldownPeriod"] = int64(*as.CooldownPeriod)
+	}
+	return spec, nil
+}
+
+// Create, update or remove the KEDA ScaledObject based on tenant microservice autoscaling settings.
+func (r *TenantMicroserviceReconciler) reconcileScaledObject(ctx context.Context, tms *v1beta1.TenantMicroservice) error {
+	log := logf.FromContext(ctx)
+
+	soname := getDeploymentName(tms)
+	if tms.Spec.Autoscaling == nil {
+		return r.deleteScaledObject(ctx, soname)
+	}
+
+	// Autoscaling stays paused at zero replicas while the tenant is suspended or the instance
+	// is scaled down for maintenance.
+	dct := &v1beta1.Tenant{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: tms.ObjectMeta.Namespace, Name: tms.Spec.TenantId}, dct); err != nil {
+		return err
+	}
+	dci := &v1beta1.Instance{}
+	if err := r.Get(ctx, client.ObjectKey{Name: tms.ObjectMeta.Namespace}, dci); err != nil {
+		return err
+	}
+	paused := isTenantScaledDown(dct, dci)
+
+	plan, err := getAppliedTenantPlan(ctx, r.Client, dct)
+	if err != nil {
+		return err
+	}
+	spec, err := generateScaledObjectSpec(tms, plan)
+	if err != nil {
+		return err
+	}
+	if err := r.limitScaledObjectReplicas(ctx, tms, dct, plan, spec); err != nil {
+		return err
+	}
+
+	so := newScaledObject()
+	if err := r.Get(ctx, soname, so); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		so = newScaledObject()
+		so.SetName(soname.Name)
+		so.SetNamespace(soname.Namespace)
+		so.SetLabels(createDeploymentLabels(tms))
+		setScaledObjectPaused(so, paused)
+		if err := unstructured.SetNestedMap(so.Object, spec, "spec"); err != nil {
+			return err
+		}
+		if err := r.Create(ctx, so); err != nil {
+			return err
+		}
+		log.Info(fmt.Sprintf("Created scaled object for tenant microservice: %+v", soname))
+		return nil
+	}
+
+	current, _, err := unstructured.NestedMap(so.Object, "spec")
+	if err != nil {
+		return err
+	}
+	changed := setScaledObjectPaused(so, paused)
+	if !changed && equality.Semantic.DeepEqual(current, spec) {
+		return nil
+	}
+	if err := unstructured.SetNestedMap(so.Object, spec, "spec"); err != nil {
+		return err
+	}
+	return r.Update(ctx, so)
+}
+
+// Limit the replicas of a KEDA ScaledObject spec to the tenant resource budget.
+func (r *TenantMicroserviceReconciler) limitScaledObjectReplicas(ctx context.Context, tms *v1beta1.TenantMicroservice,
+	dct *v1beta1.Tenant, plan *v1beta1.TenantPlan, spec map[string]interface{}) error {
+	deploy := &appsv1.Deployment{}
+	if err := r.Get(ctx, getDeploymentName(tms), deploy); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	allowed, err := r.getAllowedReplicas(ctx, dct, plan, deploy, getDeploymentReplicas(deploy))
+	if err != nil {
+		return err
+	}
+
+	violation := ""
+	requested := spec["maxReplicaCount"].(int64)
+	if allowed >= 0 && requested > int64(allowed) {
+		violation = fmt.Sprintf("requested up to %d replicas but resource budget allows %d", requested, allowed)
+		spec["maxReplicaCount"] = int64(allowed)
+		if min, found := spec["minReplicaCount"].(int64); found && min > int64(allowed) {
+			spec["minReplicaCount"] = int64(allowed)
+		}
+	}
+	return r.setBudgetViolation(ctx, tms, violation)
+}
+
+// Delete the KEDA ScaledObject for a tenant microservice if it exists.
+func (r *TenantMicroserviceReconciler) deleteScaledObject(ctx context.Context, soname types.NamespacedName) error {
+	log := logf.FromContext(ctx)
+
+	so := newScaledObject()
+	if err := r.Get(ctx, soname, so); err != nil {
+		// Treat a cluster without KEDA installed the same as a missing object.
+		if errors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			return nil
+		}
+		return err
+	}
+	if err := r.Delete(ctx, so); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	log.Info(fmt.Sprintf("Deleted scaled object for tenant microservice: %+v", soname))
+	return nil
+}
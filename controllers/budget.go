@@ -0,0 +1,290 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+// Get the resource budget of a tenant (empty if not limited).
+func getTenantResourceBudget(tenant *v1beta1.Tenant, plan *v1beta1.TenantPlan) corev1.ResourceList {
+	if len(tenant.Spec.ResourceBudget) > 0 {
+		return tenant.Spec.ResourceBudget
+	}
+	if plan != nil {
+		return plan.Spec.ResourceBudget
+	}
+	return nil
+}
+
+// Add a quantity to a resource list entry.
+func addResourceUsage(usage corev1.ResourceList, name corev1.ResourceName, quantity resource.Quantity) {
+	total := usage[name]
+	total.Add(quantity)
+	usage[name] = total
+}
+
+// Get resources consumed by a single pod, keyed as in resource quotas.
+func getPodResourceUsage(spec *corev1.PodSpec) corev1.ResourceList {
+	usage := corev1.ResourceList{corev1.ResourcePods: *resource.NewQuantity(1, resource.DecimalSI)}
+	for _, container := range spec.Containers {
+		requests := container.Resources.Requests.DeepCopy()
+		if requests == nil {
+			requests = corev1.ResourceList{}
+		}
+		for name, quantity := range container.Resources.Limits {
+			// Requests default to limits if not set.
+			if _, found := requests[name]; !found {
+				requests[name] = quantity
+			}
+			addResourceUsage(usage, corev1.ResourceName("limits."+string(name)), quantity)
+		}
+		for name, quantity := range requests {
+			addResourceUsage(usage, name, quantity)
+			addResourceUsage(usage, corev1.ResourceName("requests."+string(name)), quantity)
+		}
+	}
+	return usage
+}
+
+// Get the replica count of a deployment.
+func getDeploymentReplicas(deploy *appsv1.Deployment) int32 {
+	if deploy.Spec.Replicas == nil {
+		return 1
+	}
+	return *deploy.Spec.Replicas
+}
+
+// Get resources consumed by all replicas of the given deployments.
+func getDeploymentsResourceUsage(deploys []appsv1.Deployment) corev1.ResourceList {
+	replicas := make(map[string]int32)
+	for i := range deploys {
+		replicas[deploys[i].ObjectMeta.Name] = getDeploymentReplicas(&deploys[i])
+	}
+	return getReservedResourceUsage(deploys, replicas)
+}
+
+// Get resources consumed by the given deployments when each runs the given number of replicas.
+func getReservedResourceUsage(deploys []appsv1.Deployment, replicas map[string]int32) corev1.ResourceList {
+	usage := corev1.ResourceList{}
+	for i := range deploys {
+		count := int64(replicas[deploys[i].ObjectMeta.Name])
+		for name, quantity := range getPodResourceUsage(&deploys[i].Spec.Template.Spec) {
+			total := resource.NewMilliQuantity(quantity.MilliValue()*count, quantity.Format)
+			addResourceUsage(usage, name, *total)
+		}
+	}
+	return usage
+}
+
+// Get the number of replicas reserved in the tenant budget for each of the given deployments.
+// Deployments reserve the most replicas they may run: the autoscaler maximum, or the count
+// recorded while scaled down for suspension or maintenance.
+func (r *TenantMicroserviceReconciler) getReservedReplicas(ctx context.Context, dct *v1beta1.Tenant,
+	deploys []appsv1.Deployment) (map[string]int32, error) {
+	reserved := make(map[string]int32)
+	reserve := func(name string, replicas int32) {
+		if replicas > reserved[name] {
+			reserved[name] = replicas
+		}
+	}
+	for i := range deploys {
+		name := deploys[i].ObjectMeta.Name
+		reserve(name, getDeploymentReplicas(&deploys[i]))
+		reserve(name, dct.Status.SuspendedReplicas[name])
+	}
+
+	dci := &v1beta1.Instance{}
+	if err := r.Get(ctx, client.ObjectKey{Name: dct.ObjectMeta.Namespace}, dci); err != nil {
+		return nil, err
+	}
+	if dci.Status.Maintenance != nil {
+		for i := range deploys {
+			name := deploys[i].ObjectMeta.Name
+			reserve(name, dci.Status.Maintenance.ScaledReplicas[name])
+		}
+	}
+
+	solist := &unstructured.UnstructuredList{}
+	solist.SetGroupVersionKind(scaledObjectGVK.GroupVersion().WithKind(scaledObjectGVK.Kind + "List"))
+	err := r.List(ctx, solist, client.InNamespace(dct.ObjectMeta.Namespace),
+		client.MatchingLabels{v1beta1.LABEL_TENANT: dct.ObjectMeta.Name})
+	if err != nil && !meta.IsNoMatchError(err) {
+		return nil, err
+	}
+	for _, so := range solist.Items {
+		if _, found := reserved[so.GetName()]; !found {
+			continue
+		}
+		if count, found, _ := unstructured.NestedInt64(so.Object, "spec", "maxReplicaCount"); found {
+			reserve(so.GetName(), int32(count))
+		}
+	}
+	return reserved, nil
+}
+
+// Get the maximum number of pods which fit within the part of a budget not yet used
+// (negative if not limited).
+func getBudgetReplicas(budget corev1.ResourceList, used corev1.ResourceList, pod corev1.ResourceList) int32 {
+	allowed := int64(-1)
+	for name, limit := range budget {
+		per, found := pod[name]
+		if !found || per.IsZero() {
+			continue
+		}
+		consumed := used[name]
+		count := (limit.MilliValue() - consumed.MilliValue()) / per.MilliValue()
+		if count < 0 {
+			count = 0
+		}
+		if allowed < 0 || count < allowed {
+			allowed = count
+		}
+	}
+	return int32(allowed)
+}
+
+// Get the maximum number of replicas a tenant microservice deployment may run within the tenant
+// resource budget, after reserving the replicas other deployments may run. Running replicas are
+// never counted as over budget. Returns a negative value if not limited.
+func (r *TenantMicroserviceReconciler) getAllowedReplicas(ctx context.Context, dct *v1beta1.Tenant,
+	plan *v1beta1.TenantPlan, deploy *appsv1.Deployment, current int32) (int32, error) {
+	budget := getTenantResourceBudget(dct, plan)
+	if len(budget) == 0 {
+		return -1, nil
+	}
+
+	deploys := &appsv1.DeploymentList{}
+	err := r.List(ctx, deploys, client.InNamespace(dct.ObjectMeta.Namespace),
+		client.MatchingLabels{v1beta1.LABEL_TENANT: dct.ObjectMeta.Name})
+	if err != nil {
+		return 0, err
+	}
+	others := make([]appsv1.Deployment, 0)
+	for _, other := range deploys.Items {
+		if other.ObjectMeta.Name != deploy.ObjectMeta.Name {
+			others = append(others, other)
+		}
+	}
+
+	reserved, err := r.getReservedReplicas(ctx, dct, others)
+	if err != nil {
+		return 0, err
+	}
+	allowed := getBudgetReplicas(budget, getReservedResourceUsage(others, reserved),
+		getPodResourceUsage(&deploy.Spec.Template.Spec))
+	if allowed >= 0 && allowed < current {
+		allowed = current
+	}
+	return allowed, nil
+}
+
+// Record the reason a tenant microservice was limited by its resource budget.
+func (r *TenantMicroserviceReconciler) setBudgetViolation(ctx context.Context, tms *v1beta1.TenantMicroservice,
+	violation string) error {
+	if tms.Status.BudgetViolation == violation {
+		return nil
+	}
+	if violation != "" {
+		log := logf.FromContext(ctx)
+		log.Info(fmt.Sprintf("Tenant microservice '%s': %s", tms.ObjectMeta.Name, violation))
+	}
+	tms.Status.BudgetViolation = violation
+	return r.Status().Update(ctx, tms)
+}
+
+// Limit the replicas of a tenant microservice deployment to the tenant resource budget.
+func (r *TenantMicroserviceReconciler) limitDeploymentReplicas(ctx context.Context, tms *v1beta1.TenantMicroservice,
+	dct *v1beta1.Tenant, plan *v1beta1.TenantPlan, deploy *appsv1.Deployment, current int32) error {
+	requested := getDeploymentReplicas(deploy)
+	allowed, err := r.getAllowedReplicas(ctx, dct, plan, deploy, current)
+	if err != nil {
+		return err
+	}
+
+	violation := ""
+	if allowed >= 0 && requested > allowed {
+		violation = fmt.Sprintf("requested %d replicas but resource budget allows %d", requested, allowed)
+		deploy.Spec.Replicas = &allowed
+	}
+	return r.setBudgetViolation(ctx, tms, violation)
+}
+
+// Update tenant status with aggregate resource usage and budget violations.
+func (r *TenantReconciler) reconcileTenantResourceUsage(ctx context.Context, tenant *v1beta1.Tenant) error {
+	deploys := &appsv1.DeploymentList{}
+	err := r.List(ctx, deploys, client.InNamespace(tenant.ObjectMeta.Namespace),
+		client.MatchingLabels{v1beta1.LABEL_TENANT: tenant.ObjectMeta.Name})
+	if err != nil {
+		return err
+	}
+	usage := getDeploymentsResourceUsage(deploys.Items)
+	if len(usage) == 0 {
+		usage = nil
+	}
+
+	tmslist := &v1beta1.TenantMicroserviceList{}
+	err = r.List(ctx, tmslist, client.InNamespace(tenant.ObjectMeta.Namespace),
+		client.MatchingLabels{v1beta1.LABEL_TENANT: tenant.ObjectMeta.Name})
+	if err != nil {
+		return err
+	}
+	violations := make([]string, 0)
+	for _, tms := range tmslist.Items {
+		if tms.Status.BudgetViolation != "" {
+			violations = append(violations, fmt.Sprintf("%s: %s", tms.ObjectMeta.Name, tms.Status.BudgetViolation))
+		}
+	}
+	sort.Strings(violations)
+	if len(violations) == 0 {
+		violations = nil
+	}
+
+	if equality.Semantic.DeepEqual(usage, tenant.Status.ResourceUsage) &&
+		equality.Semantic.DeepEqual(violations, tenant.Status.BudgetViolations) {
+		return nil
+	}
+	tenant.Status.ResourceUsage = usage
+	tenant.Status.BudgetViolations = violations
+	return r.Status().Update(ctx, tenant)
+}
+
+// Find the tenant a labeled resource belongs to.
+func (r *TenantReconciler) findTenantForLabeledObject(obj client.Object) []reconcile.Request {
+	tid, found := obj.GetLabels()[v1beta1.LABEL_TENANT]
+	if !found {
+		return nil
+	}
+	return []reconcile.Request{
+		{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: tid}},
+	}
+}
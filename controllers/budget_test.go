@@ -0,0 +1,141 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+// Create a resource list from quantity strings.
+func newResourceList(values map[string]string) corev1.ResourceList {
+	list := corev1.ResourceList{}
+	for name, value := range values {
+		list[corev1.ResourceName(name)] = resource.MustParse(value)
+	}
+	return list
+}
+
+func TestGetBudgetReplicas(t *testing.T) {
+	pod := newResourceList(map[string]string{"pods": "1", "requests.cpu": "500m", "requests.memory": "256Mi"})
+	tests := []struct {
+		name     string
+		budget   map[string]string
+		used     map[string]string
+		expected int32
+	}{
+		{"not limited", map[string]string{}, map[string]string{}, -1},
+		{"resource not used by pod", map[string]string{"limits.cpu": "1"}, map[string]string{}, -1},
+		{"pod count", map[string]string{"pods": "3"}, map[string]string{"pods": "1"}, 2},
+		{"most limiting resource", map[string]string{"pods": "10", "requests.cpu": "2", "requests.memory": "1Gi"},
+			map[string]string{"requests.cpu": "500m"}, 3},
+		{"partial pod rounds down", map[string]string{"requests.cpu": "1200m"}, map[string]string{}, 2},
+		{"over budget", map[string]string{"pods": "2"}, map[string]string{"pods": "3"}, 0},
+	}
+	for _, test := range tests {
+		allowed := getBudgetReplicas(newResourceList(test.budget), newResourceList(test.used), pod)
+		if allowed != test.expected {
+			t.Errorf("%s: expected %d, got %d", test.name, test.expected, allowed)
+		}
+	}
+}
+
+func TestGetAllowedReplicasReservesMaximums(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := v1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := appsv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	// Autoscaled deployment currently running one replica but allowed up to four.
+	so := newScaledObject()
+	so.SetName("acme-inbound")
+	so.SetNamespace("dc1")
+	so.SetLabels(map[string]string{v1beta1.LABEL_TENANT: "acme"})
+	if err := unstructured.SetNestedField(so.Object, int64(4), "spec", "maxReplicaCount"); err != nil {
+		t.Fatal(err)
+	}
+
+	tenant := &v1beta1.Tenant{
+		ObjectMeta: metav1.ObjectMeta{Name: "acme", Namespace: "dc1"},
+		Spec:       v1beta1.TenantSpec{ResourceBudget: newResourceList(map[string]string{"requests.cpu": "4"})},
+		Status: v1beta1.TenantStatus{
+			SuspendedAt:       &metav1.Time{},
+			SuspendedReplicas: map[string]int32{"acme-outbound": 2},
+		},
+	}
+	// Deployments each request half a cpu per pod.
+	objects := []client.Object{so}
+	for name, replicas := range map[string]int32{"acme-inbound": 1, "acme-outbound": 0, "acme-device": 1, "acme-command": 0} {
+		count := replicas
+		objects = append(objects, &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "dc1", Labels: map[string]string{v1beta1.LABEL_TENANT: "acme"}},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: &count,
+				Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{
+					Name:      "app",
+					Resources: corev1.ResourceRequirements{Requests: newResourceList(map[string]string{"cpu": "500m"})},
+				}}}},
+			},
+		})
+	}
+	instance := &v1beta1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "dc1"}}
+	r := &TenantMicroserviceReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(append(objects, instance)...).Build(),
+		Scheme: scheme,
+	}
+	target := &appsv1.Deployment{}
+	if err := r.Get(context.Background(), client.ObjectKey{Namespace: "dc1", Name: "acme-device"}, target); err != nil {
+		t.Fatal(err)
+	}
+
+	// Budget of 4 cpu less 2 cpu reserved by the autoscaler and 1 cpu by the suspended deployment.
+	allowed, err := r.getAllowedReplicas(context.Background(), tenant, nil, target, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed != 2 {
+		t.Errorf("expected 2 replicas allowed, got %d", allowed)
+	}
+
+	// Replicas held during instance maintenance are reserved as well, leaving no room to
+	// scale up from zero.
+	instance.Status.Maintenance = &v1beta1.MaintenanceStatus{ScaledReplicas: map[string]int32{"acme-command": 2}}
+	if err := r.Status().Update(context.Background(), instance); err != nil {
+		t.Fatal(err)
+	}
+	allowed, err = r.getAllowedReplicas(context.Background(), tenant, nil, target, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed != 0 {
+		t.Errorf("expected no replicas allowed during maintenance, got %d", allowed)
+	}
+}
@@ -0,0 +1,308 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+const (
+	// Path at which generated tenant credentials are mounted in tenant microservice pods.
+	TENANT_CREDENTIALS_MOUNT_PATH = "/etc/dct-credentials"
+
+	DEFAULT_PASSWORD_LENGTH = 32
+	DEFAULT_KEY_LENGTH      = 64
+	DEFAULT_TOKEN_LENGTH    = 32
+
+	PASSWORD_CHARACTERS = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+)
+
+// Get name of tenant credentials secret
+func getTenantCredentialsSecretName(tid string) string {
+	return fmt.Sprintf("%s-%s-%s", "dct", tid, "credentials")
+}
+
+// Get key under which a credential is stored in the tenant credentials secret.
+func getCredentialKey(ms *v1beta1.Microservice, cred v1beta1.CredentialSpec) string {
+	return fmt.Sprintf("%s.%s", ms.Spec.FunctionalArea, cred.Name)
+}
+
+// Generate a random alphanumeric password.
+func generatePassword(length int) ([]byte, error) {
+	max := big.NewInt(int64(len(PASSWORD_CHARACTERS)))
+	password := make([]byte, length)
+	for i := range password {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return nil, err
+		}
+		password[i] = PASSWORD_CHARACTERS[n.Int64()]
+	}
+	return password, nil
+}
+
+// Generate random bytes.
+func generateRandomBytes(length int) ([]byte, error) {
+	bytes := make([]byte, length)
+	if _, err := rand.Read(bytes); err != nil {
+		return nil, err
+	}
+	return bytes, nil
+}
+
+// Generate a new value for a credential.
+func generateCredential(cred v1beta1.CredentialSpec) ([]byte, error) {
+	length := int(cred.Length)
+	switch cred.Type {
+	case v1beta1.CredentialPassword:
+		if length == 0 {
+			length = DEFAULT_PASSWORD_LENGTH
+		}
+		return generatePassword(length)
+	case v1beta1.CredentialSigningKey:
+		if length == 0 {
+			length = DEFAULT_KEY_LENGTH
+		}
+		key, err := generateRandomBytes(length)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(base64.StdEncoding.EncodeToString(key)), nil
+	case v1beta1.CredentialApiToken:
+		if length == 0 {
+			length = DEFAULT_TOKEN_LENGTH
+		}
+		token, err := generateRandomBytes(length)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(hex.EncodeToString(token)), nil
+	}
+	return nil, fmt.Errorf("unknown credential type: %s", cred.Type)
+}
+
+// Get microservice ids for which rotation was requested via annotation.
+func getRequestedRotations(tenant *v1beta1.Tenant) (all bool, msids map[string]bool) {
+	msids = make(map[string]bool)
+	value, found := tenant.ObjectMeta.Annotations[v1beta1.ANNOTATION_ROTATE_CREDENTIALS]
+	if !found {
+		return false, msids
+	}
+	for _, msid := range strings.Split(value, ",") {
+		msid = strings.TrimSpace(msid)
+		if msid == v1beta1.ROTATE_ALL_CREDENTIALS {
+			all = true
+		} else if msid != "" {
+			msids[msid] = true
+		}
+	}
+	return all, msids
+}
+
+// Find the status entry for a credential.
+func findCredentialStatus(tenant *v1beta1.Tenant, msid string, name string) *v1beta1.CredentialStatus {
+	for i := range tenant.Status.Credentials {
+		status := &tenant.Status.Credentials[i]
+		if status.MicroserviceId == msid && status.Name == name {
+			return status
+		}
+	}
+	return nil
+}
+
+// Get tenant credentials secret, creating it (owned by the tenant) if not found.
+func (r *TenantReconciler) getOrCreateCredentialsSecret(ctx context.Context, tenant *v1beta1.Tenant) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, client.ObjectKey{
+		Namespace: tenant.ObjectMeta.Namespace,
+		Name:      getTenantCredentialsSecretName(tenant.ObjectMeta.Name),
+	}, secret)
+	if err == nil {
+		return secret, nil
+	}
+	if !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	secret = &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getTenantCredentialsSecretName(tenant.ObjectMeta.Name),
+			Namespace: tenant.ObjectMeta.Namespace,
+			Labels: map[string]string{
+				v1beta1.LABEL_TENANT: tenant.ObjectMeta.Name,
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{},
+	}
+	if err := controllerutil.SetControllerReference(tenant, secret, r.Scheme); err != nil {
+		return nil, err
+	}
+	if err := r.Create(ctx, secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// Generate missing credentials and rotate those which are due or requested. Returns the
+// duration after which the next scheduled rotation is due (zero if none).
+func (r *TenantReconciler) reconcileTenantCredentials(ctx context.Context, tenant *v1beta1.Tenant) (time.Duration, error) {
+	log := logf.FromContext(ctx)
+
+	mslist, err := v1beta1.ListMicroservices(v1beta1.MicroserviceListRequest{
+		InstanceId: tenant.ObjectMeta.Namespace})
+	if err != nil {
+		return 0, err
+	}
+
+	secret, err := r.getOrCreateCredentialsSecret(ctx, tenant)
+	if err != nil {
+		return 0, err
+	}
+
+	now := metav1.Now()
+	all, requested := getRequestedRotations(tenant)
+	data := make(map[string][]byte)
+	statuses := make([]v1beta1.CredentialStatus, 0)
+	rotated := make([]string, 0)
+	var next time.Duration
+
+	for i := range mslist.Items {
+		ms := &mslist.Items[i]
+		msrotated := false
+		// Credentials are declared on the microservice rather than its configuration, which
+		// only provides defaults when the microservice is created.
+		for _, cred := range ms.Spec.Credentials {
+			key := getCredentialKey(ms, cred)
+			existing, found := secret.Data[key]
+			status := findCredentialStatus(tenant, ms.ObjectMeta.Name, cred.Name)
+
+			// Determine whether credential needs to be generated.
+			generate := !found || status == nil || all || requested[ms.ObjectMeta.Name]
+			if !generate && cred.RotationInterval != nil {
+				due := status.LastRotated.Add(cred.RotationInterval.Duration)
+				if !now.Time.Before(due) {
+					generate = true
+				}
+			}
+
+			if generate {
+				value, err := generateCredential(cred)
+				if err != nil {
+					return 0, err
+				}
+				data[key] = value
+				statuses = append(statuses, v1beta1.CredentialStatus{
+					MicroserviceId: ms.ObjectMeta.Name,
+					Name:           cred.Name,
+					LastRotated:    now,
+				})
+				if found {
+					msrotated = true
+				}
+			} else {
+				data[key] = existing
+				statuses = append(statuses, *status)
+			}
+
+			// Track the next scheduled rotation.
+			if cred.RotationInterval != nil {
+				last := statuses[len(statuses)-1].LastRotated
+				until := last.Add(cred.RotationInterval.Duration).Sub(now.Time)
+				if next == 0 || until < next {
+					next = until
+				}
+			}
+		}
+		if msrotated {
+			rotated = append(rotated, ms.ObjectMeta.Name)
+		}
+	}
+
+	// Store updated credentials (removing those no longer declared).
+	if !reflect.DeepEqual(data, secret.Data) && !(len(data) == 0 && len(secret.Data) == 0) {
+		secret.Data = data
+		if err := r.Update(ctx, secret); err != nil {
+			return 0, err
+		}
+	}
+
+	// Roll deployments which use rotated credentials before the rotation is recorded, so a
+	// failed restart is retried on the next reconcile.
+	for _, msid := range rotated {
+		log.Info(fmt.Sprintf("Rotated credentials for tenant microservice (%s/%s)", tenant.ObjectMeta.Name, msid))
+		err := restartDeployments(ctx, r.Client, tenant.ObjectMeta.Namespace, map[string]string{
+			v1beta1.LABEL_TENANT:       tenant.ObjectMeta.Name,
+			v1beta1.LABEL_MICROSERVICE: msid,
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Record rotation times.
+	if !reflect.DeepEqual(statuses, tenant.Status.Credentials) &&
+		!(len(statuses) == 0 && len(tenant.Status.Credentials) == 0) {
+		tenant.Status.Credentials = statuses
+		if err := r.Status().Update(ctx, tenant); err != nil {
+			return 0, err
+		}
+	}
+
+	// Clear rotation request once handled.
+	if _, found := tenant.ObjectMeta.Annotations[v1beta1.ANNOTATION_ROTATE_CREDENTIALS]; found {
+		delete(tenant.ObjectMeta.Annotations, v1beta1.ANNOTATION_ROTATE_CREDENTIALS)
+		if err := r.Update(ctx, tenant); err != nil {
+			return 0, err
+		}
+	}
+
+	return next, nil
+}
+
+// Delete tenant credentials secret if it exists
+func (r *TenantReconciler) deleteTenantCredentials(ctx context.Context, tid string, ns string) error {
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: ns, Name: getTenantCredentialsSecretName(tid)}, secret)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if err := r.Delete(ctx, secret); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,471 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+const (
+	// Purpose of the job creating the tenant database and role.
+	JOB_DATASTORE = "datastore"
+
+	// Purpose of the job dropping the tenant database and role.
+	JOB_DATASTORE_DROP = "datastore-drop"
+
+	// Annotation recording the database names a datastore job was created for.
+	ANNOTATION_DATASTORE = "devicechain.io/datastore"
+
+	// Maximum length of PostgreSQL identifiers.
+	MAX_IDENTIFIER_LENGTH = 63
+
+	// Creates the tenant role, database and schema. Statements are idempotent so the job may
+	// run again for an existing tenant.
+	DATASTORE_PROVISION_SCRIPT = `set -e
+run() {
+  psql -v ON_ERROR_STOP=1 -v isolation="$DATASTORE_ISOLATION" -v tenant_db="$DATASTORE_DATABASE" \
+    -v tenant_schema="$DATASTORE_SCHEMA" -v tenant_user="$DATASTORE_USERNAME" \
+    -v tenant_password="$DATASTORE_PASSWORD" "$@"
+}
+run -d postgres <<'EOF'
+SELECT format('CREATE ROLE %I', :'tenant_user') WHERE NOT EXISTS (SELECT FROM pg_roles WHERE rolname = :'tenant_user') \gexec
+SELECT format('ALTER ROLE %I WITH LOGIN PASSWORD %L', :'tenant_user', :'tenant_password') \gexec
+SELECT format('CREATE DATABASE %I', :'tenant_db') WHERE NOT EXISTS (SELECT FROM pg_database WHERE datname = :'tenant_db') \gexec
+SELECT format('ALTER DATABASE %I OWNER TO %I', :'tenant_db', :'tenant_user') WHERE :'isolation' = 'Database' \gexec
+SELECT format('REVOKE ALL ON DATABASE %I FROM PUBLIC', :'tenant_db') \gexec
+SELECT format('GRANT CONNECT, TEMPORARY ON DATABASE %I TO %I', :'tenant_db', :'tenant_user') \gexec
+EOF
+run -d "$DATASTORE_DATABASE" <<'EOF'
+SELECT 'REVOKE CREATE ON SCHEMA public FROM PUBLIC' WHERE :'isolation' = 'Schema' \gexec
+SELECT format('CREATE SCHEMA IF NOT EXISTS %I', :'tenant_schema') \gexec
+SELECT format('ALTER SCHEMA %I OWNER TO %I', :'tenant_schema', :'tenant_user') \gexec
+SELECT format('ALTER ROLE %I IN DATABASE %I SET search_path TO %I', :'tenant_user', :'tenant_db', :'tenant_schema') \gexec
+EOF
+`
+
+	// Drops the tenant database (or schema) and role.
+	DATASTORE_DROP_SCRIPT = `set -e
+run() {
+  psql -v ON_ERROR_STOP=1 -v tenant_db="$DATASTORE_DATABASE" -v tenant_user="$DATASTORE_USERNAME" "$@"
+}
+if [ "$DATASTORE_ISOLATION" = "Schema" ]; then
+run -d "$DATASTORE_DATABASE" <<'EOF'
+SELECT format('DROP OWNED BY %I CASCADE', :'tenant_user') WHERE EXISTS (SELECT FROM pg_roles WHERE rolname = :'tenant_user') \gexec
+EOF
+else
+run -d postgres <<'EOF'
+SELECT format('DROP DATABASE IF EXISTS %I WITH (FORCE)', :'tenant_db') \gexec
+EOF
+fi
+run -d postgres <<'EOF'
+SELECT format('DROP ROLE IF EXISTS %I', :'tenant_user') \gexec
+EOF
+`
+)
+
+var invalidIdentifierCharacters = regexp.MustCompile(`[^a-z0-9_]`)
+
+// Get a PostgreSQL identifier from the given parts. Replacing invalid characters can map
+// different parts to the same identifier (such as 'a-b' and 'c' or 'a' and 'b-c'), so those
+// identifiers and identifiers which are too long are suffixed with a hash of the parts.
+func getDatastoreIdentifier(parts ...string) string {
+	joined := strings.Join(parts, "_")
+	id := invalidIdentifierCharacters.ReplaceAllString(strings.ToLower(joined), "_")
+	if id == joined && len(id) <= MAX_IDENTIFIER_LENGTH {
+		return id
+	}
+	hash := sha256.Sum256([]byte(strings.Join(parts, "/")))
+	suffix := fmt.Sprintf("_%x", hash[:4])
+	if len(id) > MAX_IDENTIFIER_LENGTH-len(suffix) {
+		id = id[:MAX_IDENTIFIER_LENGTH-len(suffix)]
+	}
+	return id + suffix
+}
+
+// Get namespaced name of the secret holding datastore credentials for a tenant microservice.
+func getDatastoreSecretName(tms *v1beta1.TenantMicroservice) types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: tms.ObjectMeta.Namespace,
+		Name:      fmt.Sprintf("%s-%s", tms.ObjectMeta.Name, "datastore"),
+	}
+}
+
+// Get key of datastore connection details in the tenant config map.
+func getDatastoreConfigKey(ms *v1beta1.Microservice) string {
+	return fmt.Sprintf("%s.%s", ms.Spec.FunctionalArea, "datastore")
+}
+
+// Get whether tenant data is dropped or retained with its tenant microservice.
+func getDatastoreDeletionPolicy(ds *v1beta1.DatastoreSpec) v1beta1.DeletionPolicy {
+	if ds.DeletionPolicy == "" {
+		return v1beta1.DeletionPolicyRetain
+	}
+	return ds.DeletionPolicy
+}
+
+// Get the database, schema and role provisioned for a tenant microservice. A separate database
+// is used for each tenant unless tenants share a database with a schema each.
+func getDatastoreNames(tms *v1beta1.TenantMicroservice, ds *v1beta1.DatastoreSpec) *v1beta1.DatastoreStatus {
+	names := &v1beta1.DatastoreStatus{
+		Isolation: ds.Isolation,
+		Database:  getDatastoreIdentifier(tms.Spec.TenantId, tms.Spec.MicroserviceId),
+		Schema:    "public",
+		Username:  getDatastoreIdentifier(tms.Spec.TenantId, tms.Spec.MicroserviceId),
+		Secret:    getDatastoreSecretName(tms).Name,
+	}
+	if names.Isolation == "" {
+		names.Isolation = v1beta1.DatastoreDatabase
+	}
+	if names.Isolation == v1beta1.DatastoreSchema {
+		names.Database = getDatastoreIdentifier(tms.Spec.MicroserviceId)
+		names.Schema = getDatastoreIdentifier(tms.Spec.TenantId)
+	}
+	return names
+}
+
+// Get value identifying the database names a datastore job is run for.
+func getDatastoreSignature(names *v1beta1.DatastoreStatus) string {
+	return strings.Join([]string{string(names.Isolation), names.Database, names.Schema, names.Username}, "/")
+}
+
+// Get the image datastore jobs are run with (the PostgreSQL image unless set on the microservice).
+func getDatastoreImage(ms *v1beta1.Microservice, images *v1beta1.SystemImagesSpec) string {
+	if ms.Spec.Datastore.Image != "" {
+		return ms.Spec.Datastore.Image
+	}
+	return images.PostgreSQL
+}
+
+// Generate a job which runs a datastore script against the instance PostgreSQL server using
+// its superuser credentials.
+func generateDatastoreJob(tms *v1beta1.TenantMicroservice, dct *v1beta1.Tenant, ms *v1beta1.Microservice,
+	dci *v1beta1.Instance, names *v1beta1.DatastoreStatus, image string, purpose string, script string) *batchv1.Job {
+	pg := getInfrastructureComponents(dci)[INFRASTRUCTURE_POSTGRESQL]
+	server := getInfrastructureConnection(dci.ObjectMeta.Name, INFRASTRUCTURE_POSTGRESQL, getInfrastructureProvider(pg))
+
+	env := []corev1.EnvVar{
+		{Name: "PGHOST", Value: server.Host},
+		{Name: "PGPORT", Value: fmt.Sprintf("%d", server.Port)},
+		{
+			Name: "PGUSER",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: server.CredentialsSecret},
+					Key:                  INFRASTRUCTURE_USERNAME_KEY,
+				},
+			},
+		},
+		{
+			Name: "PGPASSWORD",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: server.CredentialsSecret},
+					Key:                  INFRASTRUCTURE_PASSWORD_KEY,
+				},
+			},
+		},
+		{Name: "DATASTORE_ISOLATION", Value: string(names.Isolation)},
+		{Name: "DATASTORE_DATABASE", Value: names.Database},
+		{Name: "DATASTORE_SCHEMA", Value: names.Schema},
+		{Name: "DATASTORE_USERNAME", Value: names.Username},
+	}
+	if purpose == JOB_DATASTORE {
+		env = append(env, corev1.EnvVar{
+			Name: "DATASTORE_PASSWORD",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: names.Secret},
+					Key:                  INFRASTRUCTURE_PASSWORD_KEY,
+				},
+			},
+		})
+	}
+
+	job := generateTenantJob(tms, dct, ms, dci, &v1beta1.TenantJobSpec{
+		Image:   image,
+		Command: []string{"/bin/bash", "-c", script},
+		Env:     env,
+	}, purpose)
+	job.ObjectMeta.Annotations = map[string]string{
+		ANNOTATION_DATASTORE: getDatastoreSignature(names),
+	}
+	return job
+}
+
+// Get or create the secret holding credentials of the tenant role. Secrets of datastores which
+// are dropped with the tenant microservice are owned by it so they are garbage collected.
+func (r *TenantMicroserviceReconciler) getOrCreateDatastoreSecret(ctx context.Context, tms *v1beta1.TenantMicroservice,
+	names *v1beta1.DatastoreStatus, policy v1beta1.DeletionPolicy) (*corev1.Secret, error) {
+	sname := getDatastoreSecretName(tms)
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, sname, secret); err != nil {
+		if !errors.IsNotFound(err) {
+			return nil, err
+		}
+		password, err := generatePassword(DEFAULT_PASSWORD_LENGTH)
+		if err != nil {
+			return nil, err
+		}
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      sname.Name,
+				Namespace: sname.Namespace,
+				Labels:    createDeploymentLabels(tms),
+			},
+			Type: corev1.SecretTypeOpaque,
+			Data: map[string][]byte{
+				INFRASTRUCTURE_USERNAME_KEY: []byte(names.Username),
+				INFRASTRUCTURE_PASSWORD_KEY: password,
+			},
+		}
+		if policy == v1beta1.DeletionPolicyDelete {
+			if err := controllerutil.SetControllerReference(tms, secret, r.Scheme); err != nil {
+				return nil, err
+			}
+		}
+		if err := r.Create(ctx, secret); err != nil {
+			return nil, err
+		}
+		return secret, nil
+	}
+
+	// Keep ownership and role name in line with the current binding.
+	owned := metav1.IsControlledBy(secret, tms)
+	if owned == (policy == v1beta1.DeletionPolicyDelete) && string(secret.Data[INFRASTRUCTURE_USERNAME_KEY]) == names.Username {
+		return secret, nil
+	}
+	secret.Data[INFRASTRUCTURE_USERNAME_KEY] = []byte(names.Username)
+	secret.SetOwnerReferences(nil)
+	if policy == v1beta1.DeletionPolicyDelete {
+		if err := controllerutil.SetControllerReference(tms, secret, r.Scheme); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.Update(ctx, secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// Record the datastore state of a tenant microservice if changed.
+func (r *TenantMicroserviceReconciler) setDatastoreStatus(ctx context.Context, tms *v1beta1.TenantMicroservice,
+	status *v1beta1.DatastoreStatus) error {
+	log := logf.FromContext(ctx)
+
+	if equality.Semantic.DeepEqual(status, tms.Status.Datastore) {
+		return nil
+	}
+	if status != nil && status.Phase != "" {
+		log.Info(fmt.Sprintf("Datastore provisioning of tenant microservice '%s': %s", tms.ObjectMeta.Name, status.Phase))
+	}
+	tms.Status.Datastore = status
+	return r.Status().Update(ctx, tms)
+}
+
+// Provision the database bound to the microservice for the tenant. Returns true once the
+// database is ready or if the microservice has no datastore. A failed job is kept until a
+// retry is requested with the retry-jobs annotation.
+func (r *TenantMicroserviceReconciler) reconcileDatastore(ctx context.Context, tms *v1beta1.TenantMicroservice) (bool, error) {
+	ms, err := v1beta1.GetMicroservice(v1beta1.MicroserviceGetRequest{
+		InstanceId:     tms.ObjectMeta.Namespace,
+		MicroserviceId: tms.Spec.MicroserviceId,
+	})
+	if err != nil {
+		return false, err
+	}
+	ds := ms.Spec.Datastore
+	if ds == nil {
+		return true, r.setDatastoreStatus(ctx, tms, nil)
+	}
+
+	names := getDatastoreNames(tms, ds)
+	if status := tms.Status.Datastore; status != nil && status.Phase == v1beta1.JobSucceeded &&
+		getDatastoreSignature(status) == getDatastoreSignature(names) {
+		return true, nil
+	}
+	dci, err := v1beta1.GetInstance(v1beta1.InstanceGetRequest{Id: tms.ObjectMeta.Namespace})
+	if err != nil {
+		return false, err
+	}
+	if getInfrastructureComponents(dci)[INFRASTRUCTURE_POSTGRESQL] == nil {
+		names.Message = "instance does not declare PostgreSQL infrastructure"
+		return false, r.setDatastoreStatus(ctx, tms, names)
+	}
+	for _, component := range getUnreadyInfrastructure(dci) {
+		if component == INFRASTRUCTURE_POSTGRESQL {
+			names.Message = "waiting for PostgreSQL infrastructure to become ready"
+			return false, r.setDatastoreStatus(ctx, tms, names)
+		}
+	}
+	dct, err := v1beta1.GetTenant(v1beta1.TenantGetRequest{
+		InstanceId: tms.ObjectMeta.Namespace,
+		TenantId:   tms.Spec.TenantId,
+	})
+	if err != nil {
+		return false, err
+	}
+	if _, err := r.getOrCreateDatastoreSecret(ctx, tms, names, getDatastoreDeletionPolicy(ds)); err != nil {
+		return false, err
+	}
+
+	// Remove a job left from provisioning different names (requeued once it is gone).
+	existing := &batchv1.Job{}
+	if err := r.Get(ctx, getTenantJobName(tms, JOB_DATASTORE), existing); err != nil {
+		if !errors.IsNotFound(err) {
+			return false, err
+		}
+	} else if existing.ObjectMeta.Annotations[ANNOTATION_DATASTORE] != getDatastoreSignature(names) {
+		if existing.ObjectMeta.DeletionTimestamp.IsZero() {
+			err := r.Delete(ctx, existing, client.PropagationPolicy(metav1.DeletePropagationBackground))
+			if err != nil && !errors.IsNotFound(err) {
+				return false, err
+			}
+		}
+		return false, nil
+	}
+
+	images, err := getSystemImages(ctx, r.Client)
+	if err != nil {
+		return false, err
+	}
+	job, err := r.getOrCreateTenantJob(ctx, tms,
+		generateDatastoreJob(tms, dct, ms, dci, names, getDatastoreImage(ms, images), JOB_DATASTORE, DATASTORE_PROVISION_SCRIPT))
+	if err != nil {
+		return false, err
+	}
+	names.Phase, names.Message = getJobPhase(job)
+	if err := r.setDatastoreStatus(ctx, tms, names); err != nil {
+		return false, err
+	}
+	return names.Phase == v1beta1.JobSucceeded, nil
+}
+
+// Generate the datastore connection details exposed in the tenant configuration. The password
+// is referenced from the datastore secret so it is only written to the tenant secret. Returns
+// nil if no datastore has been provisioned.
+func generateDatastoreConfiguration(tms *v1beta1.TenantMicroservice, ms *v1beta1.Microservice,
+	dci *v1beta1.Instance) (json.RawMessage, error) {
+	status := tms.Status.Datastore
+	pg := getInfrastructureComponents(dci)[INFRASTRUCTURE_POSTGRESQL]
+	if ms.Spec.Datastore == nil || status == nil || status.Phase != v1beta1.JobSucceeded || pg == nil {
+		return nil, nil
+	}
+	server := getInfrastructureConnection(dci.ObjectMeta.Name, INFRASTRUCTURE_POSTGRESQL, getInfrastructureProvider(pg))
+	return json.Marshal(map[string]interface{}{
+		"host":     server.Host,
+		"port":     server.Port,
+		"database": status.Database,
+		"schema":   status.Schema,
+		"username": status.Username,
+		"password": map[string]interface{}{
+			SECRET_REF_KEY: SecretReference{Name: status.Secret, Key: INFRASTRUCTURE_PASSWORD_KEY},
+		},
+	})
+}
+
+// Indicates whether tenant data must be dropped when a tenant microservice is deleted.
+func isDatastoreDropRequired(ms *v1beta1.Microservice) bool {
+	return ms.Spec.Datastore != nil && getDatastoreDeletionPolicy(ms.Spec.Datastore) == v1beta1.DeletionPolicyDelete
+}
+
+// Drop the database of a deleted tenant microservice if required by the deletion policy.
+// Returns true once the job has succeeded or if there is nothing to drop. A failed job keeps
+// the tenant microservice until it is retried or skipped.
+func (r *TenantMicroserviceReconciler) runDatastoreDropJob(ctx context.Context,
+	tms *v1beta1.TenantMicroservice) (bool, error) {
+	log := logf.FromContext(ctx)
+
+	ms, err := v1beta1.GetMicroservice(v1beta1.MicroserviceGetRequest{
+		InstanceId:     tms.ObjectMeta.Namespace,
+		MicroserviceId: tms.Spec.MicroserviceId,
+	})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	names := tms.Status.Datastore
+	if !isDatastoreDropRequired(ms) || names == nil || names.Phase == "" {
+		return true, nil
+	}
+	dci, err := v1beta1.GetInstance(v1beta1.InstanceGetRequest{Id: tms.ObjectMeta.Namespace})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	if getInfrastructureComponents(dci)[INFRASTRUCTURE_POSTGRESQL] == nil {
+		return true, nil
+	}
+	dct, err := v1beta1.GetTenant(v1beta1.TenantGetRequest{
+		InstanceId: tms.ObjectMeta.Namespace,
+		TenantId:   tms.Spec.TenantId,
+	})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return false, err
+		}
+		dct = nil
+	}
+
+	// Stop tenant workloads before their data is removed.
+	if err := r.deleteTenantMicroserviceWorkloads(ctx, getDeploymentName(tms)); err != nil {
+		return false, err
+	}
+
+	images, err := getSystemImages(ctx, r.Client)
+	if err != nil {
+		return false, err
+	}
+	job, err := r.getOrCreateTenantJob(ctx, tms,
+		generateDatastoreJob(tms, dct, ms, dci, names, getDatastoreImage(ms, images), JOB_DATASTORE_DROP, DATASTORE_DROP_SCRIPT))
+	if err != nil {
+		return false, err
+	}
+	phase, message := getJobPhase(job)
+	if names.DropPhase != phase || names.DropMessage != message {
+		log.Info(fmt.Sprintf("Dropping datastore of tenant microservice '%s': %s", tms.ObjectMeta.Name, phase))
+		names.DropPhase = phase
+		names.DropMessage = message
+		if err := r.Status().Update(ctx, tms); err != nil {
+			return false, err
+		}
+	}
+	if phase == v1beta1.JobFailed {
+		return isFailedCleanupSkipped(ctx, tms), nil
+	}
+	return phase == v1beta1.JobSucceeded, nil
+}
@@ -0,0 +1,116 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+var validIdentifier = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+func TestGetDatastoreIdentifier(t *testing.T) {
+	long := strings.Repeat("a", 60)
+	tests := []struct {
+		name     string
+		parts    []string
+		expected string
+		prefix   string
+	}{
+		{"unchanged", []string{"acme", "storage"}, "acme_storage", ""},
+		{"single part", []string{"acme"}, "acme", ""},
+		{"max length", []string{strings.Repeat("a", MAX_IDENTIFIER_LENGTH)}, strings.Repeat("a", MAX_IDENTIFIER_LENGTH), ""},
+		{"replaced characters", []string{"acme-corp", "device-management"}, "", "acme_corp_device_management_"},
+		{"truncated", []string{long, long}, "", long[:MAX_IDENTIFIER_LENGTH-9] + "_"},
+	}
+	for _, test := range tests {
+		id := getDatastoreIdentifier(test.parts...)
+		if len(id) > MAX_IDENTIFIER_LENGTH {
+			t.Errorf("%s: identifier '%s' exceeds %d characters", test.name, id, MAX_IDENTIFIER_LENGTH)
+		}
+		if !validIdentifier.MatchString(id) {
+			t.Errorf("%s: identifier '%s' contains invalid characters", test.name, id)
+		}
+		if test.expected != "" && id != test.expected {
+			t.Errorf("%s: expected '%s', got '%s'", test.name, test.expected, id)
+		}
+		if test.prefix != "" && (!strings.HasPrefix(id, test.prefix) || len(id) != len(test.prefix)+8) {
+			t.Errorf("%s: expected '%s' with hash suffix, got '%s'", test.name, test.prefix, id)
+		}
+	}
+}
+
+func TestGetDatastoreIdentifierCollisions(t *testing.T) {
+	long := strings.Repeat("a", 70)
+	tests := []struct {
+		name  string
+		left  []string
+		right []string
+	}{
+		{"hyphen moved between parts", []string{"a-b", "c"}, []string{"a", "b-c"}},
+		{"hyphen and separator", []string{"a-b"}, []string{"a", "b"}},
+		{"dot and hyphen", []string{"a.b"}, []string{"a-b"}},
+		{"truncated suffix", []string{long, "one"}, []string{long, "two"}},
+	}
+	for _, test := range tests {
+		left := getDatastoreIdentifier(test.left...)
+		right := getDatastoreIdentifier(test.right...)
+		if left == right {
+			t.Errorf("%s: %v and %v both map to '%s'", test.name, test.left, test.right, left)
+		}
+	}
+}
+
+func TestGetDatastoreNames(t *testing.T) {
+	acme := &v1beta1.TenantMicroservice{
+		ObjectMeta: metav1.ObjectMeta{Name: "acme-storage", Namespace: "dc1"},
+		Spec:       v1beta1.TenantMicroserviceSpec{TenantId: "acme", MicroserviceId: "storage"},
+	}
+
+	// Tenants get their own database unless schema isolation is requested.
+	names := getDatastoreNames(acme, &v1beta1.DatastoreSpec{})
+	expected := v1beta1.DatastoreStatus{Isolation: v1beta1.DatastoreDatabase, Database: "acme_storage",
+		Schema: "public", Username: "acme_storage", Secret: "acme-storage-datastore"}
+	if *names != expected {
+		t.Errorf("expected %+v, got %+v", expected, *names)
+	}
+	names = getDatastoreNames(acme, &v1beta1.DatastoreSpec{Isolation: v1beta1.DatastoreSchema})
+	expected = v1beta1.DatastoreStatus{Isolation: v1beta1.DatastoreSchema, Database: "storage",
+		Schema: "acme", Username: "acme_storage", Secret: "acme-storage-datastore"}
+	if *names != expected {
+		t.Errorf("expected %+v, got %+v", expected, *names)
+	}
+
+	// Hyphens in tenant and microservice ids must not let two tenants share a role or schema.
+	left := &v1beta1.TenantMicroservice{Spec: v1beta1.TenantMicroserviceSpec{TenantId: "a-b", MicroserviceId: "c"}}
+	right := &v1beta1.TenantMicroservice{Spec: v1beta1.TenantMicroserviceSpec{TenantId: "a", MicroserviceId: "b-c"}}
+	for _, isolation := range []v1beta1.DatastoreIsolation{v1beta1.DatastoreDatabase, v1beta1.DatastoreSchema} {
+		ds := &v1beta1.DatastoreSpec{Isolation: isolation}
+		first, second := getDatastoreNames(left, ds), getDatastoreNames(right, ds)
+		if first.Username == second.Username {
+			t.Errorf("isolation '%s': tenants share role '%s'", isolation, first.Username)
+		}
+		if first.Database == second.Database && first.Schema == second.Schema {
+			t.Errorf("isolation '%s': tenants share '%s.%s'", isolation, first.Database, first.Schema)
+		}
+	}
+}
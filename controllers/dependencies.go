@@ -0,0 +1,286 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+// Build the dependency graph of microservices, assigning each a startup stage. Also returns
+// problems such as dependencies on missing functional areas and dependency cycles.
+func getDependencyGraph(mslist []v1beta1.Microservice) ([]v1beta1.MicroserviceDependencies, []string) {
+	byarea := make(map[string]*v1beta1.Microservice)
+	for i := range mslist {
+		byarea[mslist[i].Spec.FunctionalArea] = &mslist[i]
+	}
+
+	// Stage is one more than the latest stage of any dependency. Microservices in or
+	// depending on a cycle have no stage.
+	stages := make(map[string]int32)
+	cyclic := make(map[string]bool)
+	visiting := make(map[string]bool)
+	var visit func(area string) bool
+	visit = func(area string) bool {
+		if _, found := stages[area]; found {
+			return true
+		}
+		if cyclic[area] || visiting[area] {
+			return false
+		}
+		ms, found := byarea[area]
+		if !found {
+			return true
+		}
+		visiting[area] = true
+		stage := int32(0)
+		ok := true
+		for _, dep := range ms.Spec.Dependencies {
+			if !visit(dep) {
+				ok = false
+			} else if depstage, found := stages[dep]; found && depstage >= stage {
+				stage = depstage + 1
+			}
+		}
+		visiting[area] = false
+		if ok {
+			stages[area] = stage
+		} else {
+			cyclic[area] = true
+		}
+		return ok
+	}
+
+	graph := make([]v1beta1.MicroserviceDependencies, 0)
+	problems := make([]string, 0)
+	for _, ms := range mslist {
+		area := ms.Spec.FunctionalArea
+		entry := v1beta1.MicroserviceDependencies{
+			MicroserviceId: ms.ObjectMeta.Name,
+			FunctionalArea: area,
+			DependsOn:      ms.Spec.Dependencies,
+		}
+		for _, dep := range ms.Spec.Dependencies {
+			if _, found := byarea[dep]; !found {
+				problems = append(problems, fmt.Sprintf("microservice '%s' depends on missing functional area '%s'",
+					ms.ObjectMeta.Name, dep))
+			}
+		}
+		if visit(area) {
+			stage := stages[area]
+			entry.Stage = &stage
+		} else {
+			problems = append(problems, fmt.Sprintf("microservice '%s' is part of or depends on a dependency cycle",
+				ms.ObjectMeta.Name))
+		}
+		graph = append(graph, entry)
+	}
+
+	sort.SliceStable(graph, func(i, j int) bool {
+		si, sj := graph[i].Stage, graph[j].Stage
+		if si == nil || sj == nil {
+			return si != nil && sj == nil
+		}
+		if *si != *sj {
+			return *si < *sj
+		}
+		return graph[i].MicroserviceId < graph[j].MicroserviceId
+	})
+	sort.Strings(problems)
+	return graph, problems
+}
+
+// Update instance status with the dependency graph of its microservices.
+func (r *InstanceReconciler) reconcileDependencyGraph(ctx context.Context, dci *v1beta1.Instance) error {
+	log := logf.FromContext(ctx)
+
+	mslist := &v1beta1.MicroserviceList{}
+	if err := r.List(ctx, mslist, client.InNamespace(dci.ObjectMeta.Name)); err != nil {
+		return err
+	}
+	sort.Slice(mslist.Items, func(i, j int) bool {
+		return mslist.Items[i].ObjectMeta.Name < mslist.Items[j].ObjectMeta.Name
+	})
+	graph, problems := getDependencyGraph(mslist.Items)
+	if len(graph) == 0 {
+		graph = nil
+	}
+	if len(problems) == 0 {
+		problems = nil
+	}
+
+	if equality.Semantic.DeepEqual(graph, dci.Status.DependencyGraph) &&
+		equality.Semantic.DeepEqual(problems, dci.Status.DependencyErrors) {
+		return nil
+	}
+	for _, problem := range problems {
+		log.Info(fmt.Sprintf("Instance '%s': %s", dci.ObjectMeta.Name, problem))
+	}
+	dci.Status.DependencyGraph = graph
+	dci.Status.DependencyErrors = problems
+	return r.Status().Update(ctx, dci)
+}
+
+// Indicates whether the deployment of a dependency is ready. Deployments scaled to zero
+// (by suspension, maintenance or autoscaling) are considered ready.
+func isDependencyDeploymentReady(deploy *appsv1.Deployment) bool {
+	if deploy.Status.ObservedGeneration < deploy.ObjectMeta.Generation {
+		return false
+	}
+	return deploy.Status.AvailableReplicas > 0 || getDeploymentReplicas(deploy) == 0
+}
+
+// Indicates whether the deployment of a microservice is ready for the tenant of a tenant microservice.
+func (r *TenantMicroserviceReconciler) isDependencyReady(ctx context.Context, tms *v1beta1.TenantMicroservice,
+	msid string) (bool, error) {
+	tmslist := &v1beta1.TenantMicroserviceList{}
+	err := r.List(ctx, tmslist, client.InNamespace(tms.ObjectMeta.Namespace),
+		client.MatchingLabels{v1beta1.LABEL_TENANT: tms.Spec.TenantId, v1beta1.LABEL_MICROSERVICE: msid})
+	if err != nil || len(tmslist.Items) == 0 {
+		return false, err
+	}
+
+	deploy := &appsv1.Deployment{}
+	if err := r.Get(ctx, getDeploymentName(&tmslist.Items[0]), deploy); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return isDependencyDeploymentReady(deploy), nil
+}
+
+// Get functional areas the microservice of a tenant microservice depends on which are not yet
+// ready for the tenant. Shared instance infrastructure which is not ready is included as
+// 'infrastructure/<component>'. Also returns dependencies which will never become ready because
+// their functional area is not enabled for the tenant.
+func (r *TenantMicroserviceReconciler) getUnreadyDependencies(ctx context.Context, tms *v1beta1.TenantMicroservice,
+	ms *v1beta1.Microservice) ([]string, []string, error) {
+	dci := &v1beta1.Instance{}
+	if err := r.Get(ctx, client.ObjectKey{Name: tms.ObjectMeta.Namespace}, dci); err != nil {
+		return nil, nil, err
+	}
+	waiting := make([]string, 0)
+	problems := make([]string, 0)
+	for _, component := range getUnreadyInfrastructure(dci) {
+		waiting = append(waiting, fmt.Sprintf("%s/%s", INFRASTRUCTURE_CONFIG_NAME, component))
+	}
+
+	if len(ms.Spec.Dependencies) > 0 {
+		dct := &v1beta1.Tenant{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: tms.ObjectMeta.Namespace, Name: tms.Spec.TenantId}, dct); err != nil {
+			return nil, nil, err
+		}
+		plan, err := getAppliedTenantPlan(ctx, r.Client, dct)
+		if err != nil {
+			return nil, nil, err
+		}
+		mslist, err := v1beta1.ListMicroservices(v1beta1.MicroserviceListRequest{InstanceId: tms.ObjectMeta.Namespace})
+		if err != nil {
+			return nil, nil, err
+		}
+		byarea := make(map[string]string)
+		for _, other := range mslist.Items {
+			byarea[other.Spec.FunctionalArea] = other.ObjectMeta.Name
+		}
+
+		for _, area := range ms.Spec.Dependencies {
+			if !isFunctionalAreaEnabled(dct, plan, area) {
+				problems = append(problems, fmt.Sprintf("functional area '%s' is not enabled for tenant '%s'",
+					area, tms.Spec.TenantId))
+				continue
+			}
+			msid, found := byarea[area]
+			if !found {
+				waiting = append(waiting, area)
+				continue
+			}
+			ready, err := r.isDependencyReady(ctx, tms, msid)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !ready {
+				waiting = append(waiting, area)
+			}
+		}
+	}
+	if len(waiting) == 0 {
+		waiting = nil
+	}
+	if len(problems) == 0 {
+		problems = nil
+	}
+	return waiting, problems, nil
+}
+
+// Record dependencies a tenant microservice is waiting for. Returns true once all dependencies
+// are ready for the tenant.
+func (r *TenantMicroserviceReconciler) reconcileDependencies(ctx context.Context, tms *v1beta1.TenantMicroservice,
+	ms *v1beta1.Microservice) (bool, error) {
+	log := logf.FromContext(ctx)
+
+	waiting, problems, err := r.getUnreadyDependencies(ctx, tms, ms)
+	if err != nil {
+		return false, err
+	}
+	if !equality.Semantic.DeepEqual(waiting, tms.Status.WaitingForDependencies) ||
+		!equality.Semantic.DeepEqual(problems, tms.Status.DependencyErrors) {
+		if len(waiting) > 0 {
+			log.Info(fmt.Sprintf("Tenant microservice '%s' waiting for dependencies: %v", tms.ObjectMeta.Name, waiting))
+		}
+		for _, problem := range problems {
+			log.Info(fmt.Sprintf("Tenant microservice '%s' can not be deployed: %s", tms.ObjectMeta.Name, problem))
+		}
+		tms.Status.WaitingForDependencies = waiting
+		tms.Status.DependencyErrors = problems
+		if err := r.Status().Update(ctx, tms); err != nil {
+			return false, err
+		}
+	}
+	return len(waiting) == 0 && len(problems) == 0, nil
+}
+
+// Find tenant microservices of a tenant waiting for dependencies affected by a change to a deployment.
+func (r *TenantMicroserviceReconciler) findTenantMicroservicesForDependency(obj client.Object) []reconcile.Request {
+	tid, found := obj.GetLabels()[v1beta1.LABEL_TENANT]
+	if !found {
+		return nil
+	}
+	tmslist := &v1beta1.TenantMicroserviceList{}
+	err := r.List(context.Background(), tmslist, client.InNamespace(obj.GetNamespace()),
+		client.MatchingLabels{v1beta1.LABEL_TENANT: tid})
+	if err != nil {
+		return nil
+	}
+	waiting := &v1beta1.TenantMicroserviceList{}
+	for _, tms := range tmslist.Items {
+		if len(tms.Status.WaitingForDependencies) > 0 {
+			waiting.Items = append(waiting.Items, tms)
+		}
+	}
+	return createTenantMicroserviceRequests(waiting)
+}
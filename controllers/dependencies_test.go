@@ -0,0 +1,105 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+func TestGetDependencyGraph(t *testing.T) {
+	mslist := []v1beta1.Microservice{
+		{ObjectMeta: metav1.ObjectMeta{Name: "event-processing"},
+			Spec: v1beta1.MicroserviceSpec{FunctionalArea: "event-processing", Dependencies: []string{"event-sources"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "event-sources"},
+			Spec: v1beta1.MicroserviceSpec{FunctionalArea: "event-sources", Dependencies: []string{"device-management"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "device-management"},
+			Spec: v1beta1.MicroserviceSpec{FunctionalArea: "device-management"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "outbound"},
+			Spec: v1beta1.MicroserviceSpec{FunctionalArea: "outbound", Dependencies: []string{"outbound"}}},
+	}
+	graph, problems := getDependencyGraph(mslist)
+
+	stages := make(map[string]int32)
+	for _, entry := range graph {
+		if entry.Stage != nil {
+			stages[entry.MicroserviceId] = *entry.Stage
+		}
+	}
+	expected := map[string]int32{"device-management": 0, "event-sources": 1, "event-processing": 2}
+	if !reflect.DeepEqual(stages, expected) {
+		t.Errorf("expected stages %v, got %v", expected, stages)
+	}
+	if len(problems) != 1 || problems[0] != "microservice 'outbound' is part of or depends on a dependency cycle" {
+		t.Errorf("expected self dependency to be reported as a cycle, got %v", problems)
+	}
+}
+
+func TestGetUnreadyDependenciesReportsDisabledAreas(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := v1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	ms := &v1beta1.Microservice{
+		ObjectMeta: metav1.ObjectMeta{Name: "event-processing", Namespace: "dc1"},
+		Spec: v1beta1.MicroserviceSpec{FunctionalArea: "event-processing",
+			Dependencies: []string{"device-management", "event-sources"}},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&v1beta1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "dc1"}},
+		&v1beta1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: "acme", Namespace: "dc1"},
+			Spec:       v1beta1.TenantSpec{ExcludedFunctionalAreas: []string{"event-sources"}},
+		},
+		ms,
+		&v1beta1.Microservice{
+			ObjectMeta: metav1.ObjectMeta{Name: "device-management", Namespace: "dc1"},
+			Spec:       v1beta1.MicroserviceSpec{FunctionalArea: "device-management"},
+		},
+	).Build()
+
+	// Microservices are listed through the shared API client.
+	previous := v1beta1.V1Beta1Client
+	v1beta1.V1Beta1Client = c
+	defer func() { v1beta1.V1Beta1Client = previous }()
+
+	r := &TenantMicroserviceReconciler{Client: c, Scheme: scheme}
+	tms := &v1beta1.TenantMicroservice{
+		ObjectMeta: metav1.ObjectMeta{Name: "acme-event-processing", Namespace: "dc1"},
+		Spec:       v1beta1.TenantMicroserviceSpec{TenantId: "acme", MicroserviceId: "event-processing"},
+	}
+	waiting, problems, err := r.getUnreadyDependencies(context.Background(), tms, ms)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The excluded area can never become ready, so it is reported rather than waited for.
+	if !reflect.DeepEqual(waiting, []string{"device-management"}) {
+		t.Errorf("expected to wait for device-management only, got %v", waiting)
+	}
+	expected := []string{"functional area 'event-sources' is not enabled for tenant 'acme'"}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("expected problems %v, got %v", expected, problems)
+	}
+}
@@ -0,0 +1,135 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+// Get the maximum number of replicas a tenant microservice may run within the bounds of its plan.
+func getMaximumReplicas(tms *v1beta1.TenantMicroservice, plan *v1beta1.TenantPlan) int32 {
+	if tms.Spec.Autoscaling != nil {
+		return clampPlanReplicas(plan, tms.Spec.Autoscaling.MaxReplicas)
+	}
+	if replicas := getPlanReplicas(plan, tms.Spec.Replicas); replicas != nil {
+		return *replicas
+	}
+	return 1
+}
+
+// Generate a pod disruption budget for tenant microservice pods.
+func generatePodDisruptionBudget(tms *v1beta1.TenantMicroservice) *policyv1.PodDisruptionBudget {
+	pdbname := getDeploymentName(tms)
+	labels := createDeploymentLabels(tms)
+
+	pdb := &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pdbname.Name,
+			Namespace: pdbname.Namespace,
+			Labels:    labels,
+		},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+		},
+	}
+
+	// Only one field is admitted by the CRD, but MinAvailable wins for older resources.
+	budget := tms.Spec.DisruptionBudget
+	if budget != nil && budget.MinAvailable != nil {
+		pdb.Spec.MinAvailable = budget.MinAvailable
+	} else if budget != nil && budget.MaxUnavailable != nil {
+		pdb.Spec.MaxUnavailable = budget.MaxUnavailable
+	} else {
+		maxunavailable := intstr.FromInt(1)
+		pdb.Spec.MaxUnavailable = &maxunavailable
+	}
+	return pdb
+}
+
+// Create, update or remove the pod disruption budget based on tenant microservice replicas.
+func (r *TenantMicroserviceReconciler) reconcilePodDisruptionBudget(ctx context.Context, tms *v1beta1.TenantMicroservice) error {
+	log := logf.FromContext(ctx)
+
+	dct := &v1beta1.Tenant{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: tms.ObjectMeta.Namespace, Name: tms.Spec.TenantId}, dct); err != nil {
+		return err
+	}
+	plan, err := getAppliedTenantPlan(ctx, r.Client, dct)
+	if err != nil {
+		return err
+	}
+
+	pdbname := getDeploymentName(tms)
+	if getMaximumReplicas(tms, plan) <= 1 {
+		return r.deletePodDisruptionBudget(ctx, pdbname)
+	}
+
+	updated := generatePodDisruptionBudget(tms)
+	pdb := &policyv1.PodDisruptionBudget{}
+	if err := r.Get(ctx, pdbname, pdb); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		if err := r.Create(ctx, updated); err != nil {
+			return err
+		}
+		log.Info(fmt.Sprintf("Created pod disruption budget for tenant microservice: %+v", pdbname))
+		return nil
+	}
+
+	if equality.Semantic.DeepEqual(updated.Spec.MinAvailable, pdb.Spec.MinAvailable) &&
+		equality.Semantic.DeepEqual(updated.Spec.MaxUnavailable, pdb.Spec.MaxUnavailable) &&
+		equality.Semantic.DeepEqual(updated.Spec.Selector, pdb.Spec.Selector) {
+		return nil
+	}
+	pdb.Spec.MinAvailable = updated.Spec.MinAvailable
+	pdb.Spec.MaxUnavailable = updated.Spec.MaxUnavailable
+	pdb.Spec.Selector = updated.Spec.Selector
+	return r.Update(ctx, pdb)
+}
+
+// Delete the pod disruption budget for a tenant microservice if it exists.
+func (r *TenantMicroserviceReconciler) deletePodDisruptionBudget(ctx context.Context, pdbname types.NamespacedName) error {
+	log := logf.FromContext(ctx)
+
+	pdb := &policyv1.PodDisruptionBudget{}
+	if err := r.Get(ctx, pdbname, pdb); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if err := r.Delete(ctx, pdb); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	log.Info(fmt.Sprintf("Deleted pod disruption budget for tenant microservice: %+v", pdbname))
+	return nil
+}
@@ -0,0 +1,140 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+const (
+	// Period before expiry during which a warning is issued if not specified.
+	DEFAULT_EXPIRY_WARNING_PERIOD = 24 * time.Hour
+
+	// Reason of event issued when a tenant is about to expire.
+	EVENT_TENANT_EXPIRING = "TenantExpiring"
+
+	// Reason of event issued when a tenant has expired.
+	EVENT_TENANT_EXPIRED = "TenantExpired"
+)
+
+// Get the time at which a tenant expires (nil if it does not expire).
+func getTenantExpiry(tenant *v1beta1.Tenant) *metav1.Time {
+	expiry := tenant.Spec.Expiry
+	if expiry == nil {
+		return nil
+	}
+	if expiry.ExpiresAt != nil {
+		return expiry.ExpiresAt.DeepCopy()
+	}
+	if expiry.TTL != nil {
+		expires := metav1.NewTime(tenant.ObjectMeta.CreationTimestamp.Add(expiry.TTL.Duration).Truncate(time.Second))
+		return &expires
+	}
+	return nil
+}
+
+// Get the period before expiry during which a warning is issued.
+func getExpiryWarningPeriod(expiry *v1beta1.TenantExpirySpec) time.Duration {
+	if expiry.WarningPeriod != nil {
+		return expiry.WarningPeriod.Duration
+	}
+	return DEFAULT_EXPIRY_WARNING_PERIOD
+}
+
+// Get the action taken when a tenant expires.
+func getExpiryPolicy(expiry *v1beta1.TenantExpirySpec) v1beta1.TenantExpiryPolicy {
+	if expiry.Policy == "" {
+		return v1beta1.TenantExpirySuspend
+	}
+	return expiry.Policy
+}
+
+// Suspend or delete an expired tenant according to its expiry policy. Returns true if deleted.
+func (r *TenantReconciler) expireTenant(ctx context.Context, tenant *v1beta1.Tenant) (bool, error) {
+	log := logf.FromContext(ctx)
+
+	policy := getExpiryPolicy(tenant.Spec.Expiry)
+	r.Recorder.Eventf(tenant, corev1.EventTypeWarning, EVENT_TENANT_EXPIRED,
+		"Tenant expired (policy %s)", policy)
+	log.Info(fmt.Sprintf("Tenant '%s' expired (policy %s)", tenant.ObjectMeta.Name, policy))
+
+	if policy == v1beta1.TenantExpiryDelete {
+		return true, r.Delete(ctx, tenant)
+	}
+	if tenant.Spec.Suspended {
+		return false, nil
+	}
+	tenant.Spec.Suspended = true
+	tenant.Spec.SuspensionReason = "tenant expired"
+	return false, r.Update(ctx, tenant)
+}
+
+// Warn about a tenant approaching expiry and expire it once due. Returns the time until the
+// next expiry action (zero if none) and true if the tenant was deleted. Expired tenants are
+// only expired once so they may be resumed manually or reactivated by extending the expiry.
+func (r *TenantReconciler) reconcileTenantExpiry(ctx context.Context, tenant *v1beta1.Tenant) (time.Duration, bool, error) {
+	log := logf.FromContext(ctx)
+
+	now := metav1.Now()
+	expires := getTenantExpiry(tenant)
+	status := tenant.Status.DeepCopy()
+	status.ExpiresAt = expires
+	var next time.Duration
+
+	if expires == nil {
+		status.ExpiryWarnedAt = nil
+		status.ExpiredAt = nil
+	} else if now.Before(expires) {
+		status.ExpiredAt = nil
+		warnat := expires.Add(-getExpiryWarningPeriod(tenant.Spec.Expiry))
+		if now.Time.Before(warnat) {
+			status.ExpiryWarnedAt = nil
+			next = warnat.Sub(now.Time)
+		} else {
+			if status.ExpiryWarnedAt == nil {
+				r.Recorder.Eventf(tenant, corev1.EventTypeWarning, EVENT_TENANT_EXPIRING,
+					"Tenant expires at %s", expires.UTC().Format(time.RFC3339))
+				log.Info(fmt.Sprintf("Tenant '%s' expires at %s", tenant.ObjectMeta.Name, expires.UTC().Format(time.RFC3339)))
+				status.ExpiryWarnedAt = &now
+			}
+			next = expires.Sub(now.Time)
+		}
+	} else if status.ExpiredAt == nil {
+		deleted, err := r.expireTenant(ctx, tenant)
+		if err != nil || deleted {
+			return 0, deleted, err
+		}
+		status.ExpiredAt = &now
+	}
+
+	if !equality.Semantic.DeepEqual(*status, tenant.Status) {
+		tenant.Status = *status
+		if err := r.Status().Update(ctx, tenant); err != nil {
+			return 0, false, err
+		}
+	}
+	return next, false, nil
+}
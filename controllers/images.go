@@ -0,0 +1,208 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+	"github.com/devicechain-io/dc-k8s/registry"
+)
+
+// Get the image policy configured on the cluster (nil if none).
+func getImagePolicy(ctx context.Context, c client.Client) (*v1beta1.ImagePolicySpec, error) {
+	clusters := &v1beta1.ClusterList{}
+	if err := c.List(ctx, clusters); err != nil {
+		return nil, err
+	}
+	for _, cluster := range clusters.Items {
+		if cluster.Spec.ImagePolicy != nil {
+			return cluster.Spec.ImagePolicy, nil
+		}
+	}
+	return nil, nil
+}
+
+// Get images of workloads created by the operator. Images not configured on the cluster use defaults.
+func getSystemImages(ctx context.Context, c client.Client) (*v1beta1.SystemImagesSpec, error) {
+	images := &v1beta1.SystemImagesSpec{
+		Maintenance: MAINTENANCE_IMAGE,
+		Kafka:       KAFKA_IMAGE,
+		PostgreSQL:  POSTGRESQL_IMAGE,
+		Redis:       REDIS_IMAGE,
+	}
+	clusters := &v1beta1.ClusterList{}
+	if err := c.List(ctx, clusters); err != nil {
+		return nil, err
+	}
+	for _, cluster := range clusters.Items {
+		configured := cluster.Spec.SystemImages
+		if configured == nil {
+			continue
+		}
+		if configured.Maintenance != "" {
+			images.Maintenance = configured.Maintenance
+		}
+		if configured.Kafka != "" {
+			images.Kafka = configured.Kafka
+		}
+		if configured.PostgreSQL != "" {
+			images.PostgreSQL = configured.PostgreSQL
+		}
+		if configured.Redis != "" {
+			images.Redis = configured.Redis
+		}
+		break
+	}
+	return images, nil
+}
+
+// Get the reason an image may not be used under the cluster image policy (empty if allowed).
+func getImagePolicyViolation(policy *v1beta1.ImagePolicySpec, image string) string {
+	ref, err := registry.ParseReference(image)
+	if err != nil {
+		return err.Error()
+	}
+	if policy != nil && !registry.IsAllowed(ref, policy.AllowedRegistries, policy.AllowedRepositories) {
+		return fmt.Sprintf("image '%s' is not allowed by cluster image policy", image)
+	}
+	return ""
+}
+
+// Check an image created by the operator against the cluster image policy. Returns the reason
+// it may not be used (empty if allowed).
+func checkImagePolicy(ctx context.Context, c client.Client, image string) (string, error) {
+	policy, err := getImagePolicy(ctx, c)
+	if err != nil {
+		return "", err
+	}
+	return getImagePolicyViolation(policy, image), nil
+}
+
+// Get registry credentials from the docker config pull secrets used by a pod.
+func getRegistryCredentials(ctx context.Context, c client.Client, ns string,
+	secrets []corev1.LocalObjectReference) map[string]registry.Credential {
+	log := logf.FromContext(ctx)
+
+	creds := make(map[string]registry.Credential)
+	for _, ref := range secrets {
+		secret := &corev1.Secret{}
+		if err := c.Get(ctx, client.ObjectKey{Namespace: ns, Name: ref.Name}, secret); err != nil {
+			log.Info(fmt.Sprintf("Unable to load image pull secret '%s': %v", ref.Name, err))
+			continue
+		}
+		content, found := secret.Data[corev1.DockerConfigJsonKey]
+		if !found {
+			continue
+		}
+		parsed, err := registry.ParseDockerConfig(content)
+		if err != nil {
+			log.Info(fmt.Sprintf("Unable to parse image pull secret '%s': %v", ref.Name, err))
+			continue
+		}
+		for host, cred := range parsed {
+			if _, found := creds[host]; !found {
+				creds[host] = cred
+			}
+		}
+	}
+	return creds
+}
+
+// Get the image requested for a tenant microservice. Tenants not yet upgraded by a staged
+// rollout remain on the image last rolled out to all tenants.
+func getRequestedImage(tms *v1beta1.TenantMicroservice, ms *v1beta1.Microservice) string {
+	if tms.Spec.Image != "" {
+		return tms.Spec.Image
+	}
+	if ms.Spec.Rollout != nil && ms.Status.CurrentImage != "" {
+		return ms.Status.CurrentImage
+	}
+	return ms.Spec.Image
+}
+
+// Get the image used for a tenant microservice container.
+func getContainerImage(tms *v1beta1.TenantMicroservice, ms *v1beta1.Microservice) string {
+	image := getRequestedImage(tms, ms)
+	if tms.Status.Image == image && tms.Status.ResolvedImage != "" && tms.Status.ImageError == "" {
+		return tms.Status.ResolvedImage
+	}
+	return image
+}
+
+// Check the microservice image against the cluster image policy and resolve its digest if
+// required. Returns false if the image may not be rolled out.
+func (r *TenantMicroserviceReconciler) reconcileImage(ctx context.Context, tms *v1beta1.TenantMicroservice,
+	ms *v1beta1.Microservice, dci *v1beta1.Instance) (bool, error) {
+	log := logf.FromContext(ctx)
+
+	policy, err := getImagePolicy(ctx, r.Client)
+	if err != nil {
+		return false, err
+	}
+	if policy == nil {
+		policy = &v1beta1.ImagePolicySpec{}
+	}
+
+	image := getRequestedImage(tms, ms)
+	status := tms.Status.DeepCopy()
+	status.Image = image
+	status.ImageError = ""
+
+	ref, _ := registry.ParseReference(image)
+	if violation := getImagePolicyViolation(policy, image); violation != "" {
+		status.ImageError = violation
+	} else if ref.Digest != "" {
+		status.ResolvedImage = image
+		status.ImageDigest = ref.Digest
+	} else if !policy.ResolveDigests {
+		status.ResolvedImage = image
+		status.ImageDigest = ""
+	} else if tms.Status.Image != image || tms.Status.ImageDigest == "" {
+		// Only resolve when the image changes so tags moving in the registry do not roll tenants.
+		resolver := registry.NewResolver(getRegistryCredentials(ctx, r.Client, tms.ObjectMeta.Namespace,
+			getImagePullSecrets(dci, ms)))
+		for _, host := range policy.InsecureRegistries {
+			resolver.Insecure[host] = true
+		}
+		digest, err := resolver.Resolve(ctx, ref)
+		if err != nil {
+			return false, err
+		}
+		status.ResolvedImage = ref.WithDigest(digest)
+		status.ImageDigest = digest
+		log.Info(fmt.Sprintf("Resolved image '%s' to '%s'", image, status.ResolvedImage))
+	}
+
+	if !reflect.DeepEqual(*status, tms.Status) {
+		tms.Status = *status
+		if err := r.Status().Update(ctx, tms); err != nil {
+			return false, err
+		}
+	}
+	if status.ImageError != "" {
+		log.Info(fmt.Sprintf("Not rolling out tenant microservice '%s': %s", tms.ObjectMeta.Name, status.ImageError))
+		return false, nil
+	}
+	return true, nil
+}
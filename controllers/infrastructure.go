@@ -0,0 +1,1039 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+const (
+	// Names of shared infrastructure components.
+	INFRASTRUCTURE_KAFKA      = "kafka"
+	INFRASTRUCTURE_POSTGRESQL = "postgresql"
+	INFRASTRUCTURE_REDIS      = "redis"
+
+	// Key of infrastructure connection details in the instance config map.
+	INFRASTRUCTURE_CONFIG_NAME = "infrastructure"
+
+	// Label identifying infrastructure component pods.
+	LABEL_INFRASTRUCTURE = "devicechain.io.infrastructure"
+
+	// Default images for components deployed as stateful sets (overridden by cluster system images).
+	KAFKA_IMAGE      = "bitnami/kafka:3.2"
+	POSTGRESQL_IMAGE = "bitnami/postgresql:14"
+	REDIS_IMAGE      = "bitnami/redis:7.0"
+
+	// Ports on which components are reached.
+	KAFKA_PORT            = 9092
+	KAFKA_CONTROLLER_PORT = 9093
+	POSTGRESQL_PORT       = 5432
+	REDIS_PORT            = 6379
+
+	// User id of the non-root user in default component images.
+	INFRASTRUCTURE_USER_ID = 1001
+
+	// Size of component volumes if not configured.
+	DEFAULT_INFRASTRUCTURE_STORAGE = "8Gi"
+
+	// Interval at which components are checked while not ready.
+	INFRASTRUCTURE_POLL_INTERVAL = 30 * time.Second
+
+	// Keys of generated infrastructure credentials.
+	INFRASTRUCTURE_USERNAME_KEY = "username"
+	INFRASTRUCTURE_PASSWORD_KEY = "password"
+)
+
+var strimziKafkaGVK = schema.GroupVersionKind{
+	Group:   "kafka.strimzi.io",
+	Version: "v1beta2",
+	Kind:    "Kafka",
+}
+
+var cnpgClusterGVK = schema.GroupVersionKind{
+	Group:   "postgresql.cnpg.io",
+	Version: "v1",
+	Kind:    "Cluster",
+}
+
+// Optional spec fields of operator resources which are removed once no longer generated.
+var infrastructureOptionalFields = map[string][][]string{
+	INFRASTRUCTURE_KAFKA: {
+		{"kafka", "image"},
+		{"kafka", "resources"},
+		{"kafka", "storage", "class"},
+		{"zookeeper", "storage", "class"},
+	},
+	INFRASTRUCTURE_POSTGRESQL: {
+		{"imageName"},
+		{"resources"},
+		{"storage", "storageClass"},
+	},
+}
+
+// Connection details of an infrastructure component written to the instance config map.
+type infrastructureConnection struct {
+	Endpoint          string `json:"endpoint"`
+	Host              string `json:"host"`
+	Port              int32  `json:"port"`
+	CredentialsSecret string `json:"credentialsSecret,omitempty"`
+}
+
+// Get name used for resources of an infrastructure component in an instance namespace.
+func getInfrastructureName(ns string, component string) string {
+	return fmt.Sprintf("%s-%s-%s", "dci", ns, component)
+}
+
+// Get labels applied to infrastructure component pods.
+func getInfrastructureLabels(component string) map[string]string {
+	return map[string]string{
+		LABEL_INFRASTRUCTURE: component,
+	}
+}
+
+// Get infrastructure components of an instance by name (nil if not declared).
+func getInfrastructureComponents(dci *v1beta1.Instance) map[string]*v1beta1.InfrastructureComponentSpec {
+	components := map[string]*v1beta1.InfrastructureComponentSpec{
+		INFRASTRUCTURE_KAFKA:      nil,
+		INFRASTRUCTURE_POSTGRESQL: nil,
+		INFRASTRUCTURE_REDIS:      nil,
+	}
+	if infra := dci.Spec.Infrastructure; infra != nil {
+		components[INFRASTRUCTURE_KAFKA] = infra.Kafka
+		components[INFRASTRUCTURE_POSTGRESQL] = infra.PostgreSQL
+		components[INFRASTRUCTURE_REDIS] = infra.Redis
+	}
+	return components
+}
+
+// Get how an infrastructure component is deployed.
+func getInfrastructureProvider(spec *v1beta1.InfrastructureComponentSpec) v1beta1.InfrastructureProvider {
+	if spec.Provider == "" {
+		return v1beta1.InfrastructureStatefulSet
+	}
+	return spec.Provider
+}
+
+// Get number of replicas for an infrastructure component.
+func getInfrastructureReplicas(spec *v1beta1.InfrastructureComponentSpec) int32 {
+	if spec.Replicas == nil {
+		return 1
+	}
+	return *spec.Replicas
+}
+
+// Get volume size for replicas of an infrastructure component.
+func getInfrastructureStorage(spec *v1beta1.InfrastructureComponentSpec) resource.Quantity {
+	if spec.StorageSize == nil {
+		return resource.MustParse(DEFAULT_INFRASTRUCTURE_STORAGE)
+	}
+	return *spec.StorageSize
+}
+
+// Get replication factor used for Kafka internal topics.
+func getKafkaReplicationFactor(replicas int32) int64 {
+	if replicas > 3 {
+		return 3
+	}
+	return int64(replicas)
+}
+
+// Get Kafka cluster id derived from the instance so it is stable across restarts.
+func getKafkaClusterId(dci *v1beta1.Instance) string {
+	hash := sha256.Sum256([]byte(dci.ObjectMeta.UID))
+	return base64.RawURLEncoding.EncodeToString(hash[:16])
+}
+
+// Get the in-cluster host name of a service.
+func getServiceHost(ns string, name string) string {
+	return fmt.Sprintf("%s.%s.svc", name, ns)
+}
+
+// Generate the Kafka broker container of a stateful set running Kafka in KRaft mode.
+func generateKafkaContainer(dci *v1beta1.Instance, name string, replicas int32) corev1.Container {
+	ns := dci.ObjectMeta.Name
+	host := getServiceHost(ns, name)
+	voters := make([]string, 0)
+	for i := int32(0); i < replicas; i++ {
+		voters = append(voters, fmt.Sprintf("%d@%s-%d.%s:%d", i, name, i, host, KAFKA_CONTROLLER_PORT))
+	}
+	factor := fmt.Sprintf("%d", getKafkaReplicationFactor(replicas))
+
+	return corev1.Container{
+		Name: INFRASTRUCTURE_KAFKA,
+		// Node ids are taken from the stateful set ordinal of the pod.
+		Command: []string{"/bin/bash", "-c",
+			"export KAFKA_CFG_NODE_ID=${HOSTNAME##*-} KAFKA_CFG_BROKER_ID=${HOSTNAME##*-} && " +
+				"exec /opt/bitnami/scripts/kafka/entrypoint.sh /opt/bitnami/scripts/kafka/run.sh"},
+		Env: []corev1.EnvVar{
+			{
+				Name: "POD_NAME",
+				ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"},
+				},
+			},
+			{Name: "ALLOW_PLAINTEXT_LISTENER", Value: "yes"},
+			{Name: "KAFKA_ENABLE_KRAFT", Value: "yes"},
+			{Name: "KAFKA_KRAFT_CLUSTER_ID", Value: getKafkaClusterId(dci)},
+			{Name: "KAFKA_CFG_PROCESS_ROLES", Value: "broker,controller"},
+			{Name: "KAFKA_CFG_CONTROLLER_LISTENER_NAMES", Value: "CONTROLLER"},
+			{Name: "KAFKA_CFG_LISTENERS", Value: fmt.Sprintf("PLAINTEXT://:%d,CONTROLLER://:%d", KAFKA_PORT, KAFKA_CONTROLLER_PORT)},
+			{Name: "KAFKA_CFG_LISTENER_SECURITY_PROTOCOL_MAP", Value: "CONTROLLER:PLAINTEXT,PLAINTEXT:PLAINTEXT"},
+			{Name: "KAFKA_CFG_ADVERTISED_LISTENERS", Value: fmt.Sprintf("PLAINTEXT://$(POD_NAME).%s:%d", host, KAFKA_PORT)},
+			{Name: "KAFKA_CFG_CONTROLLER_QUORUM_VOTERS", Value: strings.Join(voters, ",")},
+			{Name: "KAFKA_CFG_OFFSETS_TOPIC_REPLICATION_FACTOR", Value: factor},
+			{Name: "KAFKA_CFG_TRANSACTION_STATE_LOG_REPLICATION_FACTOR", Value: factor},
+			{Name: "KAFKA_CFG_DEFAULT_REPLICATION_FACTOR", Value: factor},
+		},
+		Ports: []corev1.ContainerPort{
+			{Name: "kafka", ContainerPort: KAFKA_PORT, Protocol: corev1.ProtocolTCP},
+			{Name: "controller", ContainerPort: KAFKA_CONTROLLER_PORT, Protocol: corev1.ProtocolTCP},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: "data", MountPath: "/bitnami/kafka"},
+		},
+	}
+}
+
+// Generate the PostgreSQL server container of a stateful set.
+func generatePostgreSQLContainer(name string) corev1.Container {
+	return corev1.Container{
+		Name: INFRASTRUCTURE_POSTGRESQL,
+		Env: []corev1.EnvVar{
+			{
+				Name: "POSTGRESQL_PASSWORD",
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: name},
+						Key:                  INFRASTRUCTURE_PASSWORD_KEY,
+					},
+				},
+			},
+		},
+		Ports: []corev1.ContainerPort{
+			{Name: "postgresql", ContainerPort: POSTGRESQL_PORT, Protocol: corev1.ProtocolTCP},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: "data", MountPath: "/bitnami/postgresql"},
+		},
+	}
+}
+
+// Generate the Redis server container of a stateful set.
+func generateRedisContainer(name string) corev1.Container {
+	return corev1.Container{
+		Name: INFRASTRUCTURE_REDIS,
+		Env: []corev1.EnvVar{
+			{
+				Name: "REDIS_PASSWORD",
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: name},
+						Key:                  INFRASTRUCTURE_PASSWORD_KEY,
+					},
+				},
+			},
+		},
+		Ports: []corev1.ContainerPort{
+			{Name: "redis", ContainerPort: REDIS_PORT, Protocol: corev1.ProtocolTCP},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: "data", MountPath: "/bitnami/redis/data"},
+		},
+	}
+}
+
+// Get the client port of an infrastructure component.
+func getInfrastructurePort(component string) int32 {
+	switch component {
+	case INFRASTRUCTURE_KAFKA:
+		return KAFKA_PORT
+	case INFRASTRUCTURE_POSTGRESQL:
+		return POSTGRESQL_PORT
+	default:
+		return REDIS_PORT
+	}
+}
+
+// Get the image of an infrastructure component deployed as a stateful set.
+func getInfrastructureImage(images *v1beta1.SystemImagesSpec, component string,
+	spec *v1beta1.InfrastructureComponentSpec) string {
+	if spec.Image != "" {
+		return spec.Image
+	}
+	switch component {
+	case INFRASTRUCTURE_KAFKA:
+		return images.Kafka
+	case INFRASTRUCTURE_POSTGRESQL:
+		return images.PostgreSQL
+	default:
+		return images.Redis
+	}
+}
+
+// Generate the stateful set running an infrastructure component.
+func generateInfrastructureStatefulSet(dci *v1beta1.Instance, component string,
+	spec *v1beta1.InfrastructureComponentSpec, image string) *appsv1.StatefulSet {
+	ns := dci.ObjectMeta.Name
+	name := getInfrastructureName(ns, component)
+	labels := getInfrastructureLabels(component)
+
+	replicas := int32(1)
+	var container corev1.Container
+	switch component {
+	case INFRASTRUCTURE_KAFKA:
+		replicas = getInfrastructureReplicas(spec)
+		container = generateKafkaContainer(dci, name, replicas)
+	case INFRASTRUCTURE_POSTGRESQL:
+		container = generatePostgreSQLContainer(name)
+	default:
+		container = generateRedisContainer(name)
+	}
+	container.Image = image
+	if spec.Resources != nil {
+		container.Resources = *spec.Resources
+	}
+	container.ReadinessProbe = &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(int(getInfrastructurePort(component)))},
+		},
+		InitialDelaySeconds: 10,
+		PeriodSeconds:       10,
+	}
+
+	nonroot := true
+	escalation := false
+	user := int64(INFRASTRUCTURE_USER_ID)
+	automount := false
+	container.SecurityContext = &corev1.SecurityContext{
+		RunAsNonRoot:             &nonroot,
+		RunAsUser:                &user,
+		AllowPrivilegeEscalation: &escalation,
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+		},
+	}
+
+	pod := corev1.PodSpec{
+		AutomountServiceAccountToken: &automount,
+		ImagePullSecrets:             getInstanceImagePullSecrets(dci),
+		SecurityContext: &corev1.PodSecurityContext{
+			RunAsNonRoot: &nonroot,
+			FSGroup:      &user,
+			SeccompProfile: &corev1.SeccompProfile{
+				Type: corev1.SeccompProfileTypeRuntimeDefault,
+			},
+		},
+		Containers: []corev1.Container{container},
+	}
+	applyInstanceScheduling(&pod, labels, dci)
+
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+			Labels:    labels,
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:            &replicas,
+			ServiceName:         name,
+			PodManagementPolicy: appsv1.ParallelPodManagement,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: pod,
+			},
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:   "data",
+						Labels: labels,
+					},
+					Spec: corev1.PersistentVolumeClaimSpec{
+						AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+						StorageClassName: spec.StorageClassName,
+						Resources: corev1.ResourceRequirements{
+							Requests: corev1.ResourceList{
+								corev1.ResourceStorage: getInfrastructureStorage(spec),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// Generate the headless service used to reach pods of an infrastructure stateful set.
+func generateInfrastructureService(ns string, component string) *corev1.Service {
+	labels := getInfrastructureLabels(component)
+	ports := []corev1.ServicePort{
+		{
+			Name:     component,
+			Protocol: corev1.ProtocolTCP,
+			Port:     getInfrastructurePort(component),
+		},
+	}
+	// Kafka controllers must resolve each other before any broker is ready.
+	unready := false
+	if component == INFRASTRUCTURE_KAFKA {
+		unready = true
+		ports = append(ports, corev1.ServicePort{
+			Name:     "controller",
+			Protocol: corev1.ProtocolTCP,
+			Port:     KAFKA_CONTROLLER_PORT,
+		})
+	}
+
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getInfrastructureName(ns, component),
+			Namespace: ns,
+			Labels:    labels,
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP:                corev1.ClusterIPNone,
+			PublishNotReadyAddresses: unready,
+			Ports:                    ports,
+			Selector:                 labels,
+		},
+	}
+}
+
+// Convert compute resources to the unstructured form used in operator resources.
+func toUnstructuredResources(resources *corev1.ResourceRequirements) (map[string]interface{}, error) {
+	return runtime.DefaultUnstructuredConverter.ToUnstructured(resources)
+}
+
+// Generate the Strimzi persistent storage definition for an infrastructure component.
+func generateStrimziStorage(spec *v1beta1.InfrastructureComponentSpec) map[string]interface{} {
+	size := getInfrastructureStorage(spec)
+	storage := map[string]interface{}{
+		"type":        "persistent-claim",
+		"size":        size.String(),
+		"deleteClaim": false,
+	}
+	if spec.StorageClassName != nil {
+		storage["class"] = *spec.StorageClassName
+	}
+	return storage
+}
+
+// Generate a Strimzi Kafka resource for the instance Kafka cluster.
+func generateStrimziKafka(dci *v1beta1.Instance, spec *v1beta1.InfrastructureComponentSpec) (*unstructured.Unstructured, error) {
+	replicas := getInfrastructureReplicas(spec)
+	factor := getKafkaReplicationFactor(replicas)
+	isr := factor - 1
+	if isr < 1 {
+		isr = 1
+	}
+
+	kafka := map[string]interface{}{
+		"replicas": int64(replicas),
+		"listeners": []interface{}{
+			map[string]interface{}{
+				"name": "plain",
+				"port": int64(KAFKA_PORT),
+				"type": "internal",
+				"tls":  false,
+			},
+		},
+		"config": map[string]interface{}{
+			"offsets.topic.replication.factor":         factor,
+			"transaction.state.log.replication.factor": factor,
+			"transaction.state.log.min.isr":            isr,
+			"default.replication.factor":               factor,
+			"min.insync.replicas":                      isr,
+		},
+		"storage": generateStrimziStorage(spec),
+	}
+	if spec.Image != "" {
+		kafka["image"] = spec.Image
+	}
+	if spec.Resources != nil {
+		resources, err := toUnstructuredResources(spec.Resources)
+		if err != nil {
+			return nil, err
+		}
+		kafka["resources"] = resources
+	}
+
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(strimziKafkaGVK)
+	obj.SetName(getInfrastructureName(dci.ObjectMeta.Name, INFRASTRUCTURE_KAFKA))
+	obj.SetNamespace(dci.ObjectMeta.Name)
+	obj.SetLabels(getInfrastructureLabels(INFRASTRUCTURE_KAFKA))
+	err := unstructured.SetNestedMap(obj.Object, map[string]interface{}{
+		"kafka": kafka,
+		"zookeeper": map[string]interface{}{
+			"replicas": int64(replicas),
+			"storage":  generateStrimziStorage(spec),
+		},
+		// The topic operator manages tenant topics.
+		"entityOperator": map[string]interface{}{
+			"topicOperator": map[string]interface{}{},
+			"userOperator":  map[string]interface{}{},
+		},
+	}, "spec")
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// Merge generated values into an existing unstructured map. Nested maps are merged so values
+// not generated (such as defaults filled in by an operator) are kept.
+func mergeUnstructuredMap(existing map[string]interface{}, generated map[string]interface{}) {
+	for key, value := range generated {
+		if gmap, ok := value.(map[string]interface{}); ok {
+			if emap, ok := existing[key].(map[string]interface{}); ok {
+				mergeUnstructuredMap(emap, gmap)
+				continue
+			}
+		}
+		existing[key] = runtime.DeepCopyJSONValue(value)
+	}
+}
+
+// Get the spec of an existing operator resource updated with generated values. Returns nil if
+// no changes are needed.
+func getUpdatedOperatorSpec(component string, existing *unstructured.Unstructured,
+	generated *unstructured.Unstructured) (map[string]interface{}, error) {
+	current, _, err := unstructured.NestedMap(existing.Object, "spec")
+	if err != nil {
+		return nil, err
+	}
+	wanted, _, err := unstructured.NestedMap(generated.Object, "spec")
+	if err != nil {
+		return nil, err
+	}
+	updated := runtime.DeepCopyJSON(current)
+	if updated == nil {
+		updated = make(map[string]interface{})
+	}
+	mergeUnstructuredMap(updated, wanted)
+	for _, path := range infrastructureOptionalFields[component] {
+		if _, found, _ := unstructured.NestedFieldNoCopy(wanted, path...); !found {
+			unstructured.RemoveNestedField(updated, path...)
+		}
+	}
+	if equality.Semantic.DeepEqual(updated, current) {
+		return nil, nil
+	}
+	return updated, nil
+}
+
+// Generate a CloudNativePG cluster for the instance PostgreSQL server.
+func generateCNPGCluster(dci *v1beta1.Instance, spec *v1beta1.InfrastructureComponentSpec) (*unstructured.Unstructured, error) {
+	size := getInfrastructureStorage(spec)
+	storage := map[string]interface{}{
+		"size": size.String(),
+	}
+	if spec.StorageClassName != nil {
+		storage["storageClass"] = *spec.StorageClassName
+	}
+	cluster := map[string]interface{}{
+		"instances": int64(getInfrastructureReplicas(spec)),
+		"storage":   storage,
+		// Superuser credentials are needed to provision tenant databases.
+		"enableSuperuserAccess": true,
+	}
+	if spec.Image != "" {
+		cluster["imageName"] = spec.Image
+	}
+	if spec.Resources != nil {
+		resources, err := toUnstructuredResources(spec.Resources)
+		if err != nil {
+			return nil, err
+		}
+		cluster["resources"] = resources
+	}
+
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(cnpgClusterGVK)
+	obj.SetName(getInfrastructureName(dci.ObjectMeta.Name, INFRASTRUCTURE_POSTGRESQL))
+	obj.SetNamespace(dci.ObjectMeta.Name)
+	obj.SetLabels(getInfrastructureLabels(INFRASTRUCTURE_POSTGRESQL))
+	if err := unstructured.SetNestedMap(obj.Object, cluster, "spec"); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// Get connection details of an infrastructure component deployed by a provider.
+func getInfrastructureConnection(ns string, component string, provider v1beta1.InfrastructureProvider) *infrastructureConnection {
+	name := getInfrastructureName(ns, component)
+	conn := &infrastructureConnection{
+		Host: getServiceHost(ns, name),
+		Port: getInfrastructurePort(component),
+	}
+	if component != INFRASTRUCTURE_KAFKA {
+		conn.CredentialsSecret = name
+	}
+	if provider == v1beta1.InfrastructureOperator {
+		switch component {
+		case INFRASTRUCTURE_KAFKA:
+			conn.Host = getServiceHost(ns, name+"-kafka-bootstrap")
+		case INFRASTRUCTURE_POSTGRESQL:
+			conn.Host = getServiceHost(ns, name+"-rw")
+			conn.CredentialsSecret = name + "-superuser"
+		}
+	}
+	conn.Endpoint = fmt.Sprintf("%s:%d", conn.Host, conn.Port)
+	return conn
+}
+
+// Get readiness and bootstrap servers of a Strimzi Kafka resource.
+func getStrimziKafkaStatus(obj *unstructured.Unstructured) (bool, string) {
+	bootstrap := getInfrastructureConnection(obj.GetNamespace(), INFRASTRUCTURE_KAFKA, v1beta1.InfrastructureOperator).Endpoint
+	listeners, _, _ := unstructured.NestedSlice(obj.Object, "status", "listeners")
+	for _, listener := range listeners {
+		if values, ok := listener.(map[string]interface{}); ok && values["name"] == "plain" {
+			if servers, ok := values["bootstrapServers"].(string); ok && servers != "" {
+				bootstrap = servers
+			}
+		}
+	}
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, condition := range conditions {
+		if values, ok := condition.(map[string]interface{}); ok && values["type"] == "Ready" {
+			return values["status"] == "True", bootstrap
+		}
+	}
+	return false, bootstrap
+}
+
+// Indicates whether all instances of a CloudNativePG cluster are ready.
+func isCNPGClusterReady(obj *unstructured.Unstructured) bool {
+	instances, _, _ := unstructured.NestedInt64(obj.Object, "spec", "instances")
+	ready, _, _ := unstructured.NestedInt64(obj.Object, "status", "readyInstances")
+	return instances > 0 && ready >= instances
+}
+
+// Indicates whether all replicas of a stateful set are ready.
+func isStatefulSetReady(sts *appsv1.StatefulSet) bool {
+	if sts.Status.ObservedGeneration < sts.ObjectMeta.Generation {
+		return false
+	}
+	replicas := int32(1)
+	if sts.Spec.Replicas != nil {
+		replicas = *sts.Spec.Replicas
+	}
+	return sts.Status.ReadyReplicas >= replicas
+}
+
+// Get or create the generated credentials of an infrastructure component.
+func (r *InstanceReconciler) getOrCreateInfrastructureCredentials(ctx context.Context, ns string,
+	component string, username string) (*corev1.Secret, error) {
+	name := getInfrastructureName(ns, component)
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: ns, Name: name}, secret); err != nil {
+		if !errors.IsNotFound(err) {
+			return nil, err
+		}
+		password, err := generatePassword(DEFAULT_PASSWORD_LENGTH)
+		if err != nil {
+			return nil, err
+		}
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: ns,
+				Labels:    getInfrastructureLabels(component),
+			},
+			Data: map[string][]byte{
+				INFRASTRUCTURE_USERNAME_KEY: []byte(username),
+				INFRASTRUCTURE_PASSWORD_KEY: password,
+			},
+		}
+		if err := r.Create(ctx, secret); err != nil {
+			return nil, err
+		}
+	}
+	return secret, nil
+}
+
+// Create or update the stateful set and service of an infrastructure component.
+func (r *InstanceReconciler) reconcileInfrastructureStatefulSet(ctx context.Context, dci *v1beta1.Instance,
+	component string, spec *v1beta1.InfrastructureComponentSpec) (*v1beta1.InfrastructureStatus, *infrastructureConnection, error) {
+	log := logf.FromContext(ctx)
+	ns := dci.ObjectMeta.Name
+	name := getInfrastructureName(ns, component)
+
+	status := &v1beta1.InfrastructureStatus{
+		Component: component,
+		Provider:  v1beta1.InfrastructureStatefulSet,
+	}
+	images, err := getSystemImages(ctx, r.Client)
+	if err != nil {
+		return nil, nil, err
+	}
+	image := getInfrastructureImage(images, component, spec)
+	violation, err := checkImagePolicy(ctx, r.Client, image)
+	if err != nil {
+		return nil, nil, err
+	}
+	if violation != "" {
+		status.Message = violation
+		return status, nil, nil
+	}
+
+	conn := getInfrastructureConnection(ns, component, v1beta1.InfrastructureStatefulSet)
+	switch component {
+	case INFRASTRUCTURE_POSTGRESQL:
+		if _, err := r.getOrCreateInfrastructureCredentials(ctx, ns, component, "postgres"); err != nil {
+			return nil, nil, err
+		}
+	case INFRASTRUCTURE_REDIS:
+		if _, err := r.getOrCreateInfrastructureCredentials(ctx, ns, component, "default"); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	generated := generateInfrastructureService(ns, component)
+	service := &corev1.Service{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: ns, Name: name}, service); err != nil {
+		if !errors.IsNotFound(err) {
+			return nil, nil, err
+		}
+		if err := r.Create(ctx, generated); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	updated := generateInfrastructureStatefulSet(dci, component, spec, image)
+	sts := &appsv1.StatefulSet{}
+	pinned := ""
+	if err := r.Get(ctx, client.ObjectKey{Namespace: ns, Name: name}, sts); err != nil {
+		if !errors.IsNotFound(err) {
+			return nil, nil, err
+		}
+		if err := r.Create(ctx, updated); err != nil {
+			return nil, nil, err
+		}
+		log.Info(fmt.Sprintf("Created %s stateful set for instance '%s'", component, ns))
+		sts = updated
+	} else {
+		// Kafka controller quorum voters are fixed when the cluster is formatted, so brokers are
+		// never added or removed once created.
+		if component == INFRASTRUCTURE_KAFKA && sts.Spec.Replicas != nil &&
+			*sts.Spec.Replicas != *updated.Spec.Replicas {
+			pinned = fmt.Sprintf("replicas can not be changed from %d once Kafka is created", *sts.Spec.Replicas)
+			fixed := spec.DeepCopy()
+			fixed.Replicas = sts.Spec.Replicas
+			updated = generateInfrastructureStatefulSet(dci, component, fixed, image)
+		}
+		if !equality.Semantic.DeepDerivative(updated.Spec.Template, sts.Spec.Template) ||
+			!equality.Semantic.DeepEqual(updated.Spec.Replicas, sts.Spec.Replicas) {
+			// Volume claim templates can not be changed once created.
+			sts.Spec.Replicas = updated.Spec.Replicas
+			sts.Spec.Template = updated.Spec.Template
+			if err := r.Update(ctx, sts); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	status.Ready = isStatefulSetReady(sts)
+	status.Endpoint = conn.Endpoint
+	messages := make([]string, 0)
+	if !status.Ready {
+		messages = append(messages, fmt.Sprintf("%d of %d replicas ready", sts.Status.ReadyReplicas, *updated.Spec.Replicas))
+	}
+	if pinned != "" {
+		messages = append(messages, pinned)
+	}
+	status.Message = strings.Join(messages, "; ")
+	return status, conn, nil
+}
+
+// Create or update the operator resource of an infrastructure component.
+func (r *InstanceReconciler) reconcileInfrastructureOperator(ctx context.Context, dci *v1beta1.Instance,
+	component string, spec *v1beta1.InfrastructureComponentSpec) (*v1beta1.InfrastructureStatus, *infrastructureConnection, error) {
+	log := logf.FromContext(ctx)
+	ns := dci.ObjectMeta.Name
+	name := getInfrastructureName(ns, component)
+	status := &v1beta1.InfrastructureStatus{
+		Component: component,
+		Provider:  v1beta1.InfrastructureOperator,
+	}
+
+	var generated *unstructured.Unstructured
+	var err error
+	switch component {
+	case INFRASTRUCTURE_KAFKA:
+		generated, err = generateStrimziKafka(dci, spec)
+	case INFRASTRUCTURE_POSTGRESQL:
+		generated, err = generateCNPGCluster(dci, spec)
+	default:
+		status.Message = fmt.Sprintf("no operator is supported for %s", component)
+		return status, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Images chosen by the operator are not known, so only images set on the instance are checked.
+	if spec.Image != "" {
+		violation, err := checkImagePolicy(ctx, r.Client, spec.Image)
+		if err != nil {
+			return nil, nil, err
+		}
+		if violation != "" {
+			status.Message = violation
+			return status, nil, nil
+		}
+	}
+
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(generated.GroupVersionKind())
+	if err := r.Get(ctx, client.ObjectKey{Namespace: ns, Name: name}, existing); err != nil {
+		if meta.IsNoMatchError(err) {
+			status.Message = fmt.Sprintf("%s operator is not installed", generated.GroupVersionKind().Group)
+			return status, nil, nil
+		}
+		if !errors.IsNotFound(err) {
+			return nil, nil, err
+		}
+		if err := r.Create(ctx, generated); err != nil {
+			return nil, nil, err
+		}
+		log.Info(fmt.Sprintf("Created %s %s for instance '%s'", component, generated.GetKind(), ns))
+		existing = generated
+	} else {
+		spec, err := getUpdatedOperatorSpec(component, existing, generated)
+		if err != nil {
+			return nil, nil, err
+		}
+		if spec != nil {
+			if err := unstructured.SetNestedMap(existing.Object, spec, "spec"); err != nil {
+				return nil, nil, err
+			}
+			if err := r.Update(ctx, existing); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	conn := getInfrastructureConnection(ns, component, v1beta1.InfrastructureOperator)
+	switch component {
+	case INFRASTRUCTURE_KAFKA:
+		status.Ready, conn.Endpoint = getStrimziKafkaStatus(existing)
+	case INFRASTRUCTURE_POSTGRESQL:
+		status.Ready = isCNPGClusterReady(existing)
+	}
+	status.Endpoint = conn.Endpoint
+	if !status.Ready {
+		status.Message = fmt.Sprintf("waiting for %s to become ready", generated.GetKind())
+	}
+	return status, conn, nil
+}
+
+// Get empty objects of the resources created for an infrastructure component by a provider.
+func getInfrastructureObjects(provider v1beta1.InfrastructureProvider) []client.Object {
+	if provider == v1beta1.InfrastructureStatefulSet {
+		return []client.Object{&appsv1.StatefulSet{}, &corev1.Service{}}
+	}
+	objects := make([]client.Object, 0)
+	for _, gvk := range []schema.GroupVersionKind{strimziKafkaGVK, cnpgClusterGVK} {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(gvk)
+		objects = append(objects, obj)
+	}
+	return objects
+}
+
+// Delete resources created for an infrastructure component by a provider. Credentials and
+// persistent volumes are retained so data survives if the component is declared again.
+func (r *InstanceReconciler) deleteInfrastructure(ctx context.Context, ns string, component string,
+	provider v1beta1.InfrastructureProvider) error {
+	log := logf.FromContext(ctx)
+	key := client.ObjectKey{Namespace: ns, Name: getInfrastructureName(ns, component)}
+	for _, obj := range getInfrastructureObjects(provider) {
+		if err := r.Get(ctx, key, obj); err != nil {
+			// Treat a cluster without the operator installed the same as a missing object.
+			if errors.IsNotFound(err) || meta.IsNoMatchError(err) {
+				continue
+			}
+			return err
+		}
+		if err := r.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		log.Info(fmt.Sprintf("Deleted %s resource '%s' for instance '%s'", component, key.Name, ns))
+	}
+	return nil
+}
+
+// Delete resources of all infrastructure components of a deleted instance.
+func (r *InstanceReconciler) deleteInstanceInfrastructure(ctx context.Context, ns string) error {
+	for _, component := range []string{INFRASTRUCTURE_KAFKA, INFRASTRUCTURE_POSTGRESQL, INFRASTRUCTURE_REDIS} {
+		for _, provider := range []v1beta1.InfrastructureProvider{v1beta1.InfrastructureStatefulSet, v1beta1.InfrastructureOperator} {
+			if err := r.deleteInfrastructure(ctx, ns, component, provider); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Write connection details of ready infrastructure components to the instance config map.
+func (r *InstanceReconciler) updateInfrastructureConfig(ctx context.Context, dci *v1beta1.Instance,
+	conns map[string]*infrastructureConnection) error {
+	cmap := &corev1.ConfigMap{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: dci.ObjectMeta.Name, Name: getInstanceConfigMapName(dci.ObjectMeta.Name)}, cmap)
+	if err != nil {
+		return err
+	}
+
+	value := ""
+	if len(conns) > 0 {
+		content, err := json.Marshal(conns)
+		if err != nil {
+			return err
+		}
+		value = string(content)
+	}
+	if cmap.Data[INFRASTRUCTURE_CONFIG_NAME] == value {
+		return nil
+	}
+	if value == "" {
+		delete(cmap.Data, INFRASTRUCTURE_CONFIG_NAME)
+	} else {
+		if cmap.Data == nil {
+			cmap.Data = make(map[string]string)
+		}
+		cmap.Data[INFRASTRUCTURE_CONFIG_NAME] = value
+	}
+	return r.Update(ctx, cmap)
+}
+
+// Provision shared infrastructure declared on an instance and publish connection details of
+// ready components. Returns true once all declared components are ready.
+func (r *InstanceReconciler) reconcileInstanceInfrastructure(ctx context.Context, dci *v1beta1.Instance) (bool, error) {
+	ns := dci.ObjectMeta.Name
+	statuses := make([]v1beta1.InfrastructureStatus, 0)
+	conns := make(map[string]*infrastructureConnection)
+	ready := true
+
+	for _, component := range []string{INFRASTRUCTURE_KAFKA, INFRASTRUCTURE_POSTGRESQL, INFRASTRUCTURE_REDIS} {
+		spec := getInfrastructureComponents(dci)[component]
+		if spec == nil {
+			if err := r.deleteInfrastructure(ctx, ns, component, v1beta1.InfrastructureStatefulSet); err != nil {
+				return false, err
+			}
+			if err := r.deleteInfrastructure(ctx, ns, component, v1beta1.InfrastructureOperator); err != nil {
+				return false, err
+			}
+			continue
+		}
+
+		// Remove resources left behind if the provider was changed.
+		var status *v1beta1.InfrastructureStatus
+		var conn *infrastructureConnection
+		var err error
+		if getInfrastructureProvider(spec) == v1beta1.InfrastructureOperator {
+			if err := r.deleteInfrastructure(ctx, ns, component, v1beta1.InfrastructureStatefulSet); err != nil {
+				return false, err
+			}
+			status, conn, err = r.reconcileInfrastructureOperator(ctx, dci, component, spec)
+		} else {
+			if err := r.deleteInfrastructure(ctx, ns, component, v1beta1.InfrastructureOperator); err != nil {
+				return false, err
+			}
+			status, conn, err = r.reconcileInfrastructureStatefulSet(ctx, dci, component, spec)
+		}
+		if err != nil {
+			return false, err
+		}
+		statuses = append(statuses, *status)
+		if status.Ready {
+			conns[component] = conn
+		} else {
+			ready = false
+		}
+	}
+
+	if err := r.updateInfrastructureConfig(ctx, dci, conns); err != nil {
+		return false, err
+	}
+	if len(statuses) == 0 {
+		statuses = nil
+	}
+	if !equality.Semantic.DeepEqual(statuses, dci.Status.Infrastructure) {
+		dci.Status.Infrastructure = statuses
+		if err := r.Status().Update(ctx, dci); err != nil {
+			return false, err
+		}
+	}
+	return ready, nil
+}
+
+// Find the instance an infrastructure stateful set belongs to.
+func (r *InstanceReconciler) findInstanceForInfrastructure(obj client.Object) []reconcile.Request {
+	if _, found := obj.GetLabels()[LABEL_INFRASTRUCTURE]; !found {
+		return nil
+	}
+	return r.findInstanceForObject(obj)
+}
+
+// Get infrastructure components of an instance which are declared but not ready.
+func getUnreadyInfrastructure(dci *v1beta1.Instance) []string {
+	unready := make([]string, 0)
+	components := getInfrastructureComponents(dci)
+	for _, component := range []string{INFRASTRUCTURE_KAFKA, INFRASTRUCTURE_POSTGRESQL, INFRASTRUCTURE_REDIS} {
+		if components[component] == nil {
+			continue
+		}
+		ready := false
+		for _, status := range dci.Status.Infrastructure {
+			if status.Component == component {
+				ready = status.Ready
+			}
+		}
+		if !ready {
+			unready = append(unready, component)
+		}
+	}
+	return unready
+}
@@ -0,0 +1,80 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+func TestGetUpdatedOperatorSpec(t *testing.T) {
+	dci := &v1beta1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "dc1"}}
+	one := int32(1)
+	three := int32(3)
+
+	// Existing cluster created with an image and defaults filled in by the operator.
+	existing, err := generateCNPGCluster(dci, &v1beta1.InfrastructureComponentSpec{Replicas: &one, Image: "pg:15"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := unstructured.SetNestedField(existing.Object, "5s", "spec", "switchoverDelay"); err != nil {
+		t.Fatal(err)
+	}
+	if err := unstructured.SetNestedField(existing.Object, true, "spec", "storage", "resizeInUseVolumes"); err != nil {
+		t.Fatal(err)
+	}
+
+	update := func(t *testing.T, spec *v1beta1.InfrastructureComponentSpec) map[string]interface{} {
+		generated, err := generateCNPGCluster(dci, spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		updated, err := getUpdatedOperatorSpec(INFRASTRUCTURE_POSTGRESQL, existing, generated)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return updated
+	}
+
+	t.Run("unchanged", func(t *testing.T) {
+		if spec := update(t, &v1beta1.InfrastructureComponentSpec{Replicas: &one, Image: "pg:15"}); spec != nil {
+			t.Errorf("expected no update, got %v", spec)
+		}
+	})
+	t.Run("replicas keep operator defaults", func(t *testing.T) {
+		spec := update(t, &v1beta1.InfrastructureComponentSpec{Replicas: &three, Image: "pg:15"})
+		if instances, _, _ := unstructured.NestedInt64(spec, "instances"); instances != 3 {
+			t.Errorf("expected 3 instances, got %d", instances)
+		}
+		if delay, _, _ := unstructured.NestedString(spec, "switchoverDelay"); delay != "5s" {
+			t.Errorf("operator default was not kept: %v", spec)
+		}
+		if resize, _, _ := unstructured.NestedBool(spec, "storage", "resizeInUseVolumes"); !resize {
+			t.Errorf("nested operator default was not kept: %v", spec)
+		}
+	})
+	t.Run("removed image is cleared", func(t *testing.T) {
+		spec := update(t, &v1beta1.InfrastructureComponentSpec{Replicas: &one})
+		if _, found, _ := unstructured.NestedString(spec, "imageName"); spec == nil || found {
+			t.Errorf("expected image to be removed, got %v", spec)
+		}
+	})
+}
@@ -20,13 +20,16 @@ import (
 	"context"
 	"fmt"
 
+	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	corev1beta1 "github.com/devicechain-io/dc-k8s/api/v1beta1"
 )
@@ -44,6 +47,18 @@ type InstanceReconciler struct {
 //+kubebuilder:rbac:groups=core.devicechain.io,resources=instances,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core.devicechain.io,resources=instances/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=core.devicechain.io,resources=instances/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=configmaps;services,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=resourcequotas;limitranges,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=kafka.strimzi.io,resources=kafkas,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=postgresql.cnpg.io,resources=clusters,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core.devicechain.io,resources=tenants,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core.devicechain.io,resources=clusters,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core.devicechain.io,resources=microservices,verbs=get;list;watch
+//+kubebuilder:rbac:groups=keda.sh,resources=scaledobjects,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -74,10 +89,16 @@ func (r *InstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 
 	// Locate namespace same as instance id and create if not existing
 	instanceid := instance.ObjectMeta.Name
-	_, err = getNamespace(instanceid)
+	nslabels := getPodSecurityLabels(instance)
+	ns, err := getNamespace(instanceid)
 	if err != nil {
 		log.Info(fmt.Sprintf("Instance namespace not found. Creating namespace '%s'", instanceid))
-		_, err = createNamespace(instanceid)
+		_, err = createNamespace(instanceid, nslabels)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	} else {
+		err = updateNamespaceLabels(ns, nslabels)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
@@ -93,24 +114,94 @@ func (r *InstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		log.Info(fmt.Sprintf("Created instance config map '%s'", cmap.ObjectMeta.Name))
 	}
 
-	return ctrl.Result{}, nil
+	// Create, update or remove namespace resource quota and limit range.
+	err = r.reconcileInstanceResourceQuota(ctx, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	err = r.reconcileInstanceLimitRange(ctx, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Record current and maximum tenant counts.
+	err = r.reconcileInstanceTenantCount(ctx, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Record dependency graph of instance microservices.
+	err = r.reconcileDependencyGraph(ctx, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Provision shared infrastructure, polling until all components are ready.
+	ready, err := r.reconcileInstanceInfrastructure(ctx, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Create or update backend serving requests for unavailable tenants.
+	err = r.reconcileMaintenanceBackend(ctx, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Enter or leave maintenance mode, requeuing at the next window boundary.
+	next, err := r.reconcileInstanceMaintenance(ctx, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Copy image pull secrets into instance namespace, resyncing periodically.
+	sync, err := r.reconcileImagePullSecrets(ctx, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if sync && (next == 0 || next > PULL_SECRET_SYNC_INTERVAL) {
+		next = PULL_SECRET_SYNC_INTERVAL
+	}
+	if !ready && (next == 0 || next > INFRASTRUCTURE_POLL_INTERVAL) {
+		next = INFRASTRUCTURE_POLL_INTERVAL
+	}
+
+	return ctrl.Result{RequeueAfter: next}, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *InstanceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&corev1beta1.Instance{}).
+		Watches(&source.Kind{Type: &appsv1.StatefulSet{}},
+			handler.EnqueueRequestsFromMapFunc(r.findInstanceForInfrastructure)).
+		Watches(&source.Kind{Type: &corev1beta1.Tenant{}},
+			handler.EnqueueRequestsFromMapFunc(r.findInstanceForObject)).
+		Watches(&source.Kind{Type: &corev1beta1.Microservice{}},
+			handler.EnqueueRequestsFromMapFunc(r.findInstanceForObject)).
 		Complete(r)
 }
 
 // Handle case where instance has been deleted.
 func (r *InstanceReconciler) handleInstanceDeleted(ctx context.Context, req ctrl.Request) error {
+	err := r.deleteImagePullSecrets(ctx, req.Name)
+	if err != nil {
+		return err
+	}
+	err = r.deleteMaintenanceBackend(ctx, req.Name)
+	if err != nil {
+		return err
+	}
+	err = r.deleteInstanceInfrastructure(ctx, req.Name)
+	if err != nil {
+		return err
+	}
 	return deleteInstanceConfigMap(ctx, req)
 }
 
 // Create a new namespace
-func createNamespace(nsid string) (*v1.Namespace, error) {
-	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: nsid}}
+func createNamespace(nsid string, labels map[string]string) (*v1.Namespace, error) {
+	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: nsid, Labels: labels}}
 
 	// Attempt to create the namespace.
 	err := corev1beta1.V1Client.Create(context.Background(), ns)
@@ -132,6 +223,24 @@ func getNamespace(nsid string) (*v1.Namespace, error) {
 	return ns, nil
 }
 
+// Update namespace with the given labels if not already present
+func updateNamespaceLabels(ns *v1.Namespace, labels map[string]string) error {
+	changed := false
+	for key, value := range labels {
+		if ns.ObjectMeta.Labels[key] != value {
+			if ns.ObjectMeta.Labels == nil {
+				ns.ObjectMeta.Labels = make(map[string]string)
+			}
+			ns.ObjectMeta.Labels[key] = value
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return corev1beta1.V1Client.Update(context.Background(), ns)
+}
+
 // Get name of instance config map
 func getInstanceConfigMapName(iname string) string {
 	return fmt.Sprintf("%s-%s-%s", "dci", iname, "config")
@@ -0,0 +1,226 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+const (
+	// Label indicating the purpose of a tenant job.
+	LABEL_JOB = "devicechain.io.job"
+
+	// Number of retries for tenant jobs if not specified.
+	DEFAULT_JOB_BACKOFF_LIMIT = 3
+)
+
+// Get namespaced name of a job run for a tenant microservice.
+func getTenantJobName(tms *v1beta1.TenantMicroservice, purpose string) types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: tms.ObjectMeta.Namespace,
+		Name:      fmt.Sprintf("%s-%s", tms.ObjectMeta.Name, purpose),
+	}
+}
+
+// Generate a job which runs a tenant job spec for a tenant microservice. Job pods carry the
+// tenant label (so tenant network policies apply) but not the microservice label, which
+// would add them to the tenant microservice service.
+func generateTenantJob(tms *v1beta1.TenantMicroservice, dct *v1beta1.Tenant, ms *v1beta1.Microservice,
+	dci *v1beta1.Instance, spec *v1beta1.TenantJobSpec, purpose string) *batchv1.Job {
+	jname := getTenantJobName(tms, purpose)
+	labels := map[string]string{
+		v1beta1.LABEL_TENANT: tms.Spec.TenantId,
+		LABEL_JOB:            purpose,
+	}
+
+	image := spec.Image
+	if image == "" {
+		image = getContainerImage(tms, ms)
+	}
+	backoff := int32(DEFAULT_JOB_BACKOFF_LIMIT)
+	if spec.BackoffLimit != nil {
+		backoff = *spec.BackoffLimit
+	}
+
+	container := corev1.Container{
+		Name:            purpose,
+		Image:           image,
+		ImagePullPolicy: ms.Spec.ImagePullPolicy,
+		Command:         spec.Command,
+		Args:            spec.Args,
+		Env:             append(getTenantEnvVars(tms, dct, ms), spec.Env...),
+		VolumeMounts:    getTenantVolumeMounts(),
+	}
+	if spec.Resources != nil {
+		container.Resources = *spec.Resources.DeepCopy()
+	}
+
+	// Tenant configuration may already be removed when a job runs after tenant deletion.
+	optional := true
+	volumes := getTenantVolumes(tms, dci)
+	for i := range volumes {
+		if volumes[i].ConfigMap != nil {
+			volumes[i].ConfigMap.Optional = &optional
+		}
+		if volumes[i].Secret != nil {
+			volumes[i].Secret.Optional = &optional
+		}
+	}
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      jname.Name,
+			Namespace: jname.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &backoff,
+			ActiveDeadlineSeconds: spec.ActiveDeadlineSeconds,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: getServiceAccountName(tms).Name,
+					ImagePullSecrets:   getImagePullSecrets(dci, ms),
+					RestartPolicy:      corev1.RestartPolicyNever,
+					Containers:         []corev1.Container{container},
+					Volumes:            volumes,
+				},
+			},
+		},
+	}
+	applyScheduling(&job.Spec.Template.Spec, labels, dci, ms, tms)
+	applySecurityContext(&job.Spec.Template.Spec, ms)
+	return job
+}
+
+// Get the phase of a job and the reason it failed (if any).
+func getJobPhase(job *batchv1.Job) (v1beta1.JobPhase, string) {
+	for _, cond := range job.Status.Conditions {
+		if cond.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch cond.Type {
+		case batchv1.JobComplete:
+			return v1beta1.JobSucceeded, ""
+		case batchv1.JobFailed:
+			return v1beta1.JobFailed, cond.Message
+		}
+	}
+	return v1beta1.JobRunning, ""
+}
+
+// Get an existing tenant job or create it (owned by the tenant microservice) if not found.
+func (r *TenantMicroserviceReconciler) getOrCreateTenantJob(ctx context.Context, tms *v1beta1.TenantMicroservice,
+	job *batchv1.Job) (*batchv1.Job, error) {
+	log := logf.FromContext(ctx)
+
+	existing := &batchv1.Job{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: job.ObjectMeta.Namespace, Name: job.ObjectMeta.Name}, existing)
+	if err == nil {
+		return existing, nil
+	}
+	if !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	// Job images are not checked by reconcileImage so they are checked before the job is created.
+	for _, container := range job.Spec.Template.Spec.Containers {
+		violation, err := checkImagePolicy(ctx, r.Client, container.Image)
+		if err != nil {
+			return nil, err
+		}
+		if violation != "" {
+			return nil, fmt.Errorf("unable to create job '%s': %s", job.ObjectMeta.Name, violation)
+		}
+	}
+	if err := controllerutil.SetControllerReference(tms, job, r.Scheme); err != nil {
+		return nil, err
+	}
+	if err := r.Create(ctx, job); err != nil {
+		return nil, err
+	}
+	log.Info(fmt.Sprintf("Created job '%s' for tenant microservice '%s'", job.ObjectMeta.Name, tms.ObjectMeta.Name))
+	return job, nil
+}
+
+// Delete failed provisioning, migration, datastore, deprovisioning and datastore drop jobs when
+// requested by annotation so they are run again. Failed jobs are otherwise kept so the failure
+// can be inspected.
+func (r *TenantMicroserviceReconciler) handleJobRetryRequest(ctx context.Context, tms *v1beta1.TenantMicroservice) error {
+	log := logf.FromContext(ctx)
+
+	if _, found := tms.ObjectMeta.Annotations[v1beta1.ANNOTATION_RETRY_JOBS]; !found {
+		return nil
+	}
+	for _, purpose := range []string{JOB_PROVISION, JOB_MIGRATE, JOB_DATASTORE, JOB_DEPROVISION, JOB_DATASTORE_DROP} {
+		job := &batchv1.Job{}
+		if err := r.Get(ctx, getTenantJobName(tms, purpose), job); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		if phase, _ := getJobPhase(job); phase != v1beta1.JobFailed {
+			continue
+		}
+		err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		log.Info(fmt.Sprintf("Retrying failed job '%s' for tenant microservice '%s'", job.ObjectMeta.Name,
+			tms.ObjectMeta.Name))
+	}
+
+	delete(tms.ObjectMeta.Annotations, v1beta1.ANNOTATION_RETRY_JOBS)
+	if err := r.Update(ctx, tms); err != nil {
+		return err
+	}
+	if tms.Status.ProvisioningPhase == v1beta1.JobFailed {
+		tms.Status.ProvisioningPhase = ""
+		tms.Status.ProvisioningMessage = ""
+	}
+	if tms.Status.DeprovisioningPhase == v1beta1.JobFailed {
+		tms.Status.DeprovisioningPhase = ""
+		tms.Status.DeprovisioningMessage = ""
+	}
+	if tms.Status.Migration != nil && tms.Status.Migration.Phase == v1beta1.JobFailed {
+		tms.Status.Migration = nil
+	}
+	if tms.Status.Datastore != nil && tms.Status.Datastore.Phase == v1beta1.JobFailed {
+		tms.Status.Datastore.Phase = ""
+		tms.Status.Datastore.Message = ""
+	}
+	if tms.Status.Datastore != nil && tms.Status.Datastore.DropPhase == v1beta1.JobFailed {
+		tms.Status.Datastore.DropPhase = ""
+		tms.Status.Datastore.DropMessage = ""
+	}
+	return r.Status().Update(ctx, tms)
+}
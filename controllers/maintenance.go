@@ -0,0 +1,618 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+const (
+	// Default image serving the maintenance backend (runs as non-root).
+	MAINTENANCE_IMAGE = "nginxinc/nginx-unprivileged:1.23-alpine"
+
+	// Port answering requests for suspended tenants.
+	MAINTENANCE_PORT = 8080
+
+	// Port answering requests while the instance is in maintenance mode.
+	INSTANCE_MAINTENANCE_PORT = 8081
+
+	// Label identifying maintenance backend pods.
+	LABEL_MAINTENANCE = "devicechain.io.maintenance"
+
+	// Message returned by the maintenance backend if not configured.
+	DEFAULT_MAINTENANCE_MESSAGE = "Service temporarily unavailable"
+
+	// Path at which maintenance response bodies are mounted.
+	MAINTENANCE_CONTENT_PATH = "/etc/nginx/maintenance"
+)
+
+// Get name used for maintenance backend resources in an instance namespace.
+func getMaintenanceName(ns string) string {
+	return fmt.Sprintf("%s-%s-%s", "dci", ns, "maintenance")
+}
+
+// Get labels applied to maintenance backend pods.
+func getMaintenanceLabels(ns string) map[string]string {
+	return map[string]string{
+		LABEL_MAINTENANCE: ns,
+	}
+}
+
+// Indicates whether an instance is in maintenance mode.
+func isInstanceInMaintenance(dci *v1beta1.Instance) bool {
+	return dci.Status.Maintenance != nil && dci.Status.Maintenance.Active
+}
+
+// Indicates whether tenant workloads of an instance are scaled down for maintenance.
+func isInstanceScaledDown(dci *v1beta1.Instance) bool {
+	return isInstanceInMaintenance(dci) && dci.Status.Maintenance.ScaledDown
+}
+
+// Indicates whether maintenance is requested at the given time. Returns the time of the
+// next window boundary (zero if none).
+func isMaintenanceRequested(spec *v1beta1.MaintenanceSpec, now time.Time) (bool, time.Time) {
+	if spec == nil {
+		return false, time.Time{}
+	}
+	if spec.Window != nil {
+		if now.Before(spec.Window.Start.Time) {
+			return spec.Enabled, spec.Window.Start.Time
+		}
+		if now.Before(spec.Window.End.Time) {
+			return true, spec.Window.End.Time
+		}
+	}
+	return spec.Enabled, time.Time{}
+}
+
+// Generate a JSON error body with the given message.
+func generateMaintenanceMessage(message string) (string, error) {
+	body, err := json.Marshal(map[string]string{"error": message})
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// Generate nginx server block which answers every request with a fixed response body.
+func generateMaintenanceServer(port int, status int32, ctype string, file string) string {
+	return fmt.Sprintf(`server {
+    listen %d;
+    root %s;
+    error_page %d /%s;
+    location / {
+        return %d;
+    }
+    location = /%s {
+        internal;
+        default_type "%s";
+        add_header Retry-After 120 always;
+    }
+}
+`, port, MAINTENANCE_CONTENT_PATH, status, file, status, file, ctype)
+}
+
+// Generate configuration map content for the maintenance backend. Suspended tenants get the
+// default message while instance maintenance uses the configured response.
+func generateMaintenanceConfig(dci *v1beta1.Instance) (map[string]string, error) {
+	suspended, err := generateMaintenanceMessage(DEFAULT_MAINTENANCE_MESSAGE)
+	if err != nil {
+		return nil, err
+	}
+	status := int32(http.StatusServiceUnavailable)
+	ctype := "application/json"
+	instance := suspended
+	if spec := dci.Spec.Maintenance; spec != nil {
+		if spec.StaticResponse != nil {
+			if spec.StaticResponse.StatusCode != 0 {
+				status = spec.StaticResponse.StatusCode
+			}
+			ctype = "text/html"
+			if spec.StaticResponse.ContentType != "" {
+				ctype = spec.StaticResponse.ContentType
+			}
+			instance = spec.StaticResponse.Body
+		} else if spec.Message != "" {
+			if instance, err = generateMaintenanceMessage(spec.Message); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	conf := generateMaintenanceServer(MAINTENANCE_PORT, http.StatusServiceUnavailable, "application/json", "suspended") +
+		generateMaintenanceServer(INSTANCE_MAINTENANCE_PORT, status, ctype, "instance")
+	return map[string]string{
+		"default.conf": conf,
+		"suspended":    suspended,
+		"instance":     instance,
+	}, nil
+}
+
+// Generate the maintenance backend deployment for an instance.
+func generateMaintenanceDeployment(dci *v1beta1.Instance, image string) *appsv1.Deployment {
+	ns := dci.ObjectMeta.Name
+	name := getMaintenanceName(ns)
+	labels := getMaintenanceLabels(ns)
+	replicas := int32(1)
+	nonroot := true
+	readonly := true
+	escalation := false
+	automount := false
+
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+			Labels:    labels,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					AutomountServiceAccountToken: &automount,
+					ImagePullSecrets:             getInstanceImagePullSecrets(dci),
+					SecurityContext: &corev1.PodSecurityContext{
+						RunAsNonRoot: &nonroot,
+						SeccompProfile: &corev1.SeccompProfile{
+							Type: corev1.SeccompProfileTypeRuntimeDefault,
+						},
+					},
+					Containers: []corev1.Container{
+						{
+							Name:    "maintenance",
+							Image:   image,
+							Command: []string{"nginx", "-g", "daemon off;"},
+							// Explicit resources keep the backend admissible under instance resource quotas.
+							Resources: corev1.ResourceRequirements{
+								Requests: corev1.ResourceList{
+									corev1.ResourceCPU:    resource.MustParse("10m"),
+									corev1.ResourceMemory: resource.MustParse("16Mi"),
+								},
+								Limits: corev1.ResourceList{
+									corev1.ResourceCPU:    resource.MustParse("100m"),
+									corev1.ResourceMemory: resource.MustParse("64Mi"),
+								},
+							},
+							Ports: []corev1.ContainerPort{
+								{
+									Name:          "suspended",
+									ContainerPort: MAINTENANCE_PORT,
+									Protocol:      corev1.ProtocolTCP,
+								}, {
+									Name:          "maintenance",
+									ContainerPort: INSTANCE_MAINTENANCE_PORT,
+									Protocol:      corev1.ProtocolTCP,
+								},
+							},
+							SecurityContext: &corev1.SecurityContext{
+								RunAsNonRoot:             &nonroot,
+								ReadOnlyRootFilesystem:   &readonly,
+								AllowPrivilegeEscalation: &escalation,
+								Capabilities: &corev1.Capabilities{
+									Drop: []corev1.Capability{"ALL"},
+								},
+							},
+							VolumeMounts: []corev1.VolumeMount{
+								{
+									Name:      "config",
+									MountPath: "/etc/nginx/conf.d",
+									ReadOnly:  true,
+								}, {
+									Name:      "content",
+									MountPath: MAINTENANCE_CONTENT_PATH,
+									ReadOnly:  true,
+								}, {
+									Name:      TMP_VOLUME_NAME,
+									MountPath: TMP_MOUNT_PATH,
+								},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "config",
+							VolumeSource: corev1.VolumeSource{
+								ConfigMap: &corev1.ConfigMapVolumeSource{
+									LocalObjectReference: corev1.LocalObjectReference{
+										Name: name,
+									},
+									Items: []corev1.KeyToPath{
+										{Key: "default.conf", Path: "default.conf"},
+									},
+								},
+							},
+						},
+						{
+							Name: "content",
+							VolumeSource: corev1.VolumeSource{
+								ConfigMap: &corev1.ConfigMapVolumeSource{
+									LocalObjectReference: corev1.LocalObjectReference{
+										Name: name,
+									},
+									Items: []corev1.KeyToPath{
+										{Key: "suspended", Path: "suspended"},
+										{Key: "instance", Path: "instance"},
+									},
+								},
+							},
+						},
+						{
+							Name: TMP_VOLUME_NAME,
+							VolumeSource: corev1.VolumeSource{
+								EmptyDir: &corev1.EmptyDirVolumeSource{},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// Generate the service exposing the maintenance backend.
+func generateMaintenanceService(ns string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getMaintenanceName(ns),
+			Namespace: ns,
+			Labels:    getMaintenanceLabels(ns),
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{
+					Name:     "suspended",
+					Protocol: corev1.ProtocolTCP,
+					Port:     MAINTENANCE_PORT,
+				}, {
+					Name:     "maintenance",
+					Protocol: corev1.ProtocolTCP,
+					Port:     INSTANCE_MAINTENANCE_PORT,
+				},
+			},
+			Selector: getMaintenanceLabels(ns),
+		},
+	}
+}
+
+// Create or update the maintenance backend which answers requests for unavailable tenants.
+func (r *InstanceReconciler) reconcileMaintenanceBackend(ctx context.Context, dci *v1beta1.Instance) error {
+	log := logf.FromContext(ctx)
+	ns := dci.ObjectMeta.Name
+	name := getMaintenanceName(ns)
+
+	// Create or update nginx configuration and response content.
+	data, err := generateMaintenanceConfig(dci)
+	if err != nil {
+		return err
+	}
+	cmap := &corev1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: ns, Name: name}, cmap); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		cmap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: ns,
+				Labels:    getMaintenanceLabels(ns),
+			},
+			Data: data,
+		}
+		if err := r.Create(ctx, cmap); err != nil {
+			return err
+		}
+	} else if !reflect.DeepEqual(cmap.Data, data) {
+		cmap.Data = data
+		if err := r.Update(ctx, cmap); err != nil {
+			return err
+		}
+		if err := restartDeployments(ctx, r.Client, ns, getMaintenanceLabels(ns)); err != nil {
+			return err
+		}
+	}
+
+	// Create or update deployment unless the image is not allowed by the cluster image policy.
+	images, err := getSystemImages(ctx, r.Client)
+	if err != nil {
+		return err
+	}
+	violation, err := checkImagePolicy(ctx, r.Client, images.Maintenance)
+	if err != nil {
+		return err
+	}
+	if violation != "" {
+		log.Info(fmt.Sprintf("Not deploying maintenance backend for instance '%s': %s", ns, violation))
+	} else {
+		updated := generateMaintenanceDeployment(dci, images.Maintenance)
+		deploy := &appsv1.Deployment{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: ns, Name: name}, deploy); err != nil {
+			if !errors.IsNotFound(err) {
+				return err
+			}
+			hash, err := getPodTemplateHash(updated)
+			if err != nil {
+				return err
+			}
+			metav1.SetMetaDataAnnotation(&updated.ObjectMeta, ANNOTATION_TEMPLATE_HASH, hash)
+			if err := r.Create(ctx, updated); err != nil {
+				return err
+			}
+			log.Info(fmt.Sprintf("Created maintenance backend for instance '%s'", ns))
+		} else {
+			preserveRestartAnnotation(deploy, updated)
+			hash, err := getPodTemplateHash(updated)
+			if err != nil {
+				return err
+			}
+			if deploy.ObjectMeta.Annotations[ANNOTATION_TEMPLATE_HASH] != hash ||
+				!equality.Semantic.DeepDerivative(updated.Spec.Template, deploy.Spec.Template) {
+				metav1.SetMetaDataAnnotation(&deploy.ObjectMeta, ANNOTATION_TEMPLATE_HASH, hash)
+				deploy.Spec.Template = updated.Spec.Template
+				if err := r.Update(ctx, deploy); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// Create or update service.
+	generated := generateMaintenanceService(ns)
+	service := &corev1.Service{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: ns, Name: name}, service); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		return r.Create(ctx, generated)
+	}
+	if len(service.Spec.Ports) != len(generated.Spec.Ports) {
+		service.Spec.Ports = generated.Spec.Ports
+		return r.Update(ctx, service)
+	}
+	return nil
+}
+
+// Get names of suspended tenants in an instance namespace.
+func (r *InstanceReconciler) getSuspendedTenants(ctx context.Context, ns string) (map[string]bool, error) {
+	tenants := &v1beta1.TenantList{}
+	if err := r.List(ctx, tenants, client.InNamespace(ns)); err != nil {
+		return nil, err
+	}
+	suspended := make(map[string]bool)
+	for _, tenant := range tenants.Items {
+		if isTenantSuspended(&tenant) {
+			suspended[tenant.ObjectMeta.Name] = true
+		}
+	}
+	return suspended, nil
+}
+
+// Pause or resume KEDA autoscaling for tenant deployments of an instance. Suspended
+// tenants remain paused.
+func (r *InstanceReconciler) pauseInstanceScaledObjects(ctx context.Context, ns string, paused bool,
+	suspended map[string]bool) error {
+	solist := &unstructured.UnstructuredList{}
+	solist.SetGroupVersionKind(scaledObjectGVK.GroupVersion().WithKind(scaledObjectGVK.Kind + "List"))
+	if err := r.List(ctx, solist, client.InNamespace(ns), client.HasLabels{v1beta1.LABEL_TENANT}); err != nil {
+		// Nothing to pause on a cluster without KEDA installed.
+		if meta.IsNoMatchError(err) {
+			return nil
+		}
+		return err
+	}
+	for i := range solist.Items {
+		so := &solist.Items[i]
+		if setScaledObjectPaused(so, paused || suspended[so.GetLabels()[v1beta1.LABEL_TENANT]]) {
+			if err := r.Update(ctx, so); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Scale tenant deployments down for maintenance or restore them afterward.
+func (r *InstanceReconciler) scaleInstanceWorkloads(ctx context.Context, dci *v1beta1.Instance,
+	status *v1beta1.MaintenanceStatus) error {
+	ns := dci.ObjectMeta.Name
+	deploys := &appsv1.DeploymentList{}
+	if err := r.List(ctx, deploys, client.InNamespace(ns), client.HasLabels{v1beta1.LABEL_TENANT}); err != nil {
+		return err
+	}
+	suspended, err := r.getSuspendedTenants(ctx, ns)
+	if err != nil {
+		return err
+	}
+
+	if status.ScaledDown {
+		// Record replica counts before scaling so they survive a failure part way through.
+		if status.ScaledReplicas == nil {
+			status.ScaledReplicas = make(map[string]int32)
+		}
+		for _, deploy := range deploys.Items {
+			if _, found := status.ScaledReplicas[deploy.ObjectMeta.Name]; found {
+				continue
+			}
+			if deploy.Spec.Replicas == nil || *deploy.Spec.Replicas > 0 {
+				replicas := int32(1)
+				if deploy.Spec.Replicas != nil {
+					replicas = *deploy.Spec.Replicas
+				}
+				status.ScaledReplicas[deploy.ObjectMeta.Name] = replicas
+			}
+		}
+		if dci.Status.Maintenance == nil || !reflect.DeepEqual(*status, *dci.Status.Maintenance) {
+			dci.Status.Maintenance = status
+			if err := r.Status().Update(ctx, dci); err != nil {
+				return err
+			}
+		}
+		if err := r.pauseInstanceScaledObjects(ctx, ns, true, suspended); err != nil {
+			return err
+		}
+		for i := range deploys.Items {
+			deploy := &deploys.Items[i]
+			if deploy.Spec.Replicas != nil && *deploy.Spec.Replicas == 0 {
+				continue
+			}
+			deploy.Spec.Replicas = new(int32)
+			if err := r.Update(ctx, deploy); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Record that workloads are no longer scaled down before restoring them, so tenant
+	// microservice reconciles triggered by the instance change do not scale them down again.
+	if dci.Status.Maintenance == nil || !reflect.DeepEqual(*status, *dci.Status.Maintenance) {
+		dci.Status.Maintenance = status.DeepCopy()
+		if err := r.Status().Update(ctx, dci); err != nil {
+			return err
+		}
+	}
+
+	// Restore recorded replica counts, leaving suspended tenants scaled down.
+	for i := range deploys.Items {
+		deploy := &deploys.Items[i]
+		replicas, found := status.ScaledReplicas[deploy.ObjectMeta.Name]
+		if !found || suspended[deploy.ObjectMeta.Labels[v1beta1.LABEL_TENANT]] {
+			continue
+		}
+		deploy.Spec.Replicas = &replicas
+		if err := r.Update(ctx, deploy); err != nil {
+			return err
+		}
+	}
+	if err := r.pauseInstanceScaledObjects(ctx, ns, false, suspended); err != nil {
+		return err
+	}
+	status.ScaledReplicas = nil
+	return nil
+}
+
+// Enter or leave maintenance mode based on instance settings, routing tenant ingresses to the
+// maintenance backend while active. Returns the time until the next maintenance window boundary.
+func (r *InstanceReconciler) reconcileInstanceMaintenance(ctx context.Context,
+	dci *v1beta1.Instance) (time.Duration, error) {
+	log := logf.FromContext(ctx)
+	ns := dci.ObjectMeta.Name
+
+	now := time.Now()
+	active, boundary := isMaintenanceRequested(dci.Spec.Maintenance, now)
+	previous := dci.Status.Maintenance
+	if previous == nil {
+		previous = &v1beta1.MaintenanceStatus{}
+	}
+	if !active && !previous.Active && len(previous.ScaledReplicas) == 0 {
+		if dci.Status.Maintenance == nil {
+			return 0, nil
+		}
+		dci.Status.Maintenance = nil
+		return 0, r.Status().Update(ctx, dci)
+	}
+
+	status := previous.DeepCopy()
+	status.Active = active
+	status.ScaledDown = active && dci.Spec.Maintenance.ScaleDown
+	status.WindowStart = nil
+	status.WindowEnd = nil
+	if dci.Spec.Maintenance != nil && dci.Spec.Maintenance.Window != nil {
+		status.WindowStart = dci.Spec.Maintenance.Window.Start.DeepCopy()
+		status.WindowEnd = dci.Spec.Maintenance.Window.End.DeepCopy()
+	}
+	if active && !previous.Active {
+		since := metav1.NewTime(now)
+		status.Since = &since
+		log.Info(fmt.Sprintf("Instance '%s' entering maintenance mode", ns))
+	} else if !active {
+		status.Since = nil
+		if previous.Active {
+			log.Info(fmt.Sprintf("Instance '%s' leaving maintenance mode", ns))
+		}
+	}
+
+	if status.ScaledDown || len(status.ScaledReplicas) > 0 {
+		if err := r.scaleInstanceWorkloads(ctx, dci, status); err != nil {
+			return 0, err
+		}
+	}
+	if !reflect.DeepEqual(status, dci.Status.Maintenance) {
+		dci.Status.Maintenance = status
+		if err := r.Status().Update(ctx, dci); err != nil {
+			return 0, err
+		}
+	}
+
+	// Route tenant ingresses to or away from the maintenance backend.
+	if active != previous.Active {
+		tenants := &v1beta1.TenantList{}
+		if err := r.List(ctx, tenants, client.InNamespace(ns)); err != nil {
+			return 0, err
+		}
+		for i := range tenants.Items {
+			if err := reconcileTenantIngress(ctx, r.Client, &tenants.Items[i], dci); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if boundary.IsZero() {
+		return 0, nil
+	}
+	return boundary.Sub(now), nil
+}
+
+// Delete the maintenance backend for an instance.
+func (r *InstanceReconciler) deleteMaintenanceBackend(ctx context.Context, ns string) error {
+	key := client.ObjectKey{Namespace: ns, Name: getMaintenanceName(ns)}
+	for _, obj := range []client.Object{&corev1.Service{}, &appsv1.Deployment{}, &corev1.ConfigMap{}} {
+		if err := r.Get(ctx, key, obj); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		if err := r.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
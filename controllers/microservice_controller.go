@@ -18,11 +18,18 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"reflect"
 
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	corev1beta1 "github.com/devicechain-io/dc-k8s/api/v1beta1"
 )
@@ -36,27 +43,73 @@ type MicroserviceReconciler struct {
 //+kubebuilder:rbac:groups=core.devicechain.io,resources=microservices,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core.devicechain.io,resources=microservices/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=core.devicechain.io,resources=microservices/finalizers,verbs=update
-
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the Microservice object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
-//
-// For more details, check Reconcile and its Result here:
-// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.11.0/pkg/reconcile
+//+kubebuilder:rbac:groups=core.devicechain.io,resources=tenantmicroservices,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=core.devicechain.io,resources=tenants,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core.devicechain.io,resources=instances,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch
 func (r *MicroserviceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = log.FromContext(ctx)
+	log := logf.FromContext(ctx)
+
+	ms := &corev1beta1.Microservice{}
+	if err := r.Get(ctx, req.NamespacedName, ms); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
 
-	// TODO(user): your logic here
+	// Record security exceptions applied to microservice pods and whether the instance admits them.
+	dci := &corev1beta1.Instance{}
+	if err := r.Get(ctx, client.ObjectKey{Name: ms.ObjectMeta.Namespace}, dci); err != nil {
+		return ctrl.Result{}, err
+	}
+	exceptions := getSecurityExceptions(ms)
+	if len(exceptions) == 0 {
+		exceptions = nil
+	}
+	violation := getPodSecurityViolation(dci, ms)
+	if !reflect.DeepEqual(exceptions, ms.Status.SecurityExceptions) || violation != ms.Status.PodSecurityError {
+		ms.Status.SecurityExceptions = exceptions
+		ms.Status.PodSecurityError = violation
+		if err := r.Status().Update(ctx, ms); err != nil {
+			return ctrl.Result{}, err
+		}
+		if violation != "" {
+			log.Info(fmt.Sprintf("Microservice %+v will not be rolled out: %s", req.NamespacedName, violation))
+		} else if exceptions != nil {
+			log.Info(fmt.Sprintf("Microservice %+v running with security exceptions: %v", req.NamespacedName, exceptions))
+		}
+	}
 
-	return ctrl.Result{}, nil
+	// Roll out image changes across tenants.
+	original := ms.Status.DeepCopy()
+	requeue, err := r.reconcileRollout(ctx, ms)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	return r.updateRolloutStatus(ctx, ms, original, requeue)
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *MicroserviceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&corev1beta1.Microservice{}).
+		Watches(&source.Kind{Type: &corev1beta1.Instance{}},
+			handler.EnqueueRequestsFromMapFunc(r.findMicroservicesForInstance)).
 		Complete(r)
 }
+
+// Find microservices affected by a change to an instance.
+func (r *MicroserviceReconciler) findMicroservicesForInstance(obj client.Object) []reconcile.Request {
+	mslist := &corev1beta1.MicroserviceList{}
+	if err := r.List(context.Background(), mslist, client.InNamespace(obj.GetName())); err != nil {
+		return nil
+	}
+	requests := make([]reconcile.Request, 0)
+	for _, ms := range mslist.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: ms.ObjectMeta.Namespace, Name: ms.ObjectMeta.Name},
+		})
+	}
+	return requests
+}
@@ -0,0 +1,196 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+	"github.com/devicechain-io/dc-k8s/registry"
+)
+
+const (
+	// Purpose of the job run when the image of a tenant microservice changes.
+	JOB_MIGRATE = "migrate"
+
+	// Annotation on a migration job holding the image it migrates to.
+	ANNOTATION_MIGRATION_IMAGE = "devicechain.io/migration-image"
+
+	// Environment variables describing the migration to the job.
+	ENV_MIGRATION_FROM_IMAGE = "DC_MIGRATION_FROM_IMAGE"
+	ENV_MIGRATION_TO_IMAGE   = "DC_MIGRATION_TO_IMAGE"
+	ENV_MIGRATION_VERSION    = "DC_MIGRATION_VERSION"
+)
+
+// Get the version an image migrates to (its tag, or digest if not tagged).
+func getMigrationVersion(image string) string {
+	ref, err := registry.ParseReference(image)
+	if err != nil {
+		return image
+	}
+	if ref.Tag != "" {
+		return ref.Tag
+	}
+	return ref.Digest
+}
+
+// Generate the job migrating a tenant microservice between images.
+func generateMigrationJob(tms *v1beta1.TenantMicroservice, dct *v1beta1.Tenant, ms *v1beta1.Microservice,
+	dci *v1beta1.Instance, from string, to string) *batchv1.Job {
+	spec := ms.Spec.MigrationJob.DeepCopy()
+	spec.Env = append([]corev1.EnvVar{
+		{
+			Name:  ENV_MIGRATION_FROM_IMAGE,
+			Value: from,
+		},
+		{
+			Name:  ENV_MIGRATION_TO_IMAGE,
+			Value: to,
+		},
+		{
+			Name:  ENV_MIGRATION_VERSION,
+			Value: getMigrationVersion(getRequestedImage(tms, ms)),
+		},
+	}, spec.Env...)
+
+	job := generateTenantJob(tms, dct, ms, dci, spec, JOB_MIGRATE)
+	job.ObjectMeta.Annotations = map[string]string{ANNOTATION_MIGRATION_IMAGE: to}
+	return job
+}
+
+// Record the state of a migration job in tenant microservice status.
+func (r *TenantMicroserviceReconciler) setMigrationStatus(ctx context.Context, tms *v1beta1.TenantMicroservice,
+	ms *v1beta1.Microservice, job *batchv1.Job, from string, to string) error {
+	log := logf.FromContext(ctx)
+
+	phase, message := getJobPhase(job)
+	status := &v1beta1.MigrationStatus{
+		FromImage: from,
+		Image:     to,
+		Version:   getMigrationVersion(getRequestedImage(tms, ms)),
+		Phase:     phase,
+		Message:   message,
+	}
+	now := metav1.Now()
+	if previous := tms.Status.Migration; previous != nil && previous.Image == to {
+		status.StartedAt = previous.StartedAt
+		status.CompletedAt = previous.CompletedAt
+	}
+	if status.StartedAt == nil {
+		status.StartedAt = &now
+	}
+	if phase == v1beta1.JobRunning {
+		status.CompletedAt = nil
+	} else if status.CompletedAt == nil {
+		status.CompletedAt = &now
+	}
+
+	if equality.Semantic.DeepEqual(status, tms.Status.Migration) {
+		return nil
+	}
+	log.Info(fmt.Sprintf("Migration of tenant microservice '%s' to version '%s': %s", tms.ObjectMeta.Name,
+		status.Version, phase))
+	tms.Status.Migration = status
+	return r.Status().Update(ctx, tms)
+}
+
+// Indicates whether two image references run the same image. A tag and the digest it was
+// resolved to for the tenant microservice are the same image.
+func isSameImage(tms *v1beta1.TenantMicroservice, first string, second string) bool {
+	if first == second {
+		return true
+	}
+	a, err := registry.ParseReference(first)
+	if err != nil {
+		return false
+	}
+	b, err := registry.ParseReference(second)
+	if err != nil || a.FullRepository() != b.FullRepository() {
+		return false
+	}
+	if a.Digest != "" && b.Digest != "" {
+		return a.Digest == b.Digest
+	}
+	if a.Digest == "" && b.Digest == "" {
+		return a.Tag == b.Tag
+	}
+	tagged, pinned := a, b
+	if a.Digest != "" {
+		tagged, pinned = b, a
+	}
+	requested, err := registry.ParseReference(tms.Status.Image)
+	return err == nil && requested.FullRepository() == tagged.FullRepository() && requested.Tag == tagged.Tag &&
+		pinned.Digest == tms.Status.ImageDigest
+}
+
+// Run the migration job for a tenant microservice when its image changes. Returns true once
+// the deployment may be updated to the new image. The deployment stays on its current image
+// after a failed migration until a retry is requested with the retry-jobs annotation.
+func (r *TenantMicroserviceReconciler) reconcileMigrationJob(ctx context.Context, tms *v1beta1.TenantMicroservice,
+	dct *v1beta1.Tenant, ms *v1beta1.Microservice, dci *v1beta1.Instance, from string, to string) (bool, error) {
+	log := logf.FromContext(ctx)
+
+	if ms.Spec.MigrationJob == nil || isSameImage(tms, from, to) {
+		return true, nil
+	}
+	status := tms.Status.Migration
+	if status != nil && isSameImage(tms, status.Image, to) && status.Phase == v1beta1.JobSucceeded {
+		return true, nil
+	}
+
+	// Migrations are not run backwards when reverting to the image last migrated from.
+	if status != nil && status.Phase == v1beta1.JobSucceeded && isSameImage(tms, status.Image, from) &&
+		isSameImage(tms, status.FromImage, to) {
+		log.Info(fmt.Sprintf("Reverting tenant microservice '%s' to '%s' without migration", tms.ObjectMeta.Name, to))
+		return true, nil
+	}
+
+	// Remove a job left from migrating to a different image (requeued once it is gone).
+	jname := getTenantJobName(tms, JOB_MIGRATE)
+	existing := &batchv1.Job{}
+	if err := r.Get(ctx, jname, existing); err != nil {
+		if !errors.IsNotFound(err) {
+			return false, err
+		}
+	} else if existing.ObjectMeta.Annotations[ANNOTATION_MIGRATION_IMAGE] != to {
+		if existing.ObjectMeta.DeletionTimestamp.IsZero() {
+			err := r.Delete(ctx, existing, client.PropagationPolicy(metav1.DeletePropagationBackground))
+			if err != nil && !errors.IsNotFound(err) {
+				return false, err
+			}
+		}
+		return false, nil
+	}
+
+	job, err := r.getOrCreateTenantJob(ctx, tms, generateMigrationJob(tms, dct, ms, dci, from, to))
+	if err != nil {
+		return false, err
+	}
+	if err := r.setMigrationStatus(ctx, tms, ms, job, from, to); err != nil {
+		return false, err
+	}
+	return tms.Status.Migration.Phase == v1beta1.JobSucceeded, nil
+}
@@ -0,0 +1,108 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+func TestReconcileMigrationJob(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := v1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := batchv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	ms := &v1beta1.Microservice{
+		ObjectMeta: metav1.ObjectMeta{Name: "storage", Namespace: "dc1"},
+		Spec: v1beta1.MicroserviceSpec{
+			Image:        "registry.io/storage:2.0",
+			MigrationJob: &v1beta1.TenantJobSpec{Command: []string{"migrate"}},
+		},
+	}
+	dci := &v1beta1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "dc1"}}
+	dct := &v1beta1.Tenant{ObjectMeta: metav1.ObjectMeta{Name: "acme", Namespace: "dc1"}}
+
+	tests := []struct {
+		name      string
+		status    v1beta1.TenantMicroserviceStatus
+		from      string
+		to        string
+		migrated  bool
+		jobExists bool
+	}{
+		{
+			name: "tag pinned to its digest",
+			status: v1beta1.TenantMicroserviceStatus{Image: "registry.io/storage:1.0",
+				ResolvedImage: "registry.io/storage@sha256:aaa", ImageDigest: "sha256:aaa"},
+			from:     "registry.io/storage:1.0",
+			to:       "registry.io/storage@sha256:aaa",
+			migrated: true,
+		},
+		{
+			name: "reverted to the image migrated from",
+			status: v1beta1.TenantMicroserviceStatus{Image: "registry.io/storage:1.0",
+				Migration: &v1beta1.MigrationStatus{FromImage: "registry.io/storage:1.0",
+					Image: "registry.io/storage:2.0", Phase: v1beta1.JobSucceeded}},
+			from:     "registry.io/storage:2.0",
+			to:       "registry.io/storage:1.0",
+			migrated: true,
+		},
+		{
+			name:      "upgraded to a new image",
+			status:    v1beta1.TenantMicroserviceStatus{Image: "registry.io/storage:2.0"},
+			from:      "registry.io/storage:1.0",
+			to:        "registry.io/storage:2.0",
+			migrated:  false,
+			jobExists: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tms := &v1beta1.TenantMicroservice{
+				ObjectMeta: metav1.ObjectMeta{Name: "acme-storage", Namespace: "dc1", UID: "uid"},
+				Spec:       v1beta1.TenantMicroserviceSpec{TenantId: "acme", MicroserviceId: "storage"},
+				Status:     test.status,
+			}
+			r := &TenantMicroserviceReconciler{
+				Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(tms).Build(),
+				Scheme: scheme,
+			}
+			migrated, err := r.reconcileMigrationJob(context.Background(), tms, dct, ms, dci, test.from, test.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if migrated != test.migrated {
+				t.Errorf("expected migrated %v, got %v", test.migrated, migrated)
+			}
+			err = r.Get(context.Background(), getTenantJobName(tms, JOB_MIGRATE), &batchv1.Job{})
+			if exists := !errors.IsNotFound(err); exists != test.jobExists {
+				t.Errorf("expected migration job to exist %v, got %v", test.jobExists, exists)
+			}
+		})
+	}
+}
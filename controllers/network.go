@@ -0,0 +1,162 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+const (
+	// Namespace assumed to contain the ingress controller if not configured.
+	DEFAULT_INGRESS_NAMESPACE = "ingress-nginx"
+
+	// Label automatically applied to namespaces with the namespace name.
+	LABEL_NAMESPACE_NAME = "kubernetes.io/metadata.name"
+)
+
+// Generate name used for tenant network policy.
+func generateNetworkPolicyName(ns string, tenantid string) types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: ns,
+		Name:      fmt.Sprintf("%s-%s-%s", "dct", tenantid, "network"),
+	}
+}
+
+// Indicates whether network isolation is enabled for an instance.
+func isNetworkIsolationEnabled(dci *v1beta1.Instance) bool {
+	return dci.Spec.NetworkIsolation == nil || !dci.Spec.NetworkIsolation.Disabled
+}
+
+// Generate network policy which only admits traffic from the same tenant, the ingress
+// controller and shared instance services.
+func generateTenantNetworkPolicy(dci *v1beta1.Instance, tenant *v1beta1.Tenant) *netv1.NetworkPolicy {
+	npname := generateNetworkPolicyName(tenant.ObjectMeta.Namespace, tenant.ObjectMeta.Name)
+	tenantselector := metav1.LabelSelector{
+		MatchLabels: map[string]string{v1beta1.LABEL_TENANT: tenant.ObjectMeta.Name},
+	}
+
+	ingressns := DEFAULT_INGRESS_NAMESPACE
+	var ingresspods *metav1.LabelSelector
+	var shared []v1beta1.SharedServiceSpec
+	if isolation := dci.Spec.NetworkIsolation; isolation != nil {
+		if isolation.IngressNamespace != "" {
+			ingressns = isolation.IngressNamespace
+		}
+		if len(isolation.IngressPodLabels) > 0 {
+			ingresspods = &metav1.LabelSelector{MatchLabels: isolation.IngressPodLabels}
+		}
+		shared = isolation.SharedServices
+	}
+
+	peers := []netv1.NetworkPolicyPeer{
+		{
+			PodSelector: tenantselector.DeepCopy(),
+		},
+		{
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{LABEL_NAMESPACE_NAME: ingressns},
+			},
+			PodSelector: ingresspods,
+		},
+	}
+	for _, service := range shared {
+		peers = append(peers, netv1.NetworkPolicyPeer{
+			PodSelector: service.PodSelector.DeepCopy(),
+		})
+	}
+
+	return &netv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      npname.Name,
+			Namespace: npname.Namespace,
+			Labels: map[string]string{
+				v1beta1.LABEL_TENANT: tenant.ObjectMeta.Name,
+			},
+		},
+		Spec: netv1.NetworkPolicySpec{
+			PodSelector: tenantselector,
+			PolicyTypes: []netv1.PolicyType{netv1.PolicyTypeIngress},
+			Ingress: []netv1.NetworkPolicyIngressRule{
+				{
+					From: peers,
+				},
+			},
+		},
+	}
+}
+
+// Create, update or remove the tenant network policy based on instance settings.
+func (r *TenantReconciler) reconcileTenantNetworkPolicy(ctx context.Context, tenant *v1beta1.Tenant) error {
+	log := logf.FromContext(ctx)
+
+	dci, err := v1beta1.GetInstance(v1beta1.InstanceGetRequest{Id: tenant.ObjectMeta.Namespace})
+	if err != nil {
+		return err
+	}
+
+	npname := generateNetworkPolicyName(tenant.ObjectMeta.Namespace, tenant.ObjectMeta.Name)
+	if !isNetworkIsolationEnabled(dci) {
+		return r.deleteTenantNetworkPolicy(ctx, npname)
+	}
+
+	updated := generateTenantNetworkPolicy(dci, tenant)
+	policy := &netv1.NetworkPolicy{}
+	if err := r.Get(ctx, npname, policy); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		if err := r.Create(ctx, updated); err != nil {
+			return err
+		}
+		log.Info(fmt.Sprintf("Created tenant network policy '%s'", npname.Name))
+		return nil
+	}
+
+	if equality.Semantic.DeepEqual(updated.Spec, policy.Spec) {
+		return nil
+	}
+	policy.Spec = updated.Spec
+	return r.Update(ctx, policy)
+}
+
+// Delete the tenant network policy if it exists.
+func (r *TenantReconciler) deleteTenantNetworkPolicy(ctx context.Context, npname types.NamespacedName) error {
+	log := logf.FromContext(ctx)
+
+	policy := &netv1.NetworkPolicy{}
+	if err := r.Get(ctx, npname, policy); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if err := r.Delete(ctx, policy); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	log.Info(fmt.Sprintf("Deleted tenant network policy '%s'", npname.Name))
+	return nil
+}
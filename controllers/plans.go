@@ -0,0 +1,271 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+const (
+	// Environment variable holding the id of the tenant plan.
+	ENV_TENANT_PLAN = "DC_TENANT_PLAN"
+
+	// Environment variable holding application quotas of the tenant plan (as JSON).
+	ENV_TENANT_QUOTAS = "DC_TENANT_QUOTAS"
+)
+
+// Get the plan referenced by a tenant (nil if the tenant has no plan).
+func getTenantPlan(ctx context.Context, c client.Client, tenant *v1beta1.Tenant) (*v1beta1.TenantPlan, error) {
+	if tenant.Spec.PlanId == "" {
+		return nil, nil
+	}
+	plan := &v1beta1.TenantPlan{}
+	if err := c.Get(ctx, client.ObjectKey{Name: tenant.Spec.PlanId}, plan); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// Get the plan applied to microservices of a tenant. If the referenced plan does not exist, the
+// plan last applied to the tenant (if any) remains in effect. The tenant controller records the
+// missing plan in tenant status.
+func getAppliedTenantPlan(ctx context.Context, c client.Client, tenant *v1beta1.Tenant) (*v1beta1.TenantPlan, error) {
+	log := logf.FromContext(ctx)
+
+	plan, err := getTenantPlan(ctx, c, tenant)
+	if err == nil || !errors.IsNotFound(err) {
+		return plan, err
+	}
+	log.Info(fmt.Sprintf("Tenant plan '%s' not found for tenant '%s'. Using last applied plan '%s'.",
+		tenant.Spec.PlanId, tenant.ObjectMeta.Name, tenant.Status.PlanId))
+	if tenant.Status.PlanId == "" || tenant.Status.PlanId == tenant.Spec.PlanId {
+		return nil, nil
+	}
+	plan = &v1beta1.TenantPlan{}
+	if err := c.Get(ctx, client.ObjectKey{Name: tenant.Status.PlanId}, plan); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return plan, nil
+}
+
+// Indicates whether microservices of a functional area are enabled by a plan.
+func isFunctionalAreaInPlan(plan *v1beta1.TenantPlan, area string) bool {
+	if plan == nil || len(plan.Spec.FunctionalAreas) == 0 {
+		return true
+	}
+	return containsFunctionalArea(plan.Spec.FunctionalAreas, area)
+}
+
+// Indicates whether a list of functional areas contains the given area.
+func containsFunctionalArea(areas []string, area string) bool {
+	for _, candidate := range areas {
+		if candidate == area {
+			return true
+		}
+	}
+	return false
+}
+
+// Indicates whether microservices of a functional area are enabled for a tenant. Areas must
+// be allowed by the plan, enabled by the tenant (if it lists areas) and not excluded.
+func isFunctionalAreaEnabled(tenant *v1beta1.Tenant, plan *v1beta1.TenantPlan, area string) bool {
+	if !isFunctionalAreaInPlan(plan, area) {
+		return false
+	}
+	if len(tenant.Spec.EnabledFunctionalAreas) > 0 && !containsFunctionalArea(tenant.Spec.EnabledFunctionalAreas, area) {
+		return false
+	}
+	return !containsFunctionalArea(tenant.Spec.ExcludedFunctionalAreas, area)
+}
+
+// Get compute resources a plan assigns to microservices of a functional area.
+func getPlanResources(plan *v1beta1.TenantPlan, area string) corev1.ResourceRequirements {
+	if plan == nil {
+		return corev1.ResourceRequirements{}
+	}
+	for _, profile := range plan.Spec.ResourceProfiles {
+		if profile.FunctionalArea == area {
+			return *profile.Resources.DeepCopy()
+		}
+	}
+	if plan.Spec.Resources != nil {
+		return *plan.Spec.Resources.DeepCopy()
+	}
+	return corev1.ResourceRequirements{}
+}
+
+// Limit a replica count to the bounds of a plan.
+func clampPlanReplicas(plan *v1beta1.TenantPlan, replicas int32) int32 {
+	if plan == nil || plan.Spec.Replicas == nil {
+		return replicas
+	}
+	bounds := plan.Spec.Replicas
+	if bounds.Max != nil && replicas > *bounds.Max {
+		replicas = *bounds.Max
+	}
+	if bounds.Min != nil && replicas < *bounds.Min {
+		replicas = *bounds.Min
+	}
+	return replicas
+}
+
+// Get the replica count of a tenant microservice within the bounds of its plan.
+func getPlanReplicas(plan *v1beta1.TenantPlan, replicas *int32) *int32 {
+	if plan == nil || plan.Spec.Replicas == nil {
+		return replicas
+	}
+	value := int32(1)
+	if replicas != nil {
+		value = *replicas
+	}
+	value = clampPlanReplicas(plan, value)
+	return &value
+}
+
+// Apply plan resources and quotas to a tenant microservice container.
+func applyTenantPlan(container *corev1.Container, plan *v1beta1.TenantPlan, ms *v1beta1.Microservice) error {
+	if plan == nil {
+		return nil
+	}
+	container.Resources = getPlanResources(plan, ms.Spec.FunctionalArea)
+	container.Env = append(container.Env, corev1.EnvVar{
+		Name:  ENV_TENANT_PLAN,
+		Value: plan.ObjectMeta.Name,
+	})
+	if len(plan.Spec.Quotas) > 0 {
+		quotas, err := json.Marshal(plan.Spec.Quotas)
+		if err != nil {
+			return err
+		}
+		container.Env = append(container.Env, corev1.EnvVar{
+			Name:  ENV_TENANT_QUOTAS,
+			Value: string(quotas),
+		})
+	}
+	return nil
+}
+
+// Look up the plan for a tenant and record the result in tenant status. Returns false if the
+// plan could not be loaded, in which case tenant microservices are left unchanged.
+func (r *TenantReconciler) reconcileTenantPlan(ctx context.Context,
+	tenant *v1beta1.Tenant) (*v1beta1.TenantPlan, bool, error) {
+	log := logf.FromContext(ctx)
+
+	plan, err := getTenantPlan(ctx, r.Client, tenant)
+	planerr := ""
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return nil, false, err
+		}
+		planerr = fmt.Sprintf("tenant plan '%s' not found", tenant.Spec.PlanId)
+		log.Info(fmt.Sprintf("Unable to apply plan to tenant '%s': %s", tenant.ObjectMeta.Name, planerr))
+	}
+
+	planid := tenant.Spec.PlanId
+	if planerr != "" {
+		planid = tenant.Status.PlanId
+	}
+	if tenant.Status.PlanId != planid || tenant.Status.PlanError != planerr {
+		if planerr == "" && tenant.Status.PlanId != planid {
+			log.Info(fmt.Sprintf("Applying plan '%s' to tenant '%s'", planid, tenant.ObjectMeta.Name))
+		}
+		tenant.Status.PlanId = planid
+		tenant.Status.PlanError = planerr
+		if err := r.Status().Update(ctx, tenant); err != nil {
+			return nil, false, err
+		}
+	}
+	return plan, planerr == "", nil
+}
+
+// Delete tenant microservices for functional areas which are not enabled for the tenant
+// by its plan or its own settings.
+func (r *TenantReconciler) removeDisabledTenantMicroservices(ctx context.Context, tenant *v1beta1.Tenant,
+	plan *v1beta1.TenantPlan, tmsbymsid map[string]v1beta1.TenantMicroservice) error {
+	log := logf.FromContext(ctx)
+
+	mslist, err := v1beta1.ListMicroservices(v1beta1.MicroserviceListRequest{
+		InstanceId: tenant.ObjectMeta.Namespace})
+	if err != nil {
+		return err
+	}
+	for _, ms := range mslist.Items {
+		tms, found := tmsbymsid[ms.ObjectMeta.Name]
+		if !found || isFunctionalAreaEnabled(tenant, plan, ms.Spec.FunctionalArea) {
+			continue
+		}
+		_, err := v1beta1.DeleteTenantMicroservice(v1beta1.TenantMicroserviceDeleteRequest{
+			InstanceId:           tenant.ObjectMeta.Namespace,
+			TenantMicroserviceId: tms.ObjectMeta.Name})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		delete(tmsbymsid, ms.ObjectMeta.Name)
+		log.Info(fmt.Sprintf("Deleted tenant microservice '%s' not enabled for tenant.", tms.ObjectMeta.Name))
+	}
+	return nil
+}
+
+// Find tenants affected by a change to a tenant plan.
+func (r *TenantReconciler) findTenantsForPlan(obj client.Object) []reconcile.Request {
+	tenants := &v1beta1.TenantList{}
+	if err := r.List(context.Background(), tenants); err != nil {
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0)
+	for _, tenant := range tenants.Items {
+		if tenant.Spec.PlanId != obj.GetName() {
+			continue
+		}
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: tenant.ObjectMeta.Namespace, Name: tenant.ObjectMeta.Name},
+		})
+	}
+	return requests
+}
+
+// Find tenant microservices affected by a change to a tenant plan.
+func (r *TenantMicroserviceReconciler) findTenantMicroservicesForPlan(obj client.Object) []reconcile.Request {
+	tenants := &v1beta1.TenantList{}
+	if err := r.List(context.Background(), tenants); err != nil {
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0)
+	for _, tenant := range tenants.Items {
+		if tenant.Spec.PlanId != obj.GetName() {
+			continue
+		}
+		requests = append(requests, r.findTenantMicroservicesForTenant(&tenant)...)
+	}
+	return requests
+}
@@ -0,0 +1,183 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+const (
+	// Purpose of the job run before a tenant microservice is first deployed.
+	JOB_PROVISION = "provision"
+
+	// Purpose of the job run after a tenant microservice is deleted.
+	JOB_DEPROVISION = "deprovision"
+
+	// Finalizer which holds a deleted tenant microservice until it is deprovisioned.
+	FINALIZER_DEPROVISION = "devicechain.io/deprovision"
+)
+
+// Run the provisioning job for a tenant microservice. Returns true once provisioning has
+// succeeded or if the microservice does not require it. A failed job is kept until a retry
+// is requested with the retry-jobs annotation.
+func (r *TenantMicroserviceReconciler) reconcileProvisioningJob(ctx context.Context, tms *v1beta1.TenantMicroservice,
+	dct *v1beta1.Tenant, ms *v1beta1.Microservice, dci *v1beta1.Instance) (bool, error) {
+	log := logf.FromContext(ctx)
+
+	if ms.Spec.ProvisionJob == nil || tms.Status.ProvisioningPhase == v1beta1.JobSucceeded {
+		return true, nil
+	}
+	job, err := r.getOrCreateTenantJob(ctx, tms,
+		generateTenantJob(tms, dct, ms, dci, ms.Spec.ProvisionJob, JOB_PROVISION))
+	if err != nil {
+		return false, err
+	}
+
+	phase, message := getJobPhase(job)
+	if tms.Status.ProvisioningPhase != phase || tms.Status.ProvisioningMessage != message {
+		log.Info(fmt.Sprintf("Provisioning of tenant microservice '%s': %s", tms.ObjectMeta.Name, phase))
+		tms.Status.ProvisioningPhase = phase
+		tms.Status.ProvisioningMessage = message
+		if err := r.Status().Update(ctx, tms); err != nil {
+			return false, err
+		}
+	}
+	return phase == v1beta1.JobSucceeded, nil
+}
+
+// Add or remove the finalizer which runs deprovisioning and drops tenant data when a tenant
+// microservice is deleted.
+func (r *TenantMicroserviceReconciler) reconcileDeprovisioningFinalizer(ctx context.Context,
+	tms *v1beta1.TenantMicroservice) error {
+	ms, err := v1beta1.GetMicroservice(v1beta1.MicroserviceGetRequest{
+		InstanceId:     tms.ObjectMeta.Namespace,
+		MicroserviceId: tms.Spec.MicroserviceId,
+	})
+	if err != nil {
+		return err
+	}
+
+	required := ms.Spec.DeprovisionJob != nil || isDatastoreDropRequired(ms)
+	if required == controllerutil.ContainsFinalizer(tms, FINALIZER_DEPROVISION) {
+		return nil
+	}
+	if required {
+		controllerutil.AddFinalizer(tms, FINALIZER_DEPROVISION)
+	} else {
+		controllerutil.RemoveFinalizer(tms, FINALIZER_DEPROVISION)
+	}
+	return r.Update(ctx, tms)
+}
+
+// Run the deprovisioning job for a deleted tenant microservice. Returns true once the job has
+// succeeded or if deprovisioning is no longer possible because the microservice or instance
+// was removed. A failed job keeps the tenant microservice until it is retried or skipped.
+func (r *TenantMicroserviceReconciler) runDeprovisioningJob(ctx context.Context,
+	tms *v1beta1.TenantMicroservice) (bool, error) {
+	log := logf.FromContext(ctx)
+
+	ms, err := v1beta1.GetMicroservice(v1beta1.MicroserviceGetRequest{
+		InstanceId:     tms.ObjectMeta.Namespace,
+		MicroserviceId: tms.Spec.MicroserviceId,
+	})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	if ms.Spec.DeprovisionJob == nil {
+		return true, nil
+	}
+	dci, err := v1beta1.GetInstance(v1beta1.InstanceGetRequest{Id: tms.ObjectMeta.Namespace})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	dct, err := v1beta1.GetTenant(v1beta1.TenantGetRequest{
+		InstanceId: tms.ObjectMeta.Namespace,
+		TenantId:   tms.Spec.TenantId,
+	})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return false, err
+		}
+		dct = nil
+	}
+
+	// Stop tenant workloads before their data is removed.
+	if err := r.deleteTenantMicroserviceWorkloads(ctx, getDeploymentName(tms)); err != nil {
+		return false, err
+	}
+
+	job, err := r.getOrCreateTenantJob(ctx, tms,
+		generateTenantJob(tms, dct, ms, dci, ms.Spec.DeprovisionJob, JOB_DEPROVISION))
+	if err != nil {
+		return false, err
+	}
+	phase, message := getJobPhase(job)
+	if tms.Status.DeprovisioningPhase != phase || tms.Status.DeprovisioningMessage != message {
+		log.Info(fmt.Sprintf("Deprovisioning of tenant microservice '%s': %s", tms.ObjectMeta.Name, phase))
+		tms.Status.DeprovisioningPhase = phase
+		tms.Status.DeprovisioningMessage = message
+		if err := r.Status().Update(ctx, tms); err != nil {
+			return false, err
+		}
+	}
+	if phase == v1beta1.JobFailed {
+		return isFailedCleanupSkipped(ctx, tms), nil
+	}
+	return phase == v1beta1.JobSucceeded, nil
+}
+
+// Check whether a deleted tenant microservice should be released even though cleanup failed,
+// leaving tenant resources in place.
+func isFailedCleanupSkipped(ctx context.Context, tms *v1beta1.TenantMicroservice) bool {
+	if _, found := tms.ObjectMeta.Annotations[v1beta1.ANNOTATION_SKIP_FAILED_CLEANUP]; !found {
+		return false
+	}
+	logf.FromContext(ctx).Info(fmt.Sprintf("Releasing tenant microservice '%s' without completing cleanup",
+		tms.ObjectMeta.Name))
+	return true
+}
+
+// Deprovision a tenant microservice which is being deleted and release it.
+func (r *TenantMicroserviceReconciler) finalizeTenantMicroservice(ctx context.Context,
+	tms *v1beta1.TenantMicroservice) error {
+	if !controllerutil.ContainsFinalizer(tms, FINALIZER_DEPROVISION) {
+		return nil
+	}
+	done, err := r.runDeprovisioningJob(ctx, tms)
+	if err != nil || !done {
+		return err
+	}
+	done, err = r.runDatastoreDropJob(ctx, tms)
+	if err != nil || !done {
+		return err
+	}
+	controllerutil.RemoveFinalizer(tms, FINALIZER_DEPROVISION)
+	return r.Update(ctx, tms)
+}
@@ -0,0 +1,202 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+const (
+	// Environment variable containing the namespace the operator runs in.
+	ENV_OPERATOR_NAMESPACE = "POD_NAMESPACE"
+
+	// Operator namespace assumed if not available from the environment.
+	DEFAULT_OPERATOR_NAMESPACE = "dc-system"
+
+	// Label applied to pull secrets copied into instance namespaces by the operator.
+	LABEL_MANAGED_PULL_SECRET = "devicechain.io.managed-pull-secret"
+
+	// Annotation recording the operator namespace secret a pull secret was copied from.
+	ANNOTATION_SOURCE_SECRET = "devicechain.io/source-secret"
+
+	// Interval at which copied pull secrets are resynchronized with their source.
+	PULL_SECRET_SYNC_INTERVAL = 5 * time.Minute
+)
+
+// Get namespace the operator is running in.
+func getOperatorNamespace() string {
+	if ns := os.Getenv(ENV_OPERATOR_NAMESPACE); ns != "" {
+		return ns
+	}
+	return DEFAULT_OPERATOR_NAMESPACE
+}
+
+// Get image pull secrets for a microservice pod (instance secrets followed by microservice secrets).
+func getImagePullSecrets(dci *v1beta1.Instance, ms *v1beta1.Microservice) []corev1.LocalObjectReference {
+	secrets := make([]corev1.LocalObjectReference, 0)
+	found := make(map[string]bool)
+	for _, secret := range dci.Spec.ImagePullSecrets {
+		if !found[secret.Name] {
+			secrets = append(secrets, corev1.LocalObjectReference{Name: secret.Name})
+			found[secret.Name] = true
+		}
+	}
+	for _, secret := range ms.Spec.ImagePullSecrets {
+		if !found[secret.Name] {
+			secrets = append(secrets, secret)
+			found[secret.Name] = true
+		}
+	}
+	if len(secrets) == 0 {
+		return nil
+	}
+	return secrets
+}
+
+// Get image pull secrets of the instance for pods created by the operator.
+func getInstanceImagePullSecrets(dci *v1beta1.Instance) []corev1.LocalObjectReference {
+	return getImagePullSecrets(dci, &v1beta1.Microservice{})
+}
+
+// Copy a pull secret from the operator namespace into the instance namespace.
+func (r *InstanceReconciler) syncImagePullSecret(ctx context.Context, dci *v1beta1.Instance,
+	spec v1beta1.ImagePullSecretSpec) error {
+	log := logf.FromContext(ctx)
+
+	source := &corev1.Secret{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: getOperatorNamespace(), Name: spec.SourceSecret}, source)
+	if err != nil {
+		return err
+	}
+
+	secret := &corev1.Secret{}
+	err = r.Get(ctx, client.ObjectKey{Namespace: dci.ObjectMeta.Name, Name: spec.Name}, secret)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      spec.Name,
+				Namespace: dci.ObjectMeta.Name,
+				Labels: map[string]string{
+					LABEL_MANAGED_PULL_SECRET: "true",
+				},
+				Annotations: map[string]string{
+					ANNOTATION_SOURCE_SECRET: spec.SourceSecret,
+				},
+			},
+			Type: source.Type,
+			Data: source.Data,
+		}
+		if err := r.Create(ctx, secret); err != nil {
+			return err
+		}
+		log.Info(fmt.Sprintf("Copied image pull secret '%s' to instance '%s'", spec.SourceSecret, dci.ObjectMeta.Name))
+		return nil
+	}
+
+	if secret.ObjectMeta.Labels[LABEL_MANAGED_PULL_SECRET] != "true" {
+		return fmt.Errorf("image pull secret '%s' exists and is not managed by the operator", spec.Name)
+	}
+	if secret.Type != source.Type {
+		// Secret type is immutable, so replace the secret.
+		if err := r.Delete(ctx, secret); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		return r.syncImagePullSecret(ctx, dci, spec)
+	}
+	if reflect.DeepEqual(secret.Data, source.Data) &&
+		secret.ObjectMeta.Annotations[ANNOTATION_SOURCE_SECRET] == spec.SourceSecret {
+		return nil
+	}
+	if secret.ObjectMeta.Annotations == nil {
+		secret.ObjectMeta.Annotations = make(map[string]string)
+	}
+	secret.ObjectMeta.Annotations[ANNOTATION_SOURCE_SECRET] = spec.SourceSecret
+	secret.Data = source.Data
+	if err := r.Update(ctx, secret); err != nil {
+		return err
+	}
+	log.Info(fmt.Sprintf("Updated image pull secret '%s' in instance '%s'", spec.Name, dci.ObjectMeta.Name))
+	return nil
+}
+
+// Copy pull secrets with a source into the instance namespace and remove copies which
+// are no longer declared. Indicates whether periodic resynchronization is required.
+func (r *InstanceReconciler) reconcileImagePullSecrets(ctx context.Context, dci *v1beta1.Instance) (bool, error) {
+	log := logf.FromContext(ctx)
+
+	sync := false
+	declared := make(map[string]bool)
+	for _, spec := range dci.Spec.ImagePullSecrets {
+		if spec.SourceSecret == "" {
+			continue
+		}
+		sync = true
+		declared[spec.Name] = true
+		if err := r.syncImagePullSecret(ctx, dci, spec); err != nil {
+			return sync, err
+		}
+	}
+
+	copies := &corev1.SecretList{}
+	err := r.List(ctx, copies, client.InNamespace(dci.ObjectMeta.Name),
+		client.MatchingLabels{LABEL_MANAGED_PULL_SECRET: "true"})
+	if err != nil {
+		return sync, err
+	}
+	for i := range copies.Items {
+		secret := &copies.Items[i]
+		if declared[secret.ObjectMeta.Name] {
+			continue
+		}
+		if err := r.Delete(ctx, secret); err != nil && !errors.IsNotFound(err) {
+			return sync, err
+		}
+		log.Info(fmt.Sprintf("Deleted image pull secret '%s' from instance '%s'", secret.ObjectMeta.Name,
+			dci.ObjectMeta.Name))
+	}
+	return sync, nil
+}
+
+// Delete pull secrets copied into an instance namespace.
+func (r *InstanceReconciler) deleteImagePullSecrets(ctx context.Context, ns string) error {
+	copies := &corev1.SecretList{}
+	err := r.List(ctx, copies, client.InNamespace(ns), client.MatchingLabels{LABEL_MANAGED_PULL_SECRET: "true"})
+	if err != nil {
+		return err
+	}
+	for i := range copies.Items {
+		if err := r.Delete(ctx, &copies.Items[i]); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+// Get name of the resource quota for an instance namespace.
+func getInstanceResourceQuotaName(ns string) string {
+	return fmt.Sprintf("%s-%s-%s", "dci", ns, "quota")
+}
+
+// Get name of the limit range for an instance namespace.
+func getInstanceLimitRangeName(ns string) string {
+	return fmt.Sprintf("%s-%s-%s", "dci", ns, "limits")
+}
+
+// Create, update or remove the resource quota for an instance namespace.
+func (r *InstanceReconciler) reconcileInstanceResourceQuota(ctx context.Context, dci *v1beta1.Instance) error {
+	log := logf.FromContext(ctx)
+	ns := dci.ObjectMeta.Name
+	key := client.ObjectKey{Namespace: ns, Name: getInstanceResourceQuotaName(ns)}
+
+	quota := &corev1.ResourceQuota{}
+	if err := r.Get(ctx, key, quota); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		if dci.Spec.ResourceQuota == nil {
+			return nil
+		}
+		quota = &corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      key.Name,
+				Namespace: ns,
+			},
+			Spec: *dci.Spec.ResourceQuota.DeepCopy(),
+		}
+		if err := r.Create(ctx, quota); err != nil {
+			return err
+		}
+		log.Info(fmt.Sprintf("Created resource quota for instance '%s'", ns))
+		return nil
+	}
+
+	if dci.Spec.ResourceQuota == nil {
+		return r.Delete(ctx, quota)
+	}
+	if equality.Semantic.DeepEqual(quota.Spec, *dci.Spec.ResourceQuota) {
+		return nil
+	}
+	quota.Spec = *dci.Spec.ResourceQuota.DeepCopy()
+	return r.Update(ctx, quota)
+}
+
+// Create, update or remove the limit range for an instance namespace.
+func (r *InstanceReconciler) reconcileInstanceLimitRange(ctx context.Context, dci *v1beta1.Instance) error {
+	log := logf.FromContext(ctx)
+	ns := dci.ObjectMeta.Name
+	key := client.ObjectKey{Namespace: ns, Name: getInstanceLimitRangeName(ns)}
+
+	limits := &corev1.LimitRange{}
+	if err := r.Get(ctx, key, limits); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		if dci.Spec.LimitRange == nil {
+			return nil
+		}
+		limits = &corev1.LimitRange{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      key.Name,
+				Namespace: ns,
+			},
+			Spec: *dci.Spec.LimitRange.DeepCopy(),
+		}
+		if err := r.Create(ctx, limits); err != nil {
+			return err
+		}
+		log.Info(fmt.Sprintf("Created limit range for instance '%s'", ns))
+		return nil
+	}
+
+	if dci.Spec.LimitRange == nil {
+		return r.Delete(ctx, limits)
+	}
+	if equality.Semantic.DeepEqual(limits.Spec, *dci.Spec.LimitRange) {
+		return nil
+	}
+	limits.Spec = *dci.Spec.LimitRange.DeepCopy()
+	return r.Update(ctx, limits)
+}
@@ -0,0 +1,210 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+// Get name of tenant secret
+func getTenantSecretName(tid string) string {
+	return fmt.Sprintf("%s-%s-%s", "dct", tid, "secrets")
+}
+
+// Get name of tenant role in namespace
+func generateTenantRoleName(ns string, tid string) types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: ns,
+		Name:      fmt.Sprintf("%s-%s-%s", "dct", tid, "role"),
+	}
+}
+
+// Get names of secrets a tenant's workloads may read.
+func getTenantSecretNames(tid string) []string {
+	return []string{getTenantSecretName(tid), getTenantCredentialsSecretName(tid)}
+}
+
+// Generate role granting read access to tenant configuration and secrets only.
+func generateTenantRole(tenant *v1beta1.Tenant) *rbacv1.Role {
+	rname := generateTenantRoleName(tenant.ObjectMeta.Namespace, tenant.ObjectMeta.Name)
+	return &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      rname.Name,
+			Namespace: rname.Namespace,
+			Labels: map[string]string{
+				v1beta1.LABEL_TENANT: tenant.ObjectMeta.Name,
+			},
+		},
+		Rules: []rbacv1.PolicyRule{
+			{
+				APIGroups: []string{""},
+				Resources: []string{"configmaps"},
+				ResourceNames: []string{
+					getInstanceConfigMapName(tenant.ObjectMeta.Namespace),
+					getTenantConfigMapName(tenant.ObjectMeta.Name),
+				},
+				Verbs: []string{"get", "watch"},
+			},
+			{
+				APIGroups:     []string{""},
+				Resources:     []string{"secrets"},
+				ResourceNames: getTenantSecretNames(tenant.ObjectMeta.Name),
+				Verbs:         []string{"get", "watch"},
+			},
+		},
+	}
+}
+
+// Create or update the role used by tenant workloads.
+func (r *TenantReconciler) reconcileTenantRole(ctx context.Context, tenant *v1beta1.Tenant) error {
+	log := logf.FromContext(ctx)
+
+	updated := generateTenantRole(tenant)
+	role := &rbacv1.Role{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(updated), role); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		if err := r.Create(ctx, updated); err != nil {
+			return err
+		}
+		log.Info(fmt.Sprintf("Created tenant role '%s'", updated.ObjectMeta.Name))
+		return nil
+	}
+
+	if equality.Semantic.DeepEqual(updated.Rules, role.Rules) {
+		return nil
+	}
+	role.Rules = updated.Rules
+	return r.Update(ctx, role)
+}
+
+// Get name of service account used by tenant microservice pods.
+func getServiceAccountName(tms *v1beta1.TenantMicroservice) types.NamespacedName {
+	return getDeploymentName(tms)
+}
+
+// Create service account and role binding for a tenant microservice if not present.
+func (r *TenantMicroserviceReconciler) reconcileServiceAccount(ctx context.Context, tms *v1beta1.TenantMicroservice) error {
+	log := logf.FromContext(ctx)
+
+	saname := getServiceAccountName(tms)
+	labels := createDeploymentLabels(tms)
+
+	sa := &corev1.ServiceAccount{}
+	if err := r.Get(ctx, saname, sa); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		sa = &corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      saname.Name,
+				Namespace: saname.Namespace,
+				Labels:    labels,
+			},
+		}
+		if err := r.Create(ctx, sa); err != nil {
+			return err
+		}
+		log.Info(fmt.Sprintf("Created service account for tenant microservice: %+v", saname))
+	}
+
+	rname := generateTenantRoleName(tms.ObjectMeta.Namespace, tms.Spec.TenantId)
+	binding := &rbacv1.RoleBinding{}
+	if err := r.Get(ctx, saname, binding); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		binding = &rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      saname.Name,
+				Namespace: saname.Namespace,
+				Labels:    labels,
+			},
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: rbacv1.GroupName,
+				Kind:     "Role",
+				Name:     rname.Name,
+			},
+			Subjects: []rbacv1.Subject{
+				{
+					Kind:      rbacv1.ServiceAccountKind,
+					Name:      saname.Name,
+					Namespace: saname.Namespace,
+				},
+			},
+		}
+		if err := r.Create(ctx, binding); err != nil {
+			return err
+		}
+		log.Info(fmt.Sprintf("Created role binding for tenant microservice: %+v", saname))
+	}
+	return nil
+}
+
+// Delete service account and role binding for a tenant microservice.
+func (r *TenantMicroserviceReconciler) deleteServiceAccount(ctx context.Context, saname types.NamespacedName) error {
+	log := logf.FromContext(ctx)
+
+	binding := &rbacv1.RoleBinding{}
+	if err := r.Get(ctx, saname, binding); err == nil {
+		if err := r.Delete(ctx, binding); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		log.Info(fmt.Sprintf("Deleted role binding for tenant microservice: %+v", saname))
+	} else if !errors.IsNotFound(err) {
+		return err
+	}
+
+	sa := &corev1.ServiceAccount{}
+	if err := r.Get(ctx, saname, sa); err == nil {
+		if err := r.Delete(ctx, sa); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		log.Info(fmt.Sprintf("Deleted service account for tenant microservice: %+v", saname))
+	} else if !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// Delete the role used by tenant workloads.
+func (r *TenantReconciler) deleteTenantRole(ctx context.Context, rname types.NamespacedName) error {
+	role := &rbacv1.Role{}
+	if err := r.Get(ctx, rname, role); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if err := r.Delete(ctx, role); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// Pod template annotation used to trigger a rolling restart (same as kubectl rollout restart).
+	ANNOTATION_RESTARTED_AT = "kubectl.kubernetes.io/restartedAt"
+)
+
+// Trigger a rolling restart of deployments matching the given labels.
+func restartDeployments(ctx context.Context, c client.Client, ns string, labels map[string]string) error {
+	deploys := &appsv1.DeploymentList{}
+	if err := c.List(ctx, deploys, client.InNamespace(ns), client.MatchingLabels(labels)); err != nil {
+		return err
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	for i := range deploys.Items {
+		deploy := &deploys.Items[i]
+		if deploy.Spec.Template.ObjectMeta.Annotations == nil {
+			deploy.Spec.Template.ObjectMeta.Annotations = make(map[string]string)
+		}
+		deploy.Spec.Template.ObjectMeta.Annotations[ANNOTATION_RESTARTED_AT] = now
+		if err := c.Update(ctx, deploy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Carry over restart annotation from an existing pod template so regenerating the
+// template does not trigger another rollout.
+func preserveRestartAnnotation(existing *appsv1.Deployment, updated *appsv1.Deployment) {
+	restarted, found := existing.Spec.Template.ObjectMeta.Annotations[ANNOTATION_RESTARTED_AT]
+	if !found {
+		return
+	}
+	if updated.Spec.Template.ObjectMeta.Annotations == nil {
+		updated.Spec.Template.ObjectMeta.Annotations = make(map[string]string)
+	}
+	updated.Spec.Template.ObjectMeta.Annotations[ANNOTATION_RESTARTED_AT] = restarted
+}
@@ -0,0 +1,216 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strconv"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+const (
+	// Label identifying the tenant microservice a resource belongs to.
+	LABEL_TENANT_MICROSERVICE = "devicechain.io.tenant-microservice"
+
+	// Number of configuration revisions retained if not configured.
+	DEFAULT_REVISION_HISTORY_LIMIT = 10
+)
+
+// Get configuration content stored in a revision.
+func getRevisionData(tms *v1beta1.TenantMicroservice) []byte {
+	if len(tms.Spec.Configuration.RawMessage) == 0 {
+		return []byte("{}")
+	}
+	return tms.Spec.Configuration.RawMessage
+}
+
+// Get name of the revision holding the given configuration content.
+func getRevisionName(tms *v1beta1.TenantMicroservice, data []byte) string {
+	hash := fmt.Sprintf("%x", sha256.Sum256(data))
+	return fmt.Sprintf("%s-%s", tms.ObjectMeta.Name, hash[:10])
+}
+
+// Get number of configuration revisions retained for a tenant microservice.
+func getRevisionHistoryLimit(tms *v1beta1.TenantMicroservice) int {
+	if tms.Spec.RevisionHistoryLimit != nil {
+		return int(*tms.Spec.RevisionHistoryLimit)
+	}
+	return DEFAULT_REVISION_HISTORY_LIMIT
+}
+
+// List configuration revisions for a tenant microservice ordered from oldest to newest.
+func (r *TenantMicroserviceReconciler) listConfigurationRevisions(ctx context.Context,
+	tms *v1beta1.TenantMicroservice) ([]appsv1.ControllerRevision, error) {
+	revisions := &appsv1.ControllerRevisionList{}
+	err := r.List(ctx, revisions, client.InNamespace(tms.ObjectMeta.Namespace),
+		client.MatchingLabels{LABEL_TENANT_MICROSERVICE: tms.ObjectMeta.Name})
+	if err != nil {
+		return nil, err
+	}
+	items := revisions.Items
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Revision < items[j].Revision
+	})
+	return items, nil
+}
+
+// Record the current configuration as the latest revision and prune old revisions.
+func (r *TenantMicroserviceReconciler) reconcileConfigurationRevision(ctx context.Context,
+	tms *v1beta1.TenantMicroservice) error {
+	log := logf.FromContext(ctx)
+
+	revisions, err := r.listConfigurationRevisions(ctx, tms)
+	if err != nil {
+		return err
+	}
+	latest := int64(0)
+	if len(revisions) > 0 {
+		latest = revisions[len(revisions)-1].Revision
+	}
+
+	// Reuse an existing revision with the same content (as for a rollback) or create a new one.
+	data := getRevisionData(tms)
+	name := getRevisionName(tms, data)
+	var current *appsv1.ControllerRevision
+	for i := range revisions {
+		if revisions[i].ObjectMeta.Name == name {
+			current = &revisions[i]
+		}
+	}
+	if current == nil {
+		current = &appsv1.ControllerRevision{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: tms.ObjectMeta.Namespace,
+				Labels: map[string]string{
+					v1beta1.LABEL_TENANT:       tms.Spec.TenantId,
+					v1beta1.LABEL_MICROSERVICE: tms.Spec.MicroserviceId,
+					LABEL_TENANT_MICROSERVICE:  tms.ObjectMeta.Name,
+				},
+			},
+			Data:     runtime.RawExtension{Raw: data},
+			Revision: latest + 1,
+		}
+		if err := controllerutil.SetControllerReference(tms, current, r.Scheme); err != nil {
+			return err
+		}
+		if err := r.Create(ctx, current); err != nil {
+			return err
+		}
+		revisions = append(revisions, *current)
+		log.Info(fmt.Sprintf("Recorded configuration revision %d for tenant microservice '%s'", current.Revision,
+			tms.ObjectMeta.Name))
+	} else if current.Revision != latest {
+		current.Revision = latest + 1
+		if err := r.Update(ctx, current); err != nil {
+			return err
+		}
+	}
+	revision := current.Revision
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Revision < revisions[j].Revision
+	})
+
+	// Prune oldest revisions beyond the history limit.
+	for i := 0; i < len(revisions)-getRevisionHistoryLimit(tms); i++ {
+		if revisions[i].ObjectMeta.Name == name {
+			continue
+		}
+		if err := r.Delete(ctx, &revisions[i]); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	if tms.Status.CurrentRevision != revision {
+		tms.Status.CurrentRevision = revision
+		return r.Status().Update(ctx, tms)
+	}
+	return nil
+}
+
+// Find the revision requested by a rollback annotation value.
+func findRollbackRevision(revisions []appsv1.ControllerRevision, current int64,
+	value string) (*appsv1.ControllerRevision, error) {
+	if value == v1beta1.ROLLBACK_PREVIOUS_REVISION {
+		for i := len(revisions) - 1; i >= 0; i-- {
+			if revisions[i].Revision < current {
+				return &revisions[i], nil
+			}
+		}
+		return nil, fmt.Errorf("no revision prior to revision %d", current)
+	}
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid revision '%s'", value)
+	}
+	for i := range revisions {
+		if revisions[i].Revision == number {
+			return &revisions[i], nil
+		}
+	}
+	return nil, fmt.Errorf("revision %d not found", number)
+}
+
+// Restore a prior configuration revision if requested by annotation. Returns true if the
+// configuration was rolled back.
+func (r *TenantMicroserviceReconciler) handleRollbackRequest(ctx context.Context,
+	tms *v1beta1.TenantMicroservice) (bool, error) {
+	log := logf.FromContext(ctx)
+
+	value, found := tms.ObjectMeta.Annotations[v1beta1.ANNOTATION_ROLLBACK_TO_REVISION]
+	if !found {
+		return false, nil
+	}
+	revisions, err := r.listConfigurationRevisions(ctx, tms)
+	if err != nil {
+		return false, err
+	}
+
+	message := ""
+	rolledback := false
+	revision, err := findRollbackRevision(revisions, tms.Status.CurrentRevision, value)
+	if err != nil {
+		message = fmt.Sprintf("Rollback failed: %v", err)
+	} else {
+		tms.Spec.Configuration.RawMessage = revision.Data.Raw
+		message = fmt.Sprintf("Rolled back to revision %d", revision.Revision)
+		rolledback = true
+	}
+	log.Info(fmt.Sprintf("Tenant microservice '%s': %s", tms.ObjectMeta.Name, message))
+
+	delete(tms.ObjectMeta.Annotations, v1beta1.ANNOTATION_ROLLBACK_TO_REVISION)
+	if err := r.Update(ctx, tms); err != nil {
+		return false, err
+	}
+	tms.Status.RollbackMessage = message
+	if err := r.Status().Update(ctx, tms); err != nil {
+		return false, err
+	}
+	return rolledback, nil
+}
@@ -0,0 +1,392 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+const (
+	// Time a rollout wave may take to become healthy if not configured.
+	DEFAULT_PROGRESS_DEADLINE = 10 * time.Minute
+
+	// Interval at which the health of a progressing rollout wave is checked.
+	ROLLOUT_CHECK_INTERVAL = 15 * time.Second
+
+	// Deployment condition reason indicating a rollout is stuck.
+	REASON_PROGRESS_DEADLINE_EXCEEDED = "ProgressDeadlineExceeded"
+)
+
+// List tenant microservices for a microservice ordered by tenant id.
+func (r *MicroserviceReconciler) listTenantMicroservices(ctx context.Context,
+	ms *v1beta1.Microservice) ([]v1beta1.TenantMicroservice, error) {
+	tmslist := &v1beta1.TenantMicroserviceList{}
+	err := r.List(ctx, tmslist, client.InNamespace(ms.ObjectMeta.Namespace),
+		client.MatchingLabels{v1beta1.LABEL_MICROSERVICE: ms.ObjectMeta.Name})
+	if err != nil {
+		return nil, err
+	}
+	items := tmslist.Items
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Spec.TenantId < items[j].Spec.TenantId
+	})
+	return items, nil
+}
+
+// Order tenant microservices for a rollout with canary tenants first. Suspended tenants run no
+// pods to verify the image with, so they are left out of waves and follow the microservice
+// image once the rollout completes. Returns the number of canaries.
+func (r *MicroserviceReconciler) orderRolloutTenants(ctx context.Context, ms *v1beta1.Microservice,
+	items []v1beta1.TenantMicroservice) ([]v1beta1.TenantMicroservice, int, error) {
+	selector := labels.Nothing()
+	if ms.Spec.Rollout.CanarySelector != nil {
+		var err error
+		selector, err = metav1.LabelSelectorAsSelector(ms.Spec.Rollout.CanarySelector)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	canaries := make([]v1beta1.TenantMicroservice, 0)
+	others := make([]v1beta1.TenantMicroservice, 0)
+	for _, tms := range items {
+		tenant := &v1beta1.Tenant{}
+		err := r.Get(ctx, client.ObjectKey{Namespace: tms.ObjectMeta.Namespace, Name: tms.Spec.TenantId}, tenant)
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, 0, err
+		}
+		if err == nil && isTenantSuspended(tenant) {
+			continue
+		}
+		if err == nil && selector.Matches(labels.Set(tenant.ObjectMeta.Labels)) {
+			canaries = append(canaries, tms)
+		} else {
+			others = append(others, tms)
+		}
+	}
+	return append(canaries, others...), len(canaries), nil
+}
+
+// Get the cumulative number of tenants upgraded after each wave.
+func getWaveSizes(ms *v1beta1.Microservice, total int, canaries int) []int {
+	sizes := make([]int, 0)
+	if total == 0 {
+		return sizes
+	}
+	if canaries > 0 {
+		sizes = append(sizes, canaries)
+	}
+	for _, percent := range ms.Spec.Rollout.Waves {
+		size := (total*int(percent) + 99) / 100
+		if size > total {
+			size = total
+		}
+		if size > 0 && (len(sizes) == 0 || size > sizes[len(sizes)-1]) {
+			sizes = append(sizes, size)
+		}
+	}
+	if len(sizes) == 0 || sizes[len(sizes)-1] < total {
+		sizes = append(sizes, total)
+	}
+	return sizes
+}
+
+// Get the time a rollout wave may take to become healthy.
+func getProgressDeadline(ms *v1beta1.Microservice) time.Duration {
+	if ms.Spec.Rollout.ProgressDeadline != nil {
+		return ms.Spec.Rollout.ProgressDeadline.Duration
+	}
+	return DEFAULT_PROGRESS_DEADLINE
+}
+
+// Set the image override for a tenant microservice.
+func (r *MicroserviceReconciler) setTenantImage(ctx context.Context, tms *v1beta1.TenantMicroservice, image string) error {
+	if tms.Spec.Image == image {
+		return nil
+	}
+	tms.Spec.Image = image
+	return r.Update(ctx, tms)
+}
+
+// Check whether a tenant microservice is running the given image. Returns a reason if
+// the rollout has failed.
+func (r *MicroserviceReconciler) checkTenantRollout(ctx context.Context, tms *v1beta1.TenantMicroservice,
+	image string) (bool, string, error) {
+	if tms.Status.Image == image && tms.Status.ImageError != "" {
+		return false, tms.Status.ImageError, nil
+	}
+	if tms.Status.Image != image {
+		return false, "", nil
+	}
+
+	// The deployment keeps its previous image until tenant data has been migrated.
+	target := image
+	if tms.Status.ResolvedImage != "" {
+		target = tms.Status.ResolvedImage
+	}
+	if migration := tms.Status.Migration; migration != nil && migration.Image == target &&
+		migration.Phase == v1beta1.JobFailed {
+		return false, fmt.Sprintf("migration failed: %s", migration.Message), nil
+	}
+
+	deploy := &appsv1.Deployment{}
+	if err := r.Get(ctx, getDeploymentName(tms), deploy); err != nil {
+		if errors.IsNotFound(err) {
+			return false, "", nil
+		}
+		return false, "", err
+	}
+	if len(deploy.Spec.Template.Spec.Containers) == 0 || deploy.Spec.Template.Spec.Containers[0].Image != target {
+		return false, "", nil
+	}
+	for _, condition := range deploy.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && condition.Reason == REASON_PROGRESS_DEADLINE_EXCEEDED {
+			return false, condition.Message, nil
+		}
+	}
+	replicas := int32(1)
+	if deploy.Spec.Replicas != nil {
+		replicas = *deploy.Spec.Replicas
+	}
+
+	// A deployment without replicas has not run the image yet.
+	if replicas == 0 {
+		return false, "", nil
+	}
+	healthy := deploy.Status.ObservedGeneration >= deploy.ObjectMeta.Generation &&
+		deploy.Status.UpdatedReplicas == replicas &&
+		deploy.Status.Replicas == replicas &&
+		deploy.Status.AvailableReplicas == replicas
+	return healthy, "", nil
+}
+
+// Apply image overrides so updated tenants run the target image. All others follow the
+// current microservice image.
+func (r *MicroserviceReconciler) applyRolloutImages(ctx context.Context, items []v1beta1.TenantMicroservice,
+	rollout *v1beta1.RolloutStatus) error {
+	upgraded := make(map[string]bool)
+	for _, tid := range append(append([]string{}, rollout.UpdatedTenants...), rollout.WaveTenants...) {
+		upgraded[tid] = true
+	}
+	for i := range items {
+		image := ""
+		if upgraded[items[i].Spec.TenantId] {
+			image = rollout.TargetImage
+		}
+		if err := r.setTenantImage(ctx, &items[i], image); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Select tenants for the next wave of a rollout.
+func selectWaveTenants(ordered []v1beta1.TenantMicroservice, rollout *v1beta1.RolloutStatus, size int) []string {
+	updated := make(map[string]bool)
+	for _, tid := range rollout.UpdatedTenants {
+		updated[tid] = true
+	}
+	wave := make([]string, 0)
+	count := len(rollout.UpdatedTenants)
+	for _, tms := range ordered {
+		if count >= size {
+			break
+		}
+		if !updated[tms.Spec.TenantId] {
+			wave = append(wave, tms.Spec.TenantId)
+			count++
+		}
+	}
+	return wave
+}
+
+// Roll out microservice image changes to tenants in waves. Returns the delay before the
+// rollout should be checked again (zero if not progressing).
+func (r *MicroserviceReconciler) reconcileRollout(ctx context.Context, ms *v1beta1.Microservice) (time.Duration, error) {
+	log := logf.FromContext(ctx)
+
+	items, err := r.listTenantMicroservices(ctx, ms)
+	if err != nil {
+		return 0, err
+	}
+
+	// Without a rollout strategy (or once rolled out) all tenants follow the microservice image.
+	if ms.Spec.Rollout == nil || ms.Status.CurrentImage == "" || ms.Spec.Image == ms.Status.CurrentImage {
+		for i := range items {
+			if err := r.setTenantImage(ctx, &items[i], ""); err != nil {
+				return 0, err
+			}
+		}
+		ms.Status.CurrentImage = ms.Spec.Image
+		if ms.Status.Rollout != nil && ms.Status.Rollout.TargetImage != ms.Spec.Image &&
+			ms.Status.Rollout.Phase != v1beta1.RolloutFailed {
+			ms.Status.Rollout.Phase = v1beta1.RolloutCancelled
+			ms.Status.Rollout.WaveTenants = nil
+			ms.Status.Rollout.Message = fmt.Sprintf("Rollout cancelled. All tenants running '%s'.", ms.Spec.Image)
+		}
+		return 0, nil
+	}
+
+	ordered, canaries, err := r.orderRolloutTenants(ctx, ms, items)
+	if err != nil {
+		return 0, err
+	}
+	sizes := getWaveSizes(ms, len(ordered), canaries)
+	now := metav1.Now()
+
+	// Start a new rollout when the target image changes.
+	rollout := ms.Status.Rollout
+	if rollout == nil || rollout.TargetImage != ms.Spec.Image {
+		rollout = &v1beta1.RolloutStatus{
+			TargetImage:   ms.Spec.Image,
+			PreviousImage: ms.Status.CurrentImage,
+			Phase:         v1beta1.RolloutProgressing,
+			WaveStartedAt: &now,
+		}
+		if len(sizes) > 0 {
+			rollout.WaveTenants = selectWaveTenants(ordered, rollout, sizes[0])
+		}
+		ms.Status.Rollout = rollout
+		log.Info(fmt.Sprintf("Starting rollout of '%s' to %d tenants in %d waves", rollout.TargetImage,
+			len(ordered), len(sizes)))
+	}
+	rollout.TotalWaves = int32(len(sizes))
+
+	// Failed rollouts stay paused until the image is changed.
+	if rollout.Phase == v1beta1.RolloutFailed {
+		return 0, r.applyRolloutImages(ctx, items, rollout)
+	}
+
+	// Resume a paused rollout with the next wave.
+	if rollout.Phase == v1beta1.RolloutPaused {
+		if ms.Spec.Rollout.Paused {
+			return 0, r.applyRolloutImages(ctx, items, rollout)
+		}
+		rollout.Phase = v1beta1.RolloutProgressing
+		rollout.WaveStartedAt = &now
+		if int(rollout.Wave) < len(sizes) {
+			rollout.WaveTenants = selectWaveTenants(ordered, rollout, sizes[rollout.Wave])
+		}
+	}
+
+	if err := r.applyRolloutImages(ctx, items, rollout); err != nil {
+		return 0, err
+	}
+
+	// Hold the current wave while instance workloads are scaled down for maintenance.
+	dci := &v1beta1.Instance{}
+	if err := r.Get(ctx, client.ObjectKey{Name: ms.ObjectMeta.Namespace}, dci); err != nil {
+		return 0, err
+	}
+	if isInstanceScaledDown(dci) {
+		rollout.WaveStartedAt = &now
+		rollout.Message = fmt.Sprintf("Waiting for instance maintenance to end before wave %d of %d.",
+			rollout.Wave+1, len(sizes))
+		return ROLLOUT_CHECK_INTERVAL, nil
+	}
+
+	// Check health of tenants in the current wave.
+	bytenant := make(map[string]*v1beta1.TenantMicroservice)
+	for i := range items {
+		bytenant[items[i].Spec.TenantId] = &items[i]
+	}
+	healthy := true
+	failure := ""
+	for _, tid := range rollout.WaveTenants {
+		tms, found := bytenant[tid]
+		if !found {
+			continue
+		}
+		ok, reason, err := r.checkTenantRollout(ctx, tms, rollout.TargetImage)
+		if err != nil {
+			return 0, err
+		}
+		if reason != "" {
+			failure = fmt.Sprintf("Tenant '%s' failed: %s", tid, reason)
+			break
+		}
+		healthy = healthy && ok
+	}
+	if failure == "" && !healthy && rollout.WaveStartedAt != nil &&
+		now.Sub(rollout.WaveStartedAt.Time) > getProgressDeadline(ms) {
+		failure = fmt.Sprintf("Wave %d did not become healthy within %s", rollout.Wave+1, getProgressDeadline(ms))
+	}
+
+	// Revert the failed wave and pause the rollout.
+	if failure != "" {
+		rollout.Phase = v1beta1.RolloutFailed
+		rollout.FailedTenants = rollout.WaveTenants
+		rollout.WaveTenants = nil
+		rollout.Message = fmt.Sprintf("%s. Wave reverted to '%s'.", failure, rollout.PreviousImage)
+		log.Info(fmt.Sprintf("Rollout of '%s' failed: %s", rollout.TargetImage, rollout.Message))
+		return 0, r.applyRolloutImages(ctx, items, rollout)
+	}
+	if !healthy {
+		rollout.Message = fmt.Sprintf("Waiting for wave %d of %d to become healthy.", rollout.Wave+1, len(sizes))
+		return ROLLOUT_CHECK_INTERVAL, nil
+	}
+
+	// Advance to the next wave.
+	rollout.UpdatedTenants = append(rollout.UpdatedTenants, rollout.WaveTenants...)
+	sort.Strings(rollout.UpdatedTenants)
+	rollout.WaveTenants = nil
+	rollout.Wave++
+	if int(rollout.Wave) >= len(sizes) {
+		rollout.Phase = v1beta1.RolloutCompleted
+		rollout.Message = fmt.Sprintf("All %d tenants running '%s'.", len(ordered), rollout.TargetImage)
+		ms.Status.CurrentImage = rollout.TargetImage
+		log.Info(fmt.Sprintf("Rollout of '%s' completed", rollout.TargetImage))
+
+		// Overrides are cleared on the next pass once the current image has been recorded.
+		return ROLLOUT_CHECK_INTERVAL, nil
+	}
+	if ms.Spec.Rollout.Paused {
+		rollout.Phase = v1beta1.RolloutPaused
+		rollout.Message = fmt.Sprintf("Paused after wave %d of %d.", rollout.Wave, len(sizes))
+		return 0, nil
+	}
+	rollout.WaveStartedAt = &now
+	rollout.WaveTenants = selectWaveTenants(ordered, rollout, sizes[rollout.Wave])
+	rollout.Message = fmt.Sprintf("Rolling out wave %d of %d.", rollout.Wave+1, len(sizes))
+	log.Info(fmt.Sprintf("Rollout of '%s' advancing to wave %d", rollout.TargetImage, rollout.Wave+1))
+	return ROLLOUT_CHECK_INTERVAL, r.applyRolloutImages(ctx, items, rollout)
+}
+
+// Update rollout progress in microservice status if changed.
+func (r *MicroserviceReconciler) updateRolloutStatus(ctx context.Context, ms *v1beta1.Microservice,
+	original *v1beta1.MicroserviceStatus, requeue time.Duration) (ctrl.Result, error) {
+	if !reflect.DeepEqual(original, &ms.Status) {
+		if err := r.Status().Update(ctx, ms); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{RequeueAfter: requeue}, nil
+}
@@ -0,0 +1,154 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+const (
+	// Topology key used for default anti-affinity between replicas.
+	DEFAULT_ANTI_AFFINITY_TOPOLOGY_KEY = "kubernetes.io/hostname"
+)
+
+// Merge scheduling settings in order of increasing precedence.
+func mergeScheduling(levels ...*v1beta1.SchedulingSpec) *v1beta1.SchedulingSpec {
+	merged := &v1beta1.SchedulingSpec{}
+	for _, level := range levels {
+		if level == nil {
+			continue
+		}
+		level = level.DeepCopy()
+
+		// Node selector entries are merged by key.
+		for key, value := range level.NodeSelector {
+			if merged.NodeSelector == nil {
+				merged.NodeSelector = make(map[string]string)
+			}
+			merged.NodeSelector[key] = value
+		}
+
+		// Affinity sections replace those from earlier levels.
+		if level.Affinity != nil {
+			if merged.Affinity == nil {
+				merged.Affinity = &corev1.Affinity{}
+			}
+			if level.Affinity.NodeAffinity != nil {
+				merged.Affinity.NodeAffinity = level.Affinity.NodeAffinity
+			}
+			if level.Affinity.PodAffinity != nil {
+				merged.Affinity.PodAffinity = level.Affinity.PodAffinity
+			}
+			if level.Affinity.PodAntiAffinity != nil {
+				merged.Affinity.PodAntiAffinity = level.Affinity.PodAntiAffinity
+			}
+		}
+
+		// Tolerations are appended, skipping exact duplicates.
+		for _, toleration := range level.Tolerations {
+			if !containsToleration(merged.Tolerations, toleration) {
+				merged.Tolerations = append(merged.Tolerations, toleration)
+			}
+		}
+
+		// Topology spread constraints replace those with the same topology key.
+		for _, constraint := range level.TopologySpreadConstraints {
+			replaced := false
+			for i, existing := range merged.TopologySpreadConstraints {
+				if existing.TopologyKey == constraint.TopologyKey {
+					merged.TopologySpreadConstraints[i] = constraint
+					replaced = true
+				}
+			}
+			if !replaced {
+				merged.TopologySpreadConstraints = append(merged.TopologySpreadConstraints, constraint)
+			}
+		}
+
+		if level.DisableDefaultAntiAffinity != nil {
+			merged.DisableDefaultAntiAffinity = level.DisableDefaultAntiAffinity
+		}
+	}
+	return merged
+}
+
+// Indicates whether a toleration is already present in a list.
+func containsToleration(tolerations []corev1.Toleration, toleration corev1.Toleration) bool {
+	for _, existing := range tolerations {
+		if reflect.DeepEqual(existing, toleration) {
+			return true
+		}
+	}
+	return false
+}
+
+// Generate default anti-affinity which prefers placing replicas on different nodes.
+func generateDefaultAntiAffinity(labels map[string]string) *corev1.PodAntiAffinity {
+	return &corev1.PodAntiAffinity{
+		PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+			{
+				Weight: 100,
+				PodAffinityTerm: corev1.PodAffinityTerm{
+					LabelSelector: &metav1.LabelSelector{
+						MatchLabels: labels,
+					},
+					TopologyKey: DEFAULT_ANTI_AFFINITY_TOPOLOGY_KEY,
+				},
+			},
+		},
+	}
+}
+
+// Apply merged instance, microservice and tenant microservice scheduling settings to a pod spec.
+func applyScheduling(pod *corev1.PodSpec, labels map[string]string, dci *v1beta1.Instance,
+	ms *v1beta1.Microservice, tms *v1beta1.TenantMicroservice) {
+	applyMergedScheduling(pod, labels, mergeScheduling(dci.Spec.Scheduling, ms.Spec.Scheduling, tms.Spec.Scheduling))
+}
+
+// Apply instance scheduling settings to a pod spec of a shared instance workload.
+func applyInstanceScheduling(pod *corev1.PodSpec, labels map[string]string, dci *v1beta1.Instance) {
+	applyMergedScheduling(pod, labels, mergeScheduling(dci.Spec.Scheduling))
+}
+
+// Apply merged scheduling settings to a pod spec.
+func applyMergedScheduling(pod *corev1.PodSpec, labels map[string]string, scheduling *v1beta1.SchedulingSpec) {
+	pod.NodeSelector = scheduling.NodeSelector
+	pod.Tolerations = scheduling.Tolerations
+	pod.Affinity = scheduling.Affinity
+	if scheduling.DisableDefaultAntiAffinity == nil || !*scheduling.DisableDefaultAntiAffinity {
+		if pod.Affinity == nil {
+			pod.Affinity = &corev1.Affinity{}
+		}
+		if pod.Affinity.PodAntiAffinity == nil {
+			pod.Affinity.PodAntiAffinity = generateDefaultAntiAffinity(labels)
+		}
+	}
+
+	pod.TopologySpreadConstraints = scheduling.TopologySpreadConstraints
+	for i := range pod.TopologySpreadConstraints {
+		if pod.TopologySpreadConstraints[i].LabelSelector == nil {
+			pod.TopologySpreadConstraints[i].LabelSelector = &metav1.LabelSelector{
+				MatchLabels: labels,
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+func TestGenerateDeploymentScheduling(t *testing.T) {
+	disabled := true
+	enabled := false
+	gpu := corev1.Toleration{Key: "gpu", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}
+	spot := corev1.Toleration{Key: "spot", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}
+	zone := corev1.TopologySpreadConstraint{TopologyKey: "topology.kubernetes.io/zone", MaxSkew: 1,
+		WhenUnsatisfiable: corev1.ScheduleAnyway}
+
+	dci := &v1beta1.Instance{
+		ObjectMeta: metav1.ObjectMeta{Name: "dc1"},
+		Spec: v1beta1.InstanceSpec{Scheduling: &v1beta1.SchedulingSpec{
+			NodeSelector:               map[string]string{"pool": "shared", "arch": "amd64"},
+			Tolerations:                []corev1.Toleration{gpu},
+			DisableDefaultAntiAffinity: &disabled,
+		}},
+	}
+	ms := &v1beta1.Microservice{
+		ObjectMeta: metav1.ObjectMeta{Name: "storage", Namespace: "dc1"},
+		Spec: v1beta1.MicroserviceSpec{
+			Image: "registry.io/storage:1.0",
+			Scheduling: &v1beta1.SchedulingSpec{
+				NodeSelector:              map[string]string{"pool": "storage"},
+				Tolerations:               []corev1.Toleration{gpu, spot},
+				TopologySpreadConstraints: []corev1.TopologySpreadConstraint{zone},
+			},
+		},
+	}
+	dct := &v1beta1.Tenant{ObjectMeta: metav1.ObjectMeta{Name: "acme", Namespace: "dc1"}}
+	tms := &v1beta1.TenantMicroservice{
+		ObjectMeta: metav1.ObjectMeta{Name: "acme-storage", Namespace: "dc1"},
+		Spec: v1beta1.TenantMicroserviceSpec{
+			TenantId:       "acme",
+			MicroserviceId: "storage",
+			Scheduling:     &v1beta1.SchedulingSpec{NodeSelector: map[string]string{"pool": "dedicated"}},
+		},
+	}
+	labels := createDeploymentLabels(tms)
+
+	// Without a tenant override the instance opt-out of default anti-affinity applies.
+	deploy, err := generateDeployment(tms, dct, ms, dci, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod := deploy.Spec.Template.Spec
+	if expected := map[string]string{"pool": "dedicated", "arch": "amd64"}; !reflect.DeepEqual(pod.NodeSelector, expected) {
+		t.Errorf("expected node selector %v, got %v", expected, pod.NodeSelector)
+	}
+	if expected := []corev1.Toleration{gpu, spot}; !reflect.DeepEqual(pod.Tolerations, expected) {
+		t.Errorf("expected tolerations %v, got %v", expected, pod.Tolerations)
+	}
+	if pod.Affinity != nil {
+		t.Errorf("expected default anti-affinity to be disabled, got %+v", pod.Affinity)
+	}
+	if len(pod.TopologySpreadConstraints) != 1 ||
+		!reflect.DeepEqual(pod.TopologySpreadConstraints[0].LabelSelector.MatchLabels, labels) {
+		t.Errorf("expected zone spread selecting tenant pods, got %+v", pod.TopologySpreadConstraints)
+	}
+
+	// The tenant microservice may enable the default anti-affinity again.
+	tms.Spec.Scheduling.DisableDefaultAntiAffinity = &enabled
+	deploy, err = generateDeployment(tms, dct, ms, dci, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	affinity := deploy.Spec.Template.Spec.Affinity
+	if affinity == nil || !reflect.DeepEqual(affinity.PodAntiAffinity, generateDefaultAntiAffinity(labels)) {
+		t.Errorf("expected default anti-affinity to be enabled again, got %+v", affinity)
+	}
+
+	// The instance level is not modified by merging.
+	if dci.Spec.Scheduling.NodeSelector["pool"] != "shared" || !*dci.Spec.Scheduling.DisableDefaultAntiAffinity {
+		t.Errorf("instance scheduling was modified: %+v", dci.Spec.Scheduling)
+	}
+}
@@ -0,0 +1,308 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+const (
+	// Key identifying a reference to a Kubernetes secret within a configuration document.
+	//
+	// Example: {"password": {"$secretRef": {"name": "postgres", "key": "password"}}}
+	SECRET_REF_KEY = "$secretRef"
+
+	// Key replacing a secret reference with the path of the file containing the value.
+	SECRET_FILE_KEY = "$secretFile"
+
+	// Path at which the tenant secret is mounted in tenant microservice pods.
+	TENANT_SECRETS_MOUNT_PATH = "/etc/dct-secrets"
+
+	// Label allowing a secret which does not belong to a tenant to be referenced by all tenants.
+	LABEL_SHARED_SECRET = "devicechain.io.shared-secret"
+)
+
+// Reference to a key within a secret in the instance namespace. Only secrets labeled with the
+// tenant id or explicitly shared may be referenced.
+type SecretReference struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// Resolves secret references in configuration documents.
+type secretResolver struct {
+	client    client.Client
+	ctx       context.Context
+	namespace string
+	tenant    string
+	prefix    string
+	secrets   map[string]*corev1.Secret
+	values    map[string][]byte
+}
+
+// Get the tenant secret key under which a referenced value is stored.
+func (sr *secretResolver) getSecretKey(ref SecretReference) string {
+	return fmt.Sprintf("%s.%s.%s", sr.prefix, ref.Name, ref.Key)
+}
+
+// Get whether a secret is managed by the operator for the instance rather than a tenant.
+// Infrastructure credentials, pull secrets and tenant secrets may never be referenced.
+func isOperatorManagedSecret(secret *corev1.Secret) bool {
+	if strings.HasPrefix(secret.ObjectMeta.Name, "dci-") {
+		return true
+	}
+	if secret.Type == corev1.SecretTypeServiceAccountToken || secret.Type == corev1.SecretTypeDockerConfigJson ||
+		secret.Type == corev1.SecretTypeDockercfg {
+		return true
+	}
+	labels := secret.ObjectMeta.Labels
+	if _, found := labels[LABEL_INFRASTRUCTURE]; found {
+		return true
+	}
+	if _, found := labels[LABEL_MANAGED_PULL_SECRET]; found {
+		return true
+	}
+	tid, found := labels[v1beta1.LABEL_TENANT]
+	return found && secret.ObjectMeta.Name == getTenantSecretName(tid)
+}
+
+// Get whether a secret may be referenced by configuration of the given tenant.
+func isSecretReferenceAllowed(secret *corev1.Secret, tid string) bool {
+	if isOperatorManagedSecret(secret) {
+		return false
+	}
+	if owner, found := secret.ObjectMeta.Labels[v1beta1.LABEL_TENANT]; found {
+		return owner == tid
+	}
+	return secret.ObjectMeta.Labels[LABEL_SHARED_SECRET] == "true"
+}
+
+// Look up the value for a secret reference.
+func (sr *secretResolver) lookup(ref SecretReference) ([]byte, error) {
+	if ref.Name == "" || ref.Key == "" {
+		return nil, fmt.Errorf("secret reference must include name and key")
+	}
+	secret, found := sr.secrets[ref.Name]
+	if !found {
+		secret = &corev1.Secret{}
+		err := sr.client.Get(sr.ctx, client.ObjectKey{Namespace: sr.namespace, Name: ref.Name}, secret)
+		if err != nil {
+			return nil, err
+		}
+		if !isSecretReferenceAllowed(secret, sr.tenant) {
+			return nil, fmt.Errorf("secret '%s' may not be referenced by tenant '%s'", ref.Name, sr.tenant)
+		}
+		sr.secrets[ref.Name] = secret
+	}
+	value, found := secret.Data[ref.Key]
+	if !found {
+		return nil, fmt.Errorf("key '%s' not found in secret '%s'", ref.Key, ref.Name)
+	}
+	return value, nil
+}
+
+// Walk a configuration document replacing secret references with file references.
+func (sr *secretResolver) resolve(node interface{}) (interface{}, error) {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		if raw, found := value[SECRET_REF_KEY]; found && len(value) == 1 {
+			encoded, err := json.Marshal(raw)
+			if err != nil {
+				return nil, err
+			}
+			ref := SecretReference{}
+			if err := json.Unmarshal(encoded, &ref); err != nil {
+				return nil, err
+			}
+			secret, err := sr.lookup(ref)
+			if err != nil {
+				return nil, err
+			}
+			key := sr.getSecretKey(ref)
+			sr.values[key] = secret
+			return map[string]interface{}{SECRET_FILE_KEY: path.Join(TENANT_SECRETS_MOUNT_PATH, key)}, nil
+		}
+		for k, v := range value {
+			resolved, err := sr.resolve(v)
+			if err != nil {
+				return nil, err
+			}
+			value[k] = resolved
+		}
+		return value, nil
+	case []interface{}:
+		for i, v := range value {
+			resolved, err := sr.resolve(v)
+			if err != nil {
+				return nil, err
+			}
+			value[i] = resolved
+		}
+		return value, nil
+	}
+	return node, nil
+}
+
+// Collect names of secrets referenced within a configuration document.
+func collectSecretReferenceNames(node interface{}, names map[string]bool) {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		if ref, found := value[SECRET_REF_KEY].(map[string]interface{}); found && len(value) == 1 {
+			if name, ok := ref["name"].(string); ok {
+				names[name] = true
+			}
+			return
+		}
+		for _, v := range value {
+			collectSecretReferenceNames(v, names)
+		}
+	case []interface{}:
+		for _, v := range value {
+			collectSecretReferenceNames(v, names)
+		}
+	}
+}
+
+// Get names of secrets referenced by a configuration document.
+func getReferencedSecretNames(config json.RawMessage) map[string]bool {
+	names := make(map[string]bool)
+	if len(config) == 0 || !strings.Contains(string(config), SECRET_REF_KEY) {
+		return names
+	}
+	var doc interface{}
+	if err := json.Unmarshal(config, &doc); err != nil {
+		return names
+	}
+	collectSecretReferenceNames(doc, names)
+	return names
+}
+
+// Resolve secret references in a configuration document of a tenant. Returns the document with
+// references replaced by file paths along with the secret values keyed by file name.
+func resolveSecretReferences(ctx context.Context, c client.Client, ns string, tid string, prefix string,
+	config json.RawMessage) (json.RawMessage, map[string][]byte, error) {
+	sr := &secretResolver{
+		client:    c,
+		ctx:       ctx,
+		namespace: ns,
+		tenant:    tid,
+		prefix:    prefix,
+		secrets:   make(map[string]*corev1.Secret),
+		values:    make(map[string][]byte),
+	}
+	if len(config) == 0 || !strings.Contains(string(config), SECRET_REF_KEY) {
+		return config, sr.values, nil
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(config, &doc); err != nil {
+		return nil, nil, err
+	}
+	resolved, err := sr.resolve(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	encoded, err := json.Marshal(resolved)
+	if err != nil {
+		return nil, nil, err
+	}
+	return encoded, sr.values, nil
+}
+
+// Generate an empty tenant secret
+func generateTenantSecret(tid string, ns string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getTenantSecretName(tid),
+			Namespace: ns,
+			Labels: map[string]string{
+				v1beta1.LABEL_TENANT: tid,
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{},
+	}
+}
+
+// Create tenant secret if not found
+func createTenantSecretIfMissing(ctx context.Context, c client.Client, tid string, ns string) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	err := c.Get(ctx, client.ObjectKey{Namespace: ns, Name: getTenantSecretName(tid)}, secret)
+	if err == nil {
+		return secret, nil
+	}
+	if !errors.IsNotFound(err) {
+		return nil, err
+	}
+	secret = generateTenantSecret(tid, ns)
+	if err := c.Create(ctx, secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// Replace tenant secret entries with the given prefix by the given values.
+func updateTenantSecretEntries(ctx context.Context, c client.Client, tid string, ns string, prefix string,
+	values map[string][]byte) error {
+	secret, err := createTenantSecretIfMissing(ctx, c, tid, ns)
+	if err != nil {
+		return err
+	}
+
+	data := make(map[string][]byte)
+	for key, value := range secret.Data {
+		if !strings.HasPrefix(key, prefix+".") {
+			data[key] = value
+		}
+	}
+	for key, value := range values {
+		data[key] = value
+	}
+	if reflect.DeepEqual(data, secret.Data) || (len(data) == 0 && len(secret.Data) == 0) {
+		return nil
+	}
+	secret.Data = data
+	return c.Update(ctx, secret)
+}
+
+// Delete tenant secret if it exists
+func deleteTenantSecret(ctx context.Context, c client.Client, tid string, ns string) error {
+	secret := &corev1.Secret{}
+	err := c.Get(ctx, client.ObjectKey{Namespace: ns, Name: getTenantSecretName(tid)}, secret)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if err := c.Delete(ctx, secret); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
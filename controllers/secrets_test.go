@@ -0,0 +1,138 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+// Create a secret in the test instance namespace.
+func newTestSecret(name string, labels map[string]string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "dc1", Labels: labels},
+		Data:       map[string][]byte{"password": []byte("secret")},
+	}
+}
+
+func TestResolveSecretReferences(t *testing.T) {
+	c := fake.NewClientBuilder().WithObjects(
+		newTestSecret("acme-db", map[string]string{v1beta1.LABEL_TENANT: "acme"}),
+		newTestSecret("other-db", map[string]string{v1beta1.LABEL_TENANT: "other"}),
+		newTestSecret("smtp", map[string]string{LABEL_SHARED_SECRET: "true"}),
+		newTestSecret("unlabeled", nil),
+		newTestSecret(getTenantSecretName("acme"), map[string]string{v1beta1.LABEL_TENANT: "acme"}),
+		newTestSecret(getTenantCredentialsSecretName("acme"), map[string]string{v1beta1.LABEL_TENANT: "acme"}),
+		newTestSecret(getTenantCredentialsSecretName("other"), map[string]string{v1beta1.LABEL_TENANT: "other"}),
+		newTestSecret(getInfrastructureName("dc1", INFRASTRUCTURE_POSTGRESQL),
+			getInfrastructureLabels(INFRASTRUCTURE_POSTGRESQL)),
+		newTestSecret(getInfrastructureName("dc1", INFRASTRUCTURE_POSTGRESQL)+"-superuser",
+			map[string]string{LABEL_SHARED_SECRET: "true"}),
+		newTestSecret("registry", map[string]string{LABEL_MANAGED_PULL_SECRET: "true", LABEL_SHARED_SECRET: "true"}),
+	).Build()
+
+	tests := []struct {
+		secret  string
+		allowed bool
+	}{
+		{"acme-db", true},
+		{getTenantCredentialsSecretName("acme"), true},
+		{"smtp", true},
+		{"other-db", false},
+		{getTenantCredentialsSecretName("other"), false},
+		{"unlabeled", false},
+		{getTenantSecretName("acme"), false},
+		{getInfrastructureName("dc1", INFRASTRUCTURE_POSTGRESQL), false},
+		{getInfrastructureName("dc1", INFRASTRUCTURE_POSTGRESQL) + "-superuser", false},
+		{"registry", false},
+		{"missing", false},
+	}
+	for _, test := range tests {
+		t.Run(test.secret, func(t *testing.T) {
+			config := json.RawMessage(`{"db": {"password": {"$secretRef": {"name": "` + test.secret + `", "key": "password"}}}}`)
+			resolved, values, err := resolveSecretReferences(context.Background(), c, "dc1", "acme", "storage", config)
+			if !test.allowed {
+				if err == nil {
+					t.Error("expected reference to be rejected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			key := "storage." + test.secret + ".password"
+			if string(values[key]) != "secret" {
+				t.Errorf("expected value under '%s', got %v", key, values)
+			}
+			expected := `{"db":{"password":{"$secretFile":"/etc/dct-secrets/` + key + `"}}}`
+			if string(resolved) != expected {
+				t.Errorf("expected %s, got %s", expected, resolved)
+			}
+		})
+	}
+}
+
+func TestFindTenantMicroservicesForSecret(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := v1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	referencing := func(name string, tenant string, secret string) *v1beta1.TenantMicroservice {
+		tms := &v1beta1.TenantMicroservice{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "dc1",
+				Labels: map[string]string{v1beta1.LABEL_TENANT: tenant}},
+		}
+		tms.Spec.Configuration.RawMessage = json.RawMessage(
+			`{"servers": [{"password": {"$secretRef": {"name": "` + secret + `", "key": "password"}}}]}`)
+		return tms
+	}
+	r := &TenantMicroserviceReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			referencing("acme-storage", "acme", "acme-db"),
+			referencing("acme-mail", "acme", "smtp"),
+			referencing("other-mail", "other", "smtp"),
+			referencing("other-storage", "other", "acme-db"),
+		).Build(),
+		Scheme: scheme,
+	}
+
+	// A tenant secret only triggers the tenant's own microservices which reference it.
+	requests := r.findTenantMicroservicesForSecret(newTestSecret("acme-db", map[string]string{v1beta1.LABEL_TENANT: "acme"}))
+	if len(requests) != 1 || requests[0].Name != "acme-storage" {
+		t.Errorf("expected only acme-storage for tenant secret, got %v", requests)
+	}
+	// A shared secret triggers every microservice referencing it.
+	requests = r.findTenantMicroservicesForSecret(newTestSecret("smtp", map[string]string{LABEL_SHARED_SECRET: "true"}))
+	if len(requests) != 2 {
+		t.Errorf("expected both mail microservices for shared secret, got %v", requests)
+	}
+	// Secrets which can not be referenced trigger nothing.
+	if requests := r.findTenantMicroservicesForSecret(newTestSecret("smtp", nil)); len(requests) != 0 {
+		t.Errorf("expected no requests for unshared secret, got %v", requests)
+	}
+}
@@ -0,0 +1,197 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+const (
+	LABEL_PSA_ENFORCE = "pod-security.kubernetes.io/enforce"
+	LABEL_PSA_AUDIT   = "pod-security.kubernetes.io/audit"
+	LABEL_PSA_WARN    = "pod-security.kubernetes.io/warn"
+
+	// Writable scratch space mounted when the root filesystem is read-only.
+	TMP_VOLUME_NAME = "tmp"
+	TMP_MOUNT_PATH  = "/tmp"
+)
+
+// Get the Pod Security Admission labels for an instance namespace.
+func getPodSecurityLabels(dci *v1beta1.Instance) map[string]string {
+	enforce, audit, warn := v1beta1.PodSecurityRestricted, v1beta1.PodSecurityRestricted, v1beta1.PodSecurityRestricted
+	if psa := dci.Spec.PodSecurity; psa != nil {
+		if psa.Enforce != "" {
+			enforce = psa.Enforce
+		}
+		if psa.Audit != "" {
+			audit = psa.Audit
+		}
+		if psa.Warn != "" {
+			warn = psa.Warn
+		}
+	}
+	return map[string]string{
+		LABEL_PSA_ENFORCE: string(enforce),
+		LABEL_PSA_AUDIT:   string(audit),
+		LABEL_PSA_WARN:    string(warn),
+	}
+}
+
+// Capabilities which may be added under the baseline Pod Security Standard.
+var baselineCapabilities = map[corev1.Capability]bool{
+	"AUDIT_WRITE": true, "CHOWN": true, "DAC_OVERRIDE": true, "FOWNER": true, "FSETID": true,
+	"KILL": true, "MKNOD": true, "NET_BIND_SERVICE": true, "SETFCAP": true, "SETGID": true,
+	"SETPCAP": true, "SETUID": true, "SYS_CHROOT": true,
+}
+
+// Order of Pod Security Standards levels from least to most restrictive.
+var podSecurityLevelOrder = map[v1beta1.PodSecurityLevel]int{
+	v1beta1.PodSecurityPrivileged: 0,
+	v1beta1.PodSecurityBaseline:   1,
+	v1beta1.PodSecurityRestricted: 2,
+}
+
+// Get the most restrictive Pod Security Standards level which admits microservice pods.
+func getRequiredPodSecurityLevel(ms *v1beta1.Microservice) v1beta1.PodSecurityLevel {
+	ex := ms.Spec.SecurityExceptions
+	if ex == nil {
+		return v1beta1.PodSecurityRestricted
+	}
+	required := v1beta1.PodSecurityRestricted
+	if ex.RunAsRoot || ex.AllowPrivilegeEscalation {
+		required = v1beta1.PodSecurityBaseline
+	}
+	for _, capability := range ex.AddCapabilities {
+		if !baselineCapabilities[capability] {
+			return v1beta1.PodSecurityPrivileged
+		}
+		if capability != "NET_BIND_SERVICE" {
+			required = v1beta1.PodSecurityBaseline
+		}
+	}
+	if ex.UnconfinedSeccomp {
+		return v1beta1.PodSecurityPrivileged
+	}
+	return required
+}
+
+// Get the reason microservice pods would be rejected by the level enforced on the instance
+// namespace (empty if they are admitted).
+func getPodSecurityViolation(dci *v1beta1.Instance, ms *v1beta1.Microservice) string {
+	enforce := v1beta1.PodSecurityLevel(getPodSecurityLabels(dci)[LABEL_PSA_ENFORCE])
+	required := getRequiredPodSecurityLevel(ms)
+	if podSecurityLevelOrder[required] >= podSecurityLevelOrder[enforce] {
+		return ""
+	}
+	return fmt.Sprintf("security exceptions (%s) require pod security level '%s' but instance '%s' enforces '%s'",
+		strings.Join(getSecurityExceptions(ms), ", "), required, dci.ObjectMeta.Name, enforce)
+}
+
+// Get descriptions of the security exceptions requested by a microservice.
+func getSecurityExceptions(ms *v1beta1.Microservice) []string {
+	exceptions := make([]string, 0)
+	ex := ms.Spec.SecurityExceptions
+	if ex == nil {
+		return exceptions
+	}
+	if ex.RunAsRoot {
+		exceptions = append(exceptions, "runAsRoot")
+	}
+	if ex.WritableRootFilesystem {
+		exceptions = append(exceptions, "writableRootFilesystem")
+	}
+	if ex.AllowPrivilegeEscalation {
+		exceptions = append(exceptions, "allowPrivilegeEscalation")
+	}
+	if ex.UnconfinedSeccomp {
+		exceptions = append(exceptions, "unconfinedSeccomp")
+	}
+	for _, capability := range ex.AddCapabilities {
+		exceptions = append(exceptions, fmt.Sprintf("addCapability:%s", capability))
+	}
+	return exceptions
+}
+
+// Generate the pod-level security context for a microservice.
+func generatePodSecurityContext(ms *v1beta1.Microservice) *corev1.PodSecurityContext {
+	ex := ms.Spec.SecurityExceptions
+	if ex == nil {
+		ex = &v1beta1.SecurityExceptionsSpec{}
+	}
+
+	nonroot := !ex.RunAsRoot
+	seccomp := corev1.SeccompProfileTypeRuntimeDefault
+	if ex.UnconfinedSeccomp {
+		seccomp = corev1.SeccompProfileTypeUnconfined
+	}
+	return &corev1.PodSecurityContext{
+		RunAsNonRoot: &nonroot,
+		SeccompProfile: &corev1.SeccompProfile{
+			Type: seccomp,
+		},
+	}
+}
+
+// Generate the container-level security context for a microservice.
+func generateContainerSecurityContext(ms *v1beta1.Microservice) *corev1.SecurityContext {
+	ex := ms.Spec.SecurityExceptions
+	if ex == nil {
+		ex = &v1beta1.SecurityExceptionsSpec{}
+	}
+
+	nonroot := !ex.RunAsRoot
+	readonly := !ex.WritableRootFilesystem
+	escalation := ex.AllowPrivilegeEscalation
+	return &corev1.SecurityContext{
+		RunAsNonRoot:             &nonroot,
+		ReadOnlyRootFilesystem:   &readonly,
+		AllowPrivilegeEscalation: &escalation,
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+			Add:  ex.AddCapabilities,
+		},
+	}
+}
+
+// Apply hardened security settings (with microservice exceptions) to a pod spec.
+func applySecurityContext(pod *corev1.PodSpec, ms *v1beta1.Microservice) {
+	pod.SecurityContext = generatePodSecurityContext(ms)
+	for i := range pod.Containers {
+		pod.Containers[i].SecurityContext = generateContainerSecurityContext(ms)
+	}
+
+	// Provide writable scratch space when the root filesystem is read-only.
+	if ms.Spec.SecurityExceptions == nil || !ms.Spec.SecurityExceptions.WritableRootFilesystem {
+		pod.Volumes = append(pod.Volumes, corev1.Volume{
+			Name: TMP_VOLUME_NAME,
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
+		})
+		for i := range pod.Containers {
+			pod.Containers[i].VolumeMounts = append(pod.Containers[i].VolumeMounts, corev1.VolumeMount{
+				Name:      TMP_VOLUME_NAME,
+				MountPath: TMP_MOUNT_PATH,
+			})
+		}
+	}
+}
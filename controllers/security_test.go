@@ -0,0 +1,66 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+func TestPodSecurityViolation(t *testing.T) {
+	root := &v1beta1.Microservice{Spec: v1beta1.MicroserviceSpec{
+		SecurityExceptions: &v1beta1.SecurityExceptionsSpec{RunAsRoot: true},
+	}}
+	netadmin := &v1beta1.Microservice{Spec: v1beta1.MicroserviceSpec{
+		SecurityExceptions: &v1beta1.SecurityExceptionsSpec{AddCapabilities: []corev1.Capability{"NET_ADMIN"}},
+	}}
+	bind := &v1beta1.Microservice{Spec: v1beta1.MicroserviceSpec{
+		SecurityExceptions: &v1beta1.SecurityExceptionsSpec{
+			WritableRootFilesystem: true,
+			AddCapabilities:        []corev1.Capability{"NET_BIND_SERVICE"},
+		},
+	}}
+	instance := func(enforce v1beta1.PodSecurityLevel) *v1beta1.Instance {
+		return &v1beta1.Instance{
+			ObjectMeta: metav1.ObjectMeta{Name: "dc1"},
+			Spec:       v1beta1.InstanceSpec{PodSecurity: &v1beta1.PodSecuritySpec{Enforce: enforce}},
+		}
+	}
+
+	// Exceptions allowed by the restricted level are admitted by default.
+	if violation := getPodSecurityViolation(instance(""), bind); violation != "" {
+		t.Errorf("expected restricted exceptions to be admitted, got: %s", violation)
+	}
+	// Running as root is rejected by default but admitted once the instance enforces baseline.
+	if getPodSecurityViolation(instance(""), root) == "" {
+		t.Error("expected running as root to be rejected by the default level")
+	}
+	if violation := getPodSecurityViolation(instance(v1beta1.PodSecurityBaseline), root); violation != "" {
+		t.Errorf("expected running as root to be admitted by baseline, got: %s", violation)
+	}
+	// Capabilities outside the baseline set need the privileged level.
+	if getPodSecurityViolation(instance(v1beta1.PodSecurityBaseline), netadmin) == "" {
+		t.Error("expected NET_ADMIN to be rejected by baseline")
+	}
+	if violation := getPodSecurityViolation(instance(v1beta1.PodSecurityPrivileged), netadmin); violation != "" {
+		t.Errorf("expected NET_ADMIN to be admitted by privileged, got: %s", violation)
+	}
+}
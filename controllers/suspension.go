@@ -0,0 +1,223 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+const (
+	// KEDA annotation which holds a scaled deployment at a fixed replica count.
+	ANNOTATION_KEDA_PAUSED_REPLICAS = "autoscaling.keda.sh/paused-replicas"
+)
+
+// Indicates whether a tenant has been suspended (as opposed to only requested).
+func isTenantSuspended(tenant *v1beta1.Tenant) bool {
+	return tenant.Status.SuspendedAt != nil
+}
+
+// Indicates whether tenant workloads are held at zero replicas by suspension or maintenance.
+func isTenantScaledDown(tenant *v1beta1.Tenant, dci *v1beta1.Instance) bool {
+	return isTenantSuspended(tenant) || isInstanceScaledDown(dci)
+}
+
+// Add or remove the KEDA pause annotation on a scaled object. Returns true if changed.
+func setScaledObjectPaused(so *unstructured.Unstructured, paused bool) bool {
+	annotations := so.GetAnnotations()
+	_, found := annotations[ANNOTATION_KEDA_PAUSED_REPLICAS]
+	if paused == found {
+		return false
+	}
+	if paused {
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		annotations[ANNOTATION_KEDA_PAUSED_REPLICAS] = "0"
+	} else {
+		delete(annotations, ANNOTATION_KEDA_PAUSED_REPLICAS)
+	}
+	so.SetAnnotations(annotations)
+	return true
+}
+
+// Pause or resume KEDA autoscaling for all deployments of a tenant.
+func (r *TenantReconciler) pauseTenantScaledObjects(ctx context.Context, tenant *v1beta1.Tenant, paused bool) error {
+	solist := &unstructured.UnstructuredList{}
+	solist.SetGroupVersionKind(scaledObjectGVK.GroupVersion().WithKind(scaledObjectGVK.Kind + "List"))
+	err := r.List(ctx, solist, client.InNamespace(tenant.ObjectMeta.Namespace),
+		client.MatchingLabels{v1beta1.LABEL_TENANT: tenant.ObjectMeta.Name})
+	if err != nil {
+		// Nothing to pause on a cluster without KEDA installed.
+		if meta.IsNoMatchError(err) {
+			return nil
+		}
+		return err
+	}
+	for i := range solist.Items {
+		if setScaledObjectPaused(&solist.Items[i], paused) {
+			if err := r.Update(ctx, &solist.Items[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Scale tenant deployments to zero, recording the replica count of each so it can be restored.
+func (r *TenantReconciler) suspendTenant(ctx context.Context, tenant *v1beta1.Tenant, dci *v1beta1.Instance,
+	deploys *appsv1.DeploymentList) error {
+	log := logf.FromContext(ctx)
+
+	// Record replica counts before scaling so they survive a failure part way through.
+	status := tenant.Status.DeepCopy()
+	if status.SuspendedReplicas == nil {
+		status.SuspendedReplicas = make(map[string]int32)
+	}
+	for _, deploy := range deploys.Items {
+		if _, found := status.SuspendedReplicas[deploy.ObjectMeta.Name]; found {
+			continue
+		}
+		replicas := int32(1)
+		if deploy.Spec.Replicas != nil {
+			replicas = *deploy.Spec.Replicas
+		}
+		// Deployments already scaled down for instance maintenance keep the count recorded there.
+		if isInstanceScaledDown(dci) {
+			if recorded, found := dci.Status.Maintenance.ScaledReplicas[deploy.ObjectMeta.Name]; found {
+				replicas = recorded
+			}
+		}
+		if replicas > 0 {
+			status.SuspendedReplicas[deploy.ObjectMeta.Name] = replicas
+		}
+	}
+	if status.SuspendedAt == nil {
+		now := metav1.Now()
+		status.SuspendedAt = &now
+		log.Info(fmt.Sprintf("Suspending tenant '%s'", tenant.ObjectMeta.Name))
+	}
+	status.SuspensionReason = tenant.Spec.SuspensionReason
+	if !reflect.DeepEqual(*status, tenant.Status) {
+		tenant.Status = *status
+		if err := r.Status().Update(ctx, tenant); err != nil {
+			return err
+		}
+	}
+
+	if err := r.pauseTenantScaledObjects(ctx, tenant, true); err != nil {
+		return err
+	}
+	for i := range deploys.Items {
+		deploy := &deploys.Items[i]
+		if deploy.Spec.Replicas != nil && *deploy.Spec.Replicas == 0 {
+			continue
+		}
+		deploy.Spec.Replicas = new(int32)
+		if err := r.Update(ctx, deploy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore tenant deployments to the replica counts recorded when suspended. Suspension is
+// lifted in status first so tenant microservice reconciles do not scale deployments back down.
+// The recorded counts are kept until restored so a failure part way through can be resumed.
+func (r *TenantReconciler) resumeTenant(ctx context.Context, tenant *v1beta1.Tenant,
+	deploys *appsv1.DeploymentList) error {
+	log := logf.FromContext(ctx)
+
+	if isTenantSuspended(tenant) {
+		tenant.Status.SuspendedAt = nil
+		tenant.Status.SuspensionReason = ""
+		if err := r.Status().Update(ctx, tenant); err != nil {
+			return err
+		}
+	}
+
+	for i := range deploys.Items {
+		deploy := &deploys.Items[i]
+		replicas, found := tenant.Status.SuspendedReplicas[deploy.ObjectMeta.Name]
+		if !found {
+			// Deployments created while suspended start with the default replica count.
+			if deploy.Spec.Replicas == nil || *deploy.Spec.Replicas != 0 {
+				continue
+			}
+			replicas = 1
+		}
+		deploy.Spec.Replicas = &replicas
+		if err := r.Update(ctx, deploy); err != nil {
+			return err
+		}
+	}
+	if err := r.pauseTenantScaledObjects(ctx, tenant, false); err != nil {
+		return err
+	}
+
+	if tenant.Status.SuspendedReplicas != nil {
+		tenant.Status.SuspendedReplicas = nil
+		if err := r.Status().Update(ctx, tenant); err != nil {
+			return err
+		}
+	}
+	log.Info(fmt.Sprintf("Resumed tenant '%s'", tenant.ObjectMeta.Name))
+	return nil
+}
+
+// Suspend or resume a tenant based on the requested state, routing its ingress accordingly.
+func (r *TenantReconciler) reconcileTenantSuspension(ctx context.Context, tenant *v1beta1.Tenant) error {
+	if !tenant.Spec.Suspended && !isTenantSuspended(tenant) && tenant.Status.SuspendedReplicas == nil {
+		return nil
+	}
+
+	// Workloads stay scaled down until instance maintenance completes.
+	dci := &v1beta1.Instance{}
+	if err := r.Get(ctx, client.ObjectKey{Name: tenant.ObjectMeta.Namespace}, dci); err != nil {
+		return err
+	}
+	if !tenant.Spec.Suspended && isInstanceScaledDown(dci) {
+		return nil
+	}
+
+	deploys := &appsv1.DeploymentList{}
+	err := r.List(ctx, deploys, client.InNamespace(tenant.ObjectMeta.Namespace),
+		client.MatchingLabels{v1beta1.LABEL_TENANT: tenant.ObjectMeta.Name})
+	if err != nil {
+		return err
+	}
+
+	if tenant.Spec.Suspended {
+		err = r.suspendTenant(ctx, tenant, dci, deploys)
+	} else {
+		err = r.resumeTenant(ctx, tenant, deploys)
+	}
+	if err != nil {
+		return err
+	}
+	return reconcileTenantIngress(ctx, r.Client, tenant, dci)
+}
@@ -22,13 +22,19 @@ import (
 
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 
+	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	netv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	"github.com/devicechain-io/dc-k8s/api/v1beta1"
 )
@@ -36,12 +42,22 @@ import (
 // TenantReconciler reconciles a Tenant object
 type TenantReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
 }
 
 //+kubebuilder:rbac:groups=core.devicechain.io,resources=tenants,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core.devicechain.io,resources=tenants/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=core.devicechain.io,resources=tenants/finalizers,verbs=update
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=core.devicechain.io,resources=tenantplans,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core.devicechain.io,resources=tenantmicroservices,verbs=get;list;watch;delete
+//+kubebuilder:rbac:groups=keda.sh,resources=scaledobjects,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 func (r *TenantReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := logf.FromContext(ctx)
 
@@ -60,25 +76,49 @@ func (r *TenantReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 	}
 	log.Info(fmt.Sprintf("Handling added/updated tenant: %+v", req.NamespacedName))
 
-	// Get list of tenantmicroservices indexed by microservice id
-	tmsbymsid, err := getTenantMicroservicesByMicroserviceId(tenant)
+	// Warn about, suspend or delete the tenant as it approaches and reaches expiry.
+	expiry, deleted, err := r.reconcileTenantExpiry(ctx, tenant)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if deleted {
+		return ctrl.Result{}, nil
+	}
+
+	// Look up the plan which determines enabled microservices.
+	plan, planned, err := r.reconcileTenantPlan(ctx, tenant)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
-	// Find microservices where no tenantmicroservice exists for the tenant
-	missing, err := getMicroservicesWithNoTenantMicroservice(ctx, tenant, tmsbymsid)
+	// Get list of tenantmicroservices indexed by microservice id
+	tmsbymsid, err := getTenantMicroservicesByMicroserviceId(tenant)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
-	// Add tenant microservice for those that were missing.
-	for _, ms := range missing {
-		tms, err := handleMissingTenantMicroservice(tenant, ms)
+	// Leave tenant microservices unchanged if the plan could not be loaded.
+	if planned {
+		// Remove tenant microservices which are not enabled by the plan or excluded by the tenant.
+		err = r.removeDisabledTenantMicroservices(ctx, tenant, plan, tmsbymsid)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
-		log.Info(fmt.Sprintf("Added missing tenant microservice (%s/%s)", tms.Spec.TenantId, tms.Spec.MicroserviceId))
+
+		// Find microservices where no tenantmicroservice exists for the tenant
+		missing, err := getMicroservicesWithNoTenantMicroservice(ctx, tenant, plan, tmsbymsid)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+
+		// Add tenant microservice for those that were missing.
+		for _, ms := range missing {
+			tms, err := handleMissingTenantMicroservice(tenant, ms)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			log.Info(fmt.Sprintf("Added missing tenant microservice (%s/%s)", tms.Spec.TenantId, tms.Spec.MicroserviceId))
+		}
 	}
 
 	// Create tenant config map if not found
@@ -91,16 +131,80 @@ func (r *TenantReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 		log.Info(fmt.Sprintf("Created tenant config map '%s'", cmap.ObjectMeta.Name))
 	}
 
-	return ctrl.Result{}, nil
+	// Create tenant secret if not found
+	_, err = createTenantSecretIfMissing(ctx, r.Client, tenant.ObjectMeta.Name, tenant.ObjectMeta.Namespace)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Create or update role used by tenant workloads.
+	err = r.reconcileTenantRole(ctx, tenant)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Create, update or remove tenant network isolation.
+	err = r.reconcileTenantNetworkPolicy(ctx, tenant)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Scale tenant workloads down or back up if suspension was requested or lifted.
+	err = r.reconcileTenantSuspension(ctx, tenant)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Report resource usage against the tenant budget.
+	err = r.reconcileTenantResourceUsage(ctx, tenant)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Generate missing credentials and rotate those which are due.
+	next, err := r.reconcileTenantCredentials(ctx, tenant)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if expiry > 0 && (next == 0 || expiry < next) {
+		next = expiry
+	}
+
+	return ctrl.Result{RequeueAfter: next}, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *TenantReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&v1beta1.Tenant{}).
+		Owns(&v1.Secret{}).
+		Watches(&source.Kind{Type: &v1beta1.Instance{}},
+			handler.EnqueueRequestsFromMapFunc(r.findTenantsForInstance)).
+		Watches(&source.Kind{Type: &v1beta1.TenantPlan{}},
+			handler.EnqueueRequestsFromMapFunc(r.findTenantsForPlan)).
+		Watches(&source.Kind{Type: &v1beta1.TenantMicroservice{}},
+			handler.EnqueueRequestsFromMapFunc(r.findTenantForLabeledObject)).
+		Watches(&source.Kind{Type: &appsv1.Deployment{}},
+			handler.EnqueueRequestsFromMapFunc(r.findTenantForLabeledObject)).
 		Complete(r)
 }
 
+// Find tenants affected by a change to an instance.
+func (r *TenantReconciler) findTenantsForInstance(obj client.Object) []reconcile.Request {
+	tenants := &v1beta1.TenantList{}
+	if err := r.List(context.Background(), tenants, client.InNamespace(obj.GetName())); err != nil {
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0)
+	for _, tenant := range tenants.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: tenant.ObjectMeta.Namespace, Name: tenant.ObjectMeta.Name},
+		})
+	}
+	return requests
+}
+
 // Handle case where there is no tenantmicroservice for a tenant/microservice combination.
 func handleMissingTenantMicroservice(tenant *v1beta1.Tenant, ms v1beta1.Microservice) (*v1beta1.TenantMicroservice, error) {
 	return v1beta1.CreateTenantMicroservice(v1beta1.TenantMicroserviceCreateRequest{
@@ -128,8 +232,8 @@ func getTenantMicroservicesByMicroserviceId(tenant *v1beta1.Tenant) (map[string]
 	return tmsbymsid, nil
 }
 
-// Get list of microservices that do not have a tenantmicroservice for tenant.
-func getMicroservicesWithNoTenantMicroservice(ctx context.Context, tenant *v1beta1.Tenant,
+// Get list of microservices enabled for tenant that do not have a tenantmicroservice for tenant.
+func getMicroservicesWithNoTenantMicroservice(ctx context.Context, tenant *v1beta1.Tenant, plan *v1beta1.TenantPlan,
 	tmsbymsid map[string]v1beta1.TenantMicroservice) ([]v1beta1.Microservice, error) {
 	log := logf.FromContext(ctx)
 
@@ -142,7 +246,10 @@ func getMicroservicesWithNoTenantMicroservice(ctx context.Context, tenant *v1bet
 	// Loop through microservices and look up tenantmicroservices by id to find missing items
 	missing := make([]v1beta1.Microservice, 0)
 	for _, ms := range mslist.Items {
-		if _, present := tmsbymsid[ms.ObjectMeta.Name]; !present {
+		if _, present := tmsbymsid[ms.ObjectMeta.Name]; present {
+			continue
+		}
+		if isFunctionalAreaEnabled(tenant, plan, ms.Spec.FunctionalArea) {
 			missing = append(missing, ms)
 		}
 	}
@@ -185,12 +292,52 @@ func (r *TenantReconciler) handleTenantDeleted(ctx context.Context, req ctrl.Req
 		log.Info(fmt.Sprintf("Deleted tenant microservice '%s' due to tenant delete.", tms.ObjectMeta.Name))
 	}
 
+	// Keep tenant configuration until tenant microservices are deprovisioned (the tenant is
+	// requeued as each one is removed).
+	pending, err := v1beta1.GetTenantMicroservicesForTenant(v1beta1.TenantMicroserviceByTenantRequest{
+		InstanceId: req.NamespacedName.Namespace,
+		TenantId:   req.NamespacedName.Name})
+	if err != nil {
+		return err
+	}
+	if len(pending.Items) > 0 {
+		log.Info(fmt.Sprintf("Waiting for %d tenant microservices to be deprovisioned.", len(pending.Items)))
+		return nil
+	}
+
 	// Delete config map associated with tenant
 	cmap, err := deleteTenantConfigMap(req.Name, req.Namespace)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+	} else {
+		log.Info(fmt.Sprintf("Deleted tenant config map '%s'.", cmap.ObjectMeta.Name))
+	}
+
+	// Delete secret associated with tenant
+	err = deleteTenantSecret(ctx, r.Client, req.Name, req.Namespace)
+	if err != nil {
+		return err
+	}
+
+	// Delete generated credentials associated with tenant
+	err = r.deleteTenantCredentials(ctx, req.Name, req.Namespace)
+	if err != nil {
+		return err
+	}
+
+	// Delete role used by tenant workloads.
+	err = r.deleteTenantRole(ctx, generateTenantRoleName(req.Namespace, req.Name))
+	if err != nil {
+		return err
+	}
+
+	// Delete tenant network policy.
+	err = r.deleteTenantNetworkPolicy(ctx, generateNetworkPolicyName(req.Namespace, req.Name))
 	if err != nil {
 		return err
 	}
-	log.Info(fmt.Sprintf("Deleted tenant config map '%s'.", cmap.ObjectMeta.Name))
 
 	// Delete tenant ingress.
 	return r.deleteTenantIngress(ctx, req)
@@ -18,18 +18,25 @@ package controllers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	"github.com/devicechain-io/dc-k8s/api/v1beta1"
 )
@@ -41,6 +48,9 @@ const (
 	ENV_MICROSERVICE_ID    = "DC_MICROSERVICE_ID"
 	ENV_MICROSERVICE_NAME  = "DC_MICROSERVICE_NAME"
 	ENV_MS_FUNCTIONAL_AREA = "DC_MS_FUNCTIONAL_AREA"
+
+	// Annotation on deployments with a hash of the pod template last applied by the operator.
+	ANNOTATION_TEMPLATE_HASH = "devicechain.io/template-hash"
 )
 
 // TenantMicroserviceReconciler reconciles a TenantMicroservice object
@@ -52,6 +62,17 @@ type TenantMicroserviceReconciler struct {
 //+kubebuilder:rbac:groups=core.devicechain.io,resources=tenantmicroservices,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core.devicechain.io,resources=tenantmicroservices/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=core.devicechain.io,resources=tenantmicroservices/finalizers,verbs=update
+//+kubebuilder:rbac:groups=keda.sh,resources=scaledobjects,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=rolebindings,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core.devicechain.io,resources=clusters,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core.devicechain.io,resources=tenantplans,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps,resources=controllerrevisions,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=kafka.strimzi.io,resources=kafkatopics,verbs=get;list;watch;create;update;patch;delete
 func (r *TenantMicroserviceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := logf.FromContext(ctx)
 
@@ -69,15 +90,75 @@ func (r *TenantMicroserviceReconciler) Reconcile(ctx context.Context, req ctrl.R
 		return ctrl.Result{}, err
 	}
 
-	// Handle creating or updating a k8s Deployment for the tenant microservice
+	// Deprovision a deleted tenant microservice before releasing it.
+	if !tms.ObjectMeta.DeletionTimestamp.IsZero() {
+		log.Info(fmt.Sprintf("Handling deleting tenant microservice: %+v", req.NamespacedName))
+		if err := r.handleJobRetryRequest(ctx, tms); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, r.finalizeTenantMicroservice(ctx, tms)
+	}
+
+	// Ensure tenant microservice is deprovisioned on delete if required.
 	log.Info(fmt.Sprintf("Handling added/updated tenant microservice: %+v", req.NamespacedName))
+	err = r.reconcileDeprovisioningFinalizer(ctx, tms)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Restore a prior configuration revision if requested.
+	rolledback, err := r.handleRollbackRequest(ctx, tms)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Run failed jobs again if requested.
+	err = r.handleJobRetryRequest(ctx, tms)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Create service account used by tenant microservice pods.
+	err = r.reconcileServiceAccount(ctx, tms)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Create, update or remove Kafka topics of the tenant, polling until they are ready.
+	topicspending, err := r.reconcileKafkaTopics(ctx, tms)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Provision the tenant database, waiting for it before deploying.
+	dsready, err := r.reconcileDatastore(ctx, tms)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !dsready {
+		return ctrl.Result{}, nil
+	}
+
+	// Handle creating or updating a k8s Deployment for the tenant microservice
 	err = r.createOrUpdateDeployment(ctx, tms)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
+	// Create, update or remove KEDA autoscaling for the deployment.
+	err = r.reconcileScaledObject(ctx, tms)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Create, update or remove the pod disruption budget.
+	err = r.reconcilePodDisruptionBudget(ctx, tms)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
 	// Create or update instance ingress based on changes.
-	err = r.updateInstanceIngress(ctx, tms)
+	err = r.updateTenantIngress(ctx, tms)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
@@ -88,6 +169,23 @@ func (r *TenantMicroserviceReconciler) Reconcile(ctx context.Context, req ctrl.R
 		return ctrl.Result{}, err
 	}
 
+	// Record configuration revision for rollback.
+	err = r.reconcileConfigurationRevision(ctx, tms)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Roll pods so restored configuration is loaded.
+	if rolledback {
+		err = restartDeployments(ctx, r.Client, tms.ObjectMeta.Namespace, createDeploymentLabels(tms))
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if topicspending {
+		return ctrl.Result{RequeueAfter: INFRASTRUCTURE_POLL_INTERVAL}, nil
+	}
 	return ctrl.Result{}, nil
 }
 
@@ -95,9 +193,103 @@ func (r *TenantMicroserviceReconciler) Reconcile(ctx context.Context, req ctrl.R
 func (r *TenantMicroserviceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&v1beta1.TenantMicroservice{}).
+		Owns(&batchv1.Job{}).
+		Watches(&source.Kind{Type: &appsv1.Deployment{}},
+			handler.EnqueueRequestsFromMapFunc(r.findTenantMicroservicesForDependency)).
+		Watches(&source.Kind{Type: &v1beta1.Microservice{}},
+			handler.EnqueueRequestsFromMapFunc(r.findTenantMicroservicesForMicroservice)).
+		Watches(&source.Kind{Type: &v1beta1.Instance{}},
+			handler.EnqueueRequestsFromMapFunc(r.findTenantMicroservicesForInstance)).
+		Watches(&source.Kind{Type: &v1beta1.Cluster{}},
+			handler.EnqueueRequestsFromMapFunc(r.findTenantMicroservicesForCluster)).
+		Watches(&source.Kind{Type: &v1beta1.Tenant{}},
+			handler.EnqueueRequestsFromMapFunc(r.findTenantMicroservicesForTenant)).
+		Watches(&source.Kind{Type: &v1beta1.TenantPlan{}},
+			handler.EnqueueRequestsFromMapFunc(r.findTenantMicroservicesForPlan)).
+		Watches(&source.Kind{Type: &corev1.Secret{}},
+			handler.EnqueueRequestsFromMapFunc(r.findTenantMicroservicesForSecret)).
 		Complete(r)
 }
 
+// Create reconcile requests for a list of tenant microservices.
+func createTenantMicroserviceRequests(tmslist *v1beta1.TenantMicroserviceList) []reconcile.Request {
+	requests := make([]reconcile.Request, 0)
+	for _, tms := range tmslist.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: tms.ObjectMeta.Namespace, Name: tms.ObjectMeta.Name},
+		})
+	}
+	return requests
+}
+
+// Find tenant microservices affected by a change to a microservice.
+func (r *TenantMicroserviceReconciler) findTenantMicroservicesForMicroservice(obj client.Object) []reconcile.Request {
+	tmslist := &v1beta1.TenantMicroserviceList{}
+	err := r.List(context.Background(), tmslist, client.InNamespace(obj.GetNamespace()),
+		client.MatchingLabels{v1beta1.LABEL_MICROSERVICE: obj.GetName()})
+	if err != nil {
+		return nil
+	}
+	return createTenantMicroserviceRequests(tmslist)
+}
+
+// Find tenant microservices affected by a change to an instance.
+func (r *TenantMicroserviceReconciler) findTenantMicroservicesForInstance(obj client.Object) []reconcile.Request {
+	tmslist := &v1beta1.TenantMicroserviceList{}
+	err := r.List(context.Background(), tmslist, client.InNamespace(obj.GetName()))
+	if err != nil {
+		return nil
+	}
+	return createTenantMicroserviceRequests(tmslist)
+}
+
+// Find tenant microservices affected by a change to a tenant.
+func (r *TenantMicroserviceReconciler) findTenantMicroservicesForTenant(obj client.Object) []reconcile.Request {
+	tmslist := &v1beta1.TenantMicroserviceList{}
+	err := r.List(context.Background(), tmslist, client.InNamespace(obj.GetNamespace()),
+		client.MatchingLabels{v1beta1.LABEL_TENANT: obj.GetName()})
+	if err != nil {
+		return nil
+	}
+	return createTenantMicroserviceRequests(tmslist)
+}
+
+// Find tenant microservices with configuration referencing a changed secret, so updated
+// values are copied into the tenant secret.
+func (r *TenantMicroserviceReconciler) findTenantMicroservicesForSecret(obj client.Object) []reconcile.Request {
+	secret, ok := obj.(*corev1.Secret)
+	if !ok || isOperatorManagedSecret(secret) {
+		return nil
+	}
+	opts := []client.ListOption{client.InNamespace(secret.ObjectMeta.Namespace)}
+	if tid, found := secret.ObjectMeta.Labels[v1beta1.LABEL_TENANT]; found {
+		opts = append(opts, client.MatchingLabels{v1beta1.LABEL_TENANT: tid})
+	} else if secret.ObjectMeta.Labels[LABEL_SHARED_SECRET] != "true" {
+		return nil
+	}
+	tmslist := &v1beta1.TenantMicroserviceList{}
+	if err := r.List(context.Background(), tmslist, opts...); err != nil {
+		return nil
+	}
+	referencing := &v1beta1.TenantMicroserviceList{}
+	for _, tms := range tmslist.Items {
+		if getReferencedSecretNames(tms.Spec.Configuration.RawMessage)[secret.ObjectMeta.Name] {
+			referencing.Items = append(referencing.Items, tms)
+		}
+	}
+	return createTenantMicroserviceRequests(referencing)
+}
+
+// Find tenant microservices affected by a change to the cluster (all of them).
+func (r *TenantMicroserviceReconciler) findTenantMicroservicesForCluster(obj client.Object) []reconcile.Request {
+	tmslist := &v1beta1.TenantMicroserviceList{}
+	err := r.List(context.Background(), tmslist)
+	if err != nil {
+		return nil
+	}
+	return createTenantMicroserviceRequests(tmslist)
+}
+
 // Get namespaced name for deployment
 func getDeploymentName(tms *v1beta1.TenantMicroservice) types.NamespacedName {
 	return types.NamespacedName{Namespace: tms.ObjectMeta.Namespace, Name: tms.ObjectMeta.Name}
@@ -107,6 +299,48 @@ func getDeploymentName(tms *v1beta1.TenantMicroservice) types.NamespacedName {
 func (r *TenantMicroserviceReconciler) createOrUpdateDeployment(ctx context.Context, tms *v1beta1.TenantMicroservice) error {
 	log := logf.FromContext(ctx)
 
+	// Look up associated tenant.
+	dct, err := v1beta1.GetTenant(v1beta1.TenantGetRequest{
+		InstanceId: tms.ObjectMeta.Namespace,
+		TenantId:   tms.Spec.TenantId,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Look up associated microservice.
+	ms, err := v1beta1.GetMicroservice(v1beta1.MicroserviceGetRequest{
+		InstanceId:     tms.ObjectMeta.Namespace,
+		MicroserviceId: tms.Spec.MicroserviceId,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Look up associated instance.
+	dci, err := v1beta1.GetInstance(v1beta1.InstanceGetRequest{Id: tms.ObjectMeta.Namespace})
+	if err != nil {
+		return err
+	}
+
+	// Look up plan which limits tenant resources.
+	plan, err := getAppliedTenantPlan(ctx, r.Client, dct)
+	if err != nil {
+		return err
+	}
+
+	// Enforce image policy and pin image digest if required.
+	allowed, err := r.reconcileImage(ctx, tms, ms, dci)
+	if err != nil || !allowed {
+		return err
+	}
+
+	// Pods rejected by pod security admission are not rolled out (reported on the microservice).
+	if violation := getPodSecurityViolation(dci, ms); violation != "" {
+		log.Info(fmt.Sprintf("Not rolling out tenant microservice '%s': %s", tms.ObjectMeta.Name, violation))
+		return nil
+	}
+
 	// Attempt to look up existing deployment.
 	dname := getDeploymentName(tms)
 	deploy := &appsv1.Deployment{}
@@ -114,26 +348,20 @@ func (r *TenantMicroserviceReconciler) createOrUpdateDeployment(ctx context.Cont
 		if errors.IsNotFound(err) {
 			log.Info(fmt.Sprintf("Existing deployment not found for tenant microservice: %+v", dname))
 
-			// Look up associated microservice.
-			dct, err := v1beta1.GetTenant(v1beta1.TenantGetRequest{
-				InstanceId: tms.ObjectMeta.Namespace,
-				TenantId:   tms.Spec.TenantId,
-			})
-			if err != nil {
+			// Wait for dependencies to be ready before the deployment is first created.
+			ready, err := r.reconcileDependencies(ctx, tms, ms)
+			if err != nil || !ready {
 				return err
 			}
 
-			// Look up associated microservice.
-			ms, err := v1beta1.GetMicroservice(v1beta1.MicroserviceGetRequest{
-				InstanceId:     tms.ObjectMeta.Namespace,
-				MicroserviceId: tms.Spec.MicroserviceId,
-			})
-			if err != nil {
+			// Provision tenant before the deployment is first created.
+			provisioned, err := r.reconcileProvisioningJob(ctx, tms, dct, ms, dci)
+			if err != nil || !provisioned {
 				return err
 			}
 
 			// Create a new deployment.
-			_, err = r.createDeploymentAndService(ctx, tms, dct, ms)
+			_, err = r.createDeploymentAndService(ctx, tms, dct, ms, dci, plan)
 			return err
 		} else {
 			return err
@@ -142,7 +370,59 @@ func (r *TenantMicroserviceReconciler) createOrUpdateDeployment(ctx context.Cont
 
 	log.Info(fmt.Sprintf("Existing deployment found for tenant microservice: %+v", dname))
 
-	return nil
+	// Update pod template with latest settings. Replicas are left to the autoscaler if enabled
+	// and held at zero while the tenant is suspended or the instance is scaled down for maintenance.
+	updated, err := generateDeployment(tms, dct, ms, dci, plan)
+	if err != nil {
+		return err
+	}
+
+	// Migrate tenant data before rolling out a new image. Other changes are applied while the
+	// deployment stays on its current image.
+	current := deploy.Spec.Template.Spec.Containers[0].Image
+	migrated, err := r.reconcileMigrationJob(ctx, tms, dct, ms, dci, current, updated.Spec.Template.Spec.Containers[0].Image)
+	if err != nil {
+		return err
+	}
+	if !migrated {
+		updated.Spec.Template.Spec.Containers[0].Image = current
+	}
+	if tms.Spec.Autoscaling == nil {
+		err = r.limitDeploymentReplicas(ctx, tms, dct, plan, updated, getDeploymentReplicas(deploy))
+		if err != nil {
+			return err
+		}
+	}
+	preserveRestartAnnotation(deploy, updated)
+	hash, err := getPodTemplateHash(updated)
+	if err != nil {
+		return err
+	}
+	replicas := deploy.Spec.Replicas
+	if isTenantScaledDown(dct, dci) || (tms.Spec.Autoscaling == nil && updated.Spec.Replicas != nil) {
+		replicas = updated.Spec.Replicas
+	}
+
+	// The hash detects fields removed from the template, which are not seen when comparing
+	// against the live template with server defaults filled in.
+	if deploy.ObjectMeta.Annotations[ANNOTATION_TEMPLATE_HASH] == hash &&
+		equality.Semantic.DeepDerivative(updated.Spec.Template, deploy.Spec.Template) &&
+		equality.Semantic.DeepEqual(replicas, deploy.Spec.Replicas) {
+		return nil
+	}
+	metav1.SetMetaDataAnnotation(&deploy.ObjectMeta, ANNOTATION_TEMPLATE_HASH, hash)
+	deploy.Spec.Template = updated.Spec.Template
+	deploy.Spec.Replicas = replicas
+	return r.Update(ctx, deploy)
+}
+
+// Get hash of a deployment pod template as generated by the operator.
+func getPodTemplateHash(deploy *appsv1.Deployment) (string, error) {
+	data, err := json.Marshal(deploy.Spec.Template)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data))[:16], nil
 }
 
 // Create labels to target deployment
@@ -153,20 +433,116 @@ func createDeploymentLabels(tms *v1beta1.TenantMicroservice) map[string]string {
 	}
 }
 
-// Create a deployment based on tenant microservice details
-func (r *TenantMicroserviceReconciler) createDeploymentAndService(ctx context.Context, tms *v1beta1.TenantMicroservice,
-	dct *v1beta1.Tenant, ms *v1beta1.Microservice) (*appsv1.Deployment, error) {
-	log := logf.FromContext(ctx)
+// Get environment variables identifying the instance, tenant and microservice. The tenant
+// may be nil if it has already been deleted.
+func getTenantEnvVars(tms *v1beta1.TenantMicroservice, dct *v1beta1.Tenant, ms *v1beta1.Microservice) []corev1.EnvVar {
+	tname := ""
+	if dct != nil {
+		tname = dct.Spec.Name
+	}
+	return []corev1.EnvVar{
+		{
+			Name:  ENV_INSTANCE_ID,
+			Value: tms.ObjectMeta.Namespace,
+		},
+		{
+			Name:  ENV_TENANT_ID,
+			Value: tms.Spec.TenantId,
+		},
+		{
+			Name:  ENV_TENANT_NAME,
+			Value: tname,
+		},
+		{
+			Name:  ENV_MICROSERVICE_ID,
+			Value: ms.ObjectMeta.Name,
+		},
+		{
+			Name:  ENV_MICROSERVICE_NAME,
+			Value: ms.Spec.Name,
+		},
+		{
+			Name:  ENV_MS_FUNCTIONAL_AREA,
+			Value: ms.Spec.FunctionalArea,
+		},
+	}
+}
 
-	dci, err := v1beta1.GetInstance(v1beta1.InstanceGetRequest{Id: tms.ObjectMeta.Namespace})
-	if err != nil {
-		return nil, err
+// Get mounts of the instance and tenant configuration volumes.
+func getTenantVolumeMounts() []corev1.VolumeMount {
+	return []corev1.VolumeMount{
+		{
+			Name:      "instance-config",
+			MountPath: "/etc/dci-config",
+		}, {
+			Name:      "tenant-config",
+			MountPath: "/etc/dct-config",
+		}, {
+			Name:      "tenant-secrets",
+			MountPath: TENANT_SECRETS_MOUNT_PATH,
+			ReadOnly:  true,
+		}, {
+			Name:      "tenant-credentials",
+			MountPath: TENANT_CREDENTIALS_MOUNT_PATH,
+			ReadOnly:  true,
+		},
 	}
+}
 
+// Get volumes holding instance and tenant configuration.
+func getTenantVolumes(tms *v1beta1.TenantMicroservice, dci *v1beta1.Instance) []corev1.Volume {
+	optional := true
+	return []corev1.Volume{
+		{
+			Name: "instance-config",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: getInstanceConfigMapName(dci.ObjectMeta.Name),
+					},
+				},
+			},
+		},
+		{
+			Name: "tenant-config",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: getTenantConfigMapName(tms.Spec.TenantId),
+					},
+				},
+			},
+		},
+		{
+			Name: "tenant-secrets",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: getTenantSecretName(tms.Spec.TenantId),
+				},
+			},
+		},
+		{
+			Name: "tenant-credentials",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: getTenantCredentialsSecretName(tms.Spec.TenantId),
+					Optional:   &optional,
+				},
+			},
+		},
+	}
+}
+
+// Generate a deployment based on tenant microservice details
+func generateDeployment(tms *v1beta1.TenantMicroservice, dct *v1beta1.Tenant, ms *v1beta1.Microservice,
+	dci *v1beta1.Instance, plan *v1beta1.TenantPlan) (*appsv1.Deployment, error) {
 	dname := getDeploymentName(tms)
 	labels := createDeploymentLabels(tms)
+	replicas := getPlanReplicas(plan, tms.Spec.Replicas)
+	if isTenantScaledDown(dct, dci) {
+		replicas = new(int32)
+	}
 
-	// Create deployment.
 	deploy := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      dname.Name,
@@ -174,6 +550,7 @@ func (r *TenantMicroserviceReconciler) createDeploymentAndService(ctx context.Co
 			Labels:    labels,
 		},
 		Spec: appsv1.DeploymentSpec{
+			Replicas: replicas,
 			Selector: &metav1.LabelSelector{
 				MatchLabels: labels,
 			},
@@ -182,74 +559,62 @@ func (r *TenantMicroserviceReconciler) createDeploymentAndService(ctx context.Co
 					Labels: labels,
 				},
 				Spec: corev1.PodSpec{
+					ServiceAccountName: getServiceAccountName(tms).Name,
+					ImagePullSecrets:   getImagePullSecrets(dci, ms),
 					Containers: []corev1.Container{
 						{
 							Name:            tms.Spec.MicroserviceId,
-							Image:           ms.Spec.Image,
+							Image:           getContainerImage(tms, ms),
 							ImagePullPolicy: ms.Spec.ImagePullPolicy,
-							Env: []corev1.EnvVar{
-								{
-									Name:  ENV_INSTANCE_ID,
-									Value: dct.ObjectMeta.Namespace,
-								},
-								{
-									Name:  ENV_TENANT_ID,
-									Value: dct.ObjectMeta.Name,
-								},
-								{
-									Name:  ENV_TENANT_NAME,
-									Value: dct.Spec.Name,
-								},
-								{
-									Name:  ENV_MICROSERVICE_ID,
-									Value: ms.ObjectMeta.Name,
-								},
-								{
-									Name:  ENV_MICROSERVICE_NAME,
-									Value: ms.Spec.Name,
-								},
-								{
-									Name:  ENV_MS_FUNCTIONAL_AREA,
-									Value: ms.Spec.FunctionalArea,
-								},
-							},
-							VolumeMounts: []corev1.VolumeMount{
-								{
-									Name:      "instance-config",
-									MountPath: "/etc/dci-config",
-								}, {
-									Name:      "tenant-config",
-									MountPath: "/etc/dct-config",
-								},
-							},
-						},
-					},
-					Volumes: []corev1.Volume{
-						{
-							Name: "instance-config",
-							VolumeSource: corev1.VolumeSource{
-								ConfigMap: &corev1.ConfigMapVolumeSource{
-									LocalObjectReference: corev1.LocalObjectReference{
-										Name: getInstanceConfigMapName(dci.ObjectMeta.Name),
-									},
-								},
-							},
-						},
-						{
-							Name: "tenant-config",
-							VolumeSource: corev1.VolumeSource{
-								ConfigMap: &corev1.ConfigMapVolumeSource{
-									LocalObjectReference: corev1.LocalObjectReference{
-										Name: getTenantConfigMapName(tms.Spec.TenantId),
-									},
-								},
-							},
+							Env:             getTenantEnvVars(tms, dct, ms),
+							VolumeMounts:    getTenantVolumeMounts(),
 						},
 					},
+					Volumes: getTenantVolumes(tms, dci),
 				},
 			},
 		},
 	}
+
+	// Apply scheduling constraints.
+	applyScheduling(&deploy.Spec.Template.Spec, labels, dci, ms, tms)
+
+	// Apply hardened security settings.
+	applySecurityContext(&deploy.Spec.Template.Spec, ms)
+
+	// Apply resources and quotas of the tenant plan.
+	if err := applyTenantPlan(&deploy.Spec.Template.Spec.Containers[0], plan, ms); err != nil {
+		return nil, err
+	}
+
+	return deploy, nil
+}
+
+// Create a deployment based on tenant microservice details
+func (r *TenantMicroserviceReconciler) createDeploymentAndService(ctx context.Context, tms *v1beta1.TenantMicroservice,
+	dct *v1beta1.Tenant, ms *v1beta1.Microservice, dci *v1beta1.Instance,
+	plan *v1beta1.TenantPlan) (*appsv1.Deployment, error) {
+	log := logf.FromContext(ctx)
+
+	dname := getDeploymentName(tms)
+	labels := createDeploymentLabels(tms)
+
+	// Create deployment.
+	deploy, err := generateDeployment(tms, dct, ms, dci, plan)
+	if err != nil {
+		return nil, err
+	}
+	if tms.Spec.Autoscaling == nil {
+		err = r.limitDeploymentReplicas(ctx, tms, dct, plan, deploy, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+	hash, err := getPodTemplateHash(deploy)
+	if err != nil {
+		return nil, err
+	}
+	metav1.SetMetaDataAnnotation(&deploy.ObjectMeta, ANNOTATION_TEMPLATE_HASH, hash)
 	err = r.Create(context.Background(), deploy)
 	if err != nil {
 		return nil, err
@@ -284,36 +649,55 @@ func (r *TenantMicroserviceReconciler) createDeploymentAndService(ctx context.Co
 
 // Handle a deleted tenant microservice
 func (r *TenantMicroserviceReconciler) handleTenantMicroserviceDeleted(ctx context.Context, req ctrl.Request) error {
+	if err := r.deleteTenantMicroserviceWorkloads(ctx, req.NamespacedName); err != nil {
+		return err
+	}
+
+	// Remove the service account and its role binding.
+	return r.deleteServiceAccount(ctx, req.NamespacedName)
+}
+
+// Delete the deployment of a tenant microservice along with its service, autoscaling and
+// disruption budget.
+func (r *TenantMicroserviceReconciler) deleteTenantMicroserviceWorkloads(ctx context.Context,
+	name types.NamespacedName) error {
 	log := logf.FromContext(ctx)
 
 	deploy := &appsv1.Deployment{}
-	if err := r.Get(ctx, req.NamespacedName, deploy); err != nil {
+	if err := r.Get(ctx, name, deploy); err != nil {
 		if !errors.IsNotFound(err) {
-			log.Info(fmt.Sprintf("Unable to find deployment for tenant microservice: %+v", req.NamespacedName))
+			log.Info(fmt.Sprintf("Unable to find deployment for tenant microservice: %+v", name))
 			return err
 		}
 	} else {
 		if err := r.Delete(ctx, deploy); err != nil {
-			log.Info(fmt.Sprintf("Unable to delete deployment for tenant microservice: %+v", req.NamespacedName))
+			log.Info(fmt.Sprintf("Unable to delete deployment for tenant microservice: %+v", name))
 			return err
 		}
-		log.Info(fmt.Sprintf("Deleted deployment for tenant microservice: %+v", req.NamespacedName))
+		log.Info(fmt.Sprintf("Deleted deployment for tenant microservice: %+v", name))
 	}
 
 	service := &corev1.Service{}
-	if err := r.Get(ctx, req.NamespacedName, service); err != nil {
+	if err := r.Get(ctx, name, service); err != nil {
 		if !errors.IsNotFound(err) {
-			log.Info(fmt.Sprintf("Unable to find service for tenant microservice: %+v", req.NamespacedName))
+			log.Info(fmt.Sprintf("Unable to find service for tenant microservice: %+v", name))
 			return err
 		}
 	} else {
 		if err := r.Delete(ctx, service); err != nil {
-			log.Info(fmt.Sprintf("Unable to delete service for tenant microservice: %+v", req.NamespacedName))
+			log.Info(fmt.Sprintf("Unable to delete service for tenant microservice: %+v", name))
 			return err
 		}
-		log.Info(fmt.Sprintf("Deleted service for tenant microservice: %+v", req.NamespacedName))
+		log.Info(fmt.Sprintf("Deleted service for tenant microservice: %+v", name))
 	}
-	return nil
+
+	// Remove autoscaling for the deleted deployment.
+	if err := r.deleteScaledObject(ctx, name); err != nil {
+		return err
+	}
+
+	// Remove the pod disruption budget.
+	return r.deletePodDisruptionBudget(ctx, name)
 }
 
 // Update tenant configuration map with entry for tenant microservice
@@ -339,8 +723,44 @@ func (r *TenantMicroserviceReconciler) updateTenantConfigMap(ctx context.Context
 		tcmap.Data = make(map[string]string, 0)
 	}
 
+	// Move referenced secret values into the tenant secret.
+	config, values, err := resolveSecretReferences(ctx, r.Client, tms.ObjectMeta.Namespace,
+		tms.Spec.TenantId, ms.Spec.FunctionalArea, tms.Spec.Configuration.RawMessage)
+	if err != nil {
+		return err
+	}
+
+	// Add connection details of the tenant datastore.
+	dci, err := v1beta1.GetInstance(v1beta1.InstanceGetRequest{Id: tms.ObjectMeta.Namespace})
+	if err != nil {
+		return err
+	}
+	datastore, err := generateDatastoreConfiguration(tms, ms, dci)
+	if err != nil {
+		return err
+	}
+	if datastore != nil {
+		dsconfig, dsvalues, err := resolveSecretReferences(ctx, r.Client, tms.ObjectMeta.Namespace,
+			tms.Spec.TenantId, ms.Spec.FunctionalArea, datastore)
+		if err != nil {
+			return err
+		}
+		for key, value := range dsvalues {
+			values[key] = value
+		}
+		tcmap.Data[getDatastoreConfigKey(ms)] = string(dsconfig)
+	} else {
+		delete(tcmap.Data, getDatastoreConfigKey(ms))
+	}
+
+	err = updateTenantSecretEntries(ctx, r.Client, tms.Spec.TenantId, tms.ObjectMeta.Namespace,
+		ms.Spec.FunctionalArea, values)
+	if err != nil {
+		return err
+	}
+
 	// Update map index for functional area
-	tcmap.Data[ms.Spec.FunctionalArea] = string(tms.Spec.Configuration.RawMessage)
+	tcmap.Data[ms.Spec.FunctionalArea] = string(config)
 	return r.Update(ctx, tcmap)
 }
 
@@ -378,11 +798,12 @@ func generateIngressPath(tms *v1beta1.TenantMicroservice) (*netv1.HTTPIngressPat
 	return igpath, nil
 }
 
-// Generate ingress paths for all tenant microservices in instance namespace.
-func (r *TenantMicroserviceReconciler) generateIngressPaths(tms *v1beta1.TenantMicroservice) ([]netv1.HTTPIngressPath, error) {
+// Generate ingress paths for all tenant microservices of a tenant. If a backend is given,
+// all paths are routed to it instead of the tenant microservices.
+func generateIngressPaths(ctx context.Context, c client.Client, ns string, tid string,
+	backend *netv1.IngressServiceBackend) ([]netv1.HTTPIngressPath, error) {
 	tmslist := &v1beta1.TenantMicroserviceList{}
-	err := r.List(context.Background(), tmslist, client.InNamespace(tms.ObjectMeta.Namespace),
-		client.MatchingLabels{v1beta1.LABEL_TENANT: tms.Spec.TenantId})
+	err := c.List(ctx, tmslist, client.InNamespace(ns), client.MatchingLabels{v1beta1.LABEL_TENANT: tid})
 	if err != nil {
 		return nil, err
 	}
@@ -393,19 +814,34 @@ func (r *TenantMicroserviceReconciler) generateIngressPaths(tms *v1beta1.TenantM
 		if err != nil {
 			return nil, err
 		}
+		if backend != nil {
+			ipath.Backend.Service = backend.DeepCopy()
+		}
 		ipaths = append(ipaths, *ipath)
 	}
 	return ipaths, nil
 }
 
-// Generate ingress resource.
-func (r *TenantMicroserviceReconciler) generateIngress(tms *v1beta1.TenantMicroservice,
-	igname types.NamespacedName) (*netv1.Ingress, error) {
-	ipaths, err := r.generateIngressPaths(tms)
-	if err != nil {
-		return nil, err
+// Get backend serving requests for a tenant which is unavailable (nil if available).
+func getUnavailableTenantBackend(tenant *v1beta1.Tenant, dci *v1beta1.Instance) *netv1.IngressServiceBackend {
+	ns := dci.ObjectMeta.Name
+	if isInstanceInMaintenance(dci) {
+		return &netv1.IngressServiceBackend{
+			Name: getMaintenanceName(ns),
+			Port: netv1.ServiceBackendPort{Number: INSTANCE_MAINTENANCE_PORT},
+		}
+	}
+	if isTenantSuspended(tenant) {
+		return &netv1.IngressServiceBackend{
+			Name: getMaintenanceName(ns),
+			Port: netv1.ServiceBackendPort{Number: MAINTENANCE_PORT},
+		}
 	}
+	return nil
+}
 
+// Generate ingress resource.
+func generateIngress(igname types.NamespacedName, ipaths []netv1.HTTPIngressPath) *netv1.Ingress {
 	return &netv1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      igname.Name,
@@ -426,31 +862,46 @@ func (r *TenantMicroserviceReconciler) generateIngress(tms *v1beta1.TenantMicros
 				},
 			},
 		},
-	}, nil
+	}
 }
 
-// Update ingress configuration based on tenant microservice changes.
-func (r *TenantMicroserviceReconciler) updateInstanceIngress(ctx context.Context, tms *v1beta1.TenantMicroservice) error {
-	igname := generateIngressName(tms.ObjectMeta.Namespace, tms.Spec.TenantId)
-	updated, err := r.generateIngress(tms, igname)
+// Update the ingress for the tenant of a tenant microservice.
+func (r *TenantMicroserviceReconciler) updateTenantIngress(ctx context.Context, tms *v1beta1.TenantMicroservice) error {
+	dct, err := v1beta1.GetTenant(v1beta1.TenantGetRequest{
+		InstanceId: tms.ObjectMeta.Namespace,
+		TenantId:   tms.Spec.TenantId,
+	})
+	if err != nil {
+		return err
+	}
+	dci, err := v1beta1.GetInstance(v1beta1.InstanceGetRequest{Id: tms.ObjectMeta.Namespace})
 	if err != nil {
 		return err
 	}
+	return reconcileTenantIngress(ctx, r.Client, dct, dci)
+}
+
+// Create or update the ingress for a tenant based on its tenant microservices. Requests
+// are routed to the maintenance backend while the tenant is unavailable.
+func reconcileTenantIngress(ctx context.Context, c client.Client, tenant *v1beta1.Tenant,
+	dci *v1beta1.Instance) error {
+	ns := tenant.ObjectMeta.Namespace
+	tid := tenant.ObjectMeta.Name
+	ipaths, err := generateIngressPaths(ctx, c, ns, tid, getUnavailableTenantBackend(tenant, dci))
+	if err != nil || len(ipaths) == 0 {
+		return err
+	}
 
+	igname := generateIngressName(ns, tid)
+	updated := generateIngress(igname, ipaths)
 	ingress := &netv1.Ingress{}
-	if err = r.Get(ctx, igname, ingress); err != nil {
+	if err = c.Get(ctx, igname, ingress); err != nil {
 		if errors.IsNotFound(err) {
-			if err = r.Create(context.Background(), updated); err != nil {
-				return err
-			}
-		} else {
-			return err
-		}
-	} else {
-		if err = r.Update(context.Background(), updated); err != nil {
-			return err
+			return c.Create(ctx, updated)
 		}
+		return err
 	}
-
-	return nil
+	ingress.ObjectMeta.Annotations = updated.ObjectMeta.Annotations
+	ingress.Spec = updated.Spec
+	return c.Update(ctx, ingress)
 }
@@ -0,0 +1,59 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+// Get the maximum number of tenants allowed in an instance (nil if unlimited).
+func getInstanceMaxTenants(dci *v1beta1.Instance) *int32 {
+	if dci.Spec.TenantPolicy == nil {
+		return nil
+	}
+	return dci.Spec.TenantPolicy.MaxTenants
+}
+
+// Update instance status with the current and maximum number of tenants.
+func (r *InstanceReconciler) reconcileInstanceTenantCount(ctx context.Context, dci *v1beta1.Instance) error {
+	tenants := &v1beta1.TenantList{}
+	if err := r.List(ctx, tenants, client.InNamespace(dci.ObjectMeta.Name)); err != nil {
+		return err
+	}
+	count := int32(len(tenants.Items))
+	max := getInstanceMaxTenants(dci)
+	if dci.Status.Tenants == count && reflect.DeepEqual(dci.Status.MaxTenants, max) {
+		return nil
+	}
+	dci.Status.Tenants = count
+	dci.Status.MaxTenants = max
+	return r.Status().Update(ctx, dci)
+}
+
+// Find the instance a namespaced resource belongs to.
+func (r *InstanceReconciler) findInstanceForObject(obj client.Object) []reconcile.Request {
+	return []reconcile.Request{
+		{NamespacedName: types.NamespacedName{Name: obj.GetNamespace()}},
+	}
+}
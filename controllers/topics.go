@@ -0,0 +1,281 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/devicechain-io/dc-k8s/api/v1beta1"
+)
+
+const (
+	// Placeholder in topic names replaced with the tenant id.
+	TOPIC_TENANT_PLACEHOLDER = "{tenant}"
+
+	// Label associating a Strimzi resource with its Kafka cluster.
+	LABEL_STRIMZI_CLUSTER = "strimzi.io/cluster"
+)
+
+var kafkaTopicGVK = schema.GroupVersionKind{
+	Group:   "kafka.strimzi.io",
+	Version: "v1beta2",
+	Kind:    "KafkaTopic",
+}
+
+// Create an empty Strimzi KafkaTopic.
+func newKafkaTopic() *unstructured.Unstructured {
+	topic := &unstructured.Unstructured{}
+	topic.SetGroupVersionKind(kafkaTopicGVK)
+	return topic
+}
+
+// Get name of a tenant topic generated from a topic template.
+func getTopicName(template v1beta1.TopicTemplateSpec, tenantid string) string {
+	return strings.ReplaceAll(template.Name, TOPIC_TENANT_PLACEHOLDER, tenantid)
+}
+
+// Get resource name of the KafkaTopic for a tenant topic. Topic names may contain characters
+// which are not valid in resource names, so a hash of the topic name is used.
+func getKafkaTopicResourceName(tms *v1beta1.TenantMicroservice, topic string) string {
+	hash := sha256.Sum256([]byte(topic))
+	return fmt.Sprintf("%s-%x", tms.ObjectMeta.Name, hash[:4])
+}
+
+// Get whether a tenant topic is deleted or retained with its tenant microservice.
+func getTopicDeletionPolicy(template v1beta1.TopicTemplateSpec) v1beta1.DeletionPolicy {
+	if template.DeletionPolicy == "" {
+		return v1beta1.DeletionPolicyDelete
+	}
+	return template.DeletionPolicy
+}
+
+// Generate the spec of a Strimzi KafkaTopic from a topic template.
+func generateKafkaTopicSpec(template v1beta1.TopicTemplateSpec, topic string) map[string]interface{} {
+	partitions := int32(1)
+	if template.Partitions != nil {
+		partitions = *template.Partitions
+	}
+	config := make(map[string]interface{})
+	for key, value := range template.Config {
+		config[key] = value
+	}
+	if template.Retention != nil {
+		config["retention.ms"] = template.Retention.Milliseconds()
+	}
+
+	spec := map[string]interface{}{
+		"topicName":  topic,
+		"partitions": int64(partitions),
+	}
+	if template.Replicas != nil {
+		spec["replicas"] = int64(*template.Replicas)
+	}
+	if len(config) > 0 {
+		spec["config"] = config
+	}
+	return spec
+}
+
+// Get readiness of a Strimzi KafkaTopic and the reason it is not ready.
+func getKafkaTopicStatus(topic *unstructured.Unstructured) (bool, string) {
+	conditions, _, _ := unstructured.NestedSlice(topic.Object, "status", "conditions")
+	for _, condition := range conditions {
+		values, ok := condition.(map[string]interface{})
+		if !ok || values["type"] != "Ready" {
+			continue
+		}
+		if values["status"] == "True" {
+			return true, ""
+		}
+		message, _ := values["message"].(string)
+		return false, message
+	}
+	return false, "waiting for topic operator"
+}
+
+// Set topic statuses for all templates to the same reason they can not be provisioned.
+func getUnavailableTopicStatuses(tms *v1beta1.TenantMicroservice, templates []v1beta1.TopicTemplateSpec,
+	message string) []v1beta1.TopicStatus {
+	statuses := make([]v1beta1.TopicStatus, 0)
+	for _, template := range templates {
+		statuses = append(statuses, v1beta1.TopicStatus{
+			Name:    getTopicName(template, tms.Spec.TenantId),
+			Message: message,
+		})
+	}
+	return statuses
+}
+
+// Create or update the KafkaTopic for a topic template. Topics which are deleted with the
+// tenant microservice are owned by it so they are garbage collected.
+func (r *TenantMicroserviceReconciler) reconcileKafkaTopic(ctx context.Context, tms *v1beta1.TenantMicroservice,
+	cluster string, template v1beta1.TopicTemplateSpec) (*v1beta1.TopicStatus, string, error) {
+	log := logf.FromContext(ctx)
+
+	name := getTopicName(template, tms.Spec.TenantId)
+	rname := getKafkaTopicResourceName(tms, name)
+	labels := createDeploymentLabels(tms)
+	labels[LABEL_STRIMZI_CLUSTER] = cluster
+
+	topic := newKafkaTopic()
+	found := true
+	if err := r.Get(ctx, client.ObjectKey{Namespace: tms.ObjectMeta.Namespace, Name: rname}, topic); err != nil {
+		if !errors.IsNotFound(err) {
+			return nil, "", err
+		}
+		found = false
+		topic = newKafkaTopic()
+		topic.SetName(rname)
+		topic.SetNamespace(tms.ObjectMeta.Namespace)
+	}
+
+	existing := topic.DeepCopy()
+	topic.SetLabels(labels)
+	topic.SetOwnerReferences(nil)
+	if getTopicDeletionPolicy(template) == v1beta1.DeletionPolicyDelete {
+		if err := controllerutil.SetControllerReference(tms, topic, r.Scheme); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := unstructured.SetNestedMap(topic.Object, generateKafkaTopicSpec(template, name), "spec"); err != nil {
+		return nil, "", err
+	}
+
+	if !found {
+		if err := r.Create(ctx, topic); err != nil {
+			return nil, "", err
+		}
+		log.Info(fmt.Sprintf("Created Kafka topic '%s' for tenant microservice '%s'", name, tms.ObjectMeta.Name))
+	} else if !equality.Semantic.DeepEqual(existing.Object, topic.Object) {
+		if err := r.Update(ctx, topic); err != nil {
+			return nil, "", err
+		}
+	}
+
+	ready, message := getKafkaTopicStatus(topic)
+	return &v1beta1.TopicStatus{Name: name, Ready: ready, Message: message}, rname, nil
+}
+
+// Delete or release KafkaTopics which are no longer declared by the microservice. Retained
+// topics are released by removing the labels associating them with the tenant microservice.
+func (r *TenantMicroserviceReconciler) pruneKafkaTopics(ctx context.Context, tms *v1beta1.TenantMicroservice,
+	current map[string]bool) error {
+	log := logf.FromContext(ctx)
+
+	topics := &unstructured.UnstructuredList{}
+	topics.SetGroupVersionKind(kafkaTopicGVK.GroupVersion().WithKind(kafkaTopicGVK.Kind + "List"))
+	err := r.List(ctx, topics, client.InNamespace(tms.ObjectMeta.Namespace),
+		client.MatchingLabels(createDeploymentLabels(tms)))
+	if err != nil {
+		return err
+	}
+	for i := range topics.Items {
+		topic := &topics.Items[i]
+		if current[topic.GetName()] {
+			continue
+		}
+		if metav1.IsControlledBy(topic, tms) {
+			if err := r.Delete(ctx, topic); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+			log.Info(fmt.Sprintf("Deleted Kafka topic resource '%s' for tenant microservice '%s'",
+				topic.GetName(), tms.ObjectMeta.Name))
+			continue
+		}
+		labels := topic.GetLabels()
+		delete(labels, v1beta1.LABEL_TENANT)
+		delete(labels, v1beta1.LABEL_MICROSERVICE)
+		topic.SetLabels(labels)
+		if err := r.Update(ctx, topic); err != nil {
+			return err
+		}
+		log.Info(fmt.Sprintf("Retained Kafka topic resource '%s' for tenant microservice '%s'",
+			topic.GetName(), tms.ObjectMeta.Name))
+	}
+	return nil
+}
+
+// Create, update or remove Kafka topics declared by the microservice for the tenant. Topics
+// require the instance Kafka cluster to be provided by Strimzi. Returns true while created
+// topics are not yet ready. Topics which can not be created are only reported in status,
+// since instance changes trigger reconciliation.
+func (r *TenantMicroserviceReconciler) reconcileKafkaTopics(ctx context.Context, tms *v1beta1.TenantMicroservice) (bool, error) {
+	ms, err := v1beta1.GetMicroservice(v1beta1.MicroserviceGetRequest{
+		InstanceId:     tms.ObjectMeta.Namespace,
+		MicroserviceId: tms.Spec.MicroserviceId,
+	})
+	if err != nil {
+		return false, err
+	}
+	dci, err := v1beta1.GetInstance(v1beta1.InstanceGetRequest{Id: tms.ObjectMeta.Namespace})
+	if err != nil {
+		return false, err
+	}
+
+	statuses := make([]v1beta1.TopicStatus, 0)
+	current := make(map[string]bool)
+	pending := false
+	kafka := getInfrastructureComponents(dci)[INFRASTRUCTURE_KAFKA]
+	if len(ms.Spec.Topics) > 0 && (kafka == nil || getInfrastructureProvider(kafka) != v1beta1.InfrastructureOperator) {
+		statuses = getUnavailableTopicStatuses(tms, ms.Spec.Topics, "instance Kafka is not provided by the Strimzi operator")
+	} else {
+		cluster := getInfrastructureName(dci.ObjectMeta.Name, INFRASTRUCTURE_KAFKA)
+		for _, template := range ms.Spec.Topics {
+			status, rname, err := r.reconcileKafkaTopic(ctx, tms, cluster, template)
+			if err != nil {
+				if meta.IsNoMatchError(err) {
+					statuses = getUnavailableTopicStatuses(tms, ms.Spec.Topics, "Strimzi is not installed")
+					pending = false
+					break
+				}
+				return false, err
+			}
+			statuses = append(statuses, *status)
+			current[rname] = true
+			pending = pending || !status.Ready
+		}
+	}
+
+	// Clusters without Strimzi can not have topics left to prune.
+	if err := r.pruneKafkaTopics(ctx, tms, current); err != nil && !meta.IsNoMatchError(err) {
+		return false, err
+	}
+
+	if len(statuses) == 0 {
+		statuses = nil
+	}
+	if !equality.Semantic.DeepEqual(statuses, tms.Status.Topics) {
+		tms.Status.Topics = statuses
+		if err := r.Status().Update(ctx, tms); err != nil {
+			return false, err
+		}
+	}
+	return pending, nil
+}
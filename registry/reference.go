@@ -0,0 +1,163 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registry
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	DEFAULT_REGISTRY = "docker.io"
+	DEFAULT_TAG      = "latest"
+
+	// Host serving the registry API for Docker Hub.
+	DOCKER_HUB_API_HOST = "registry-1.docker.io"
+)
+
+// Reference to an image in an OCI registry
+type Reference struct {
+	// Image name as originally specified (without tag or digest).
+	Name string
+
+	// Registry host (including port if specified).
+	Registry string
+
+	// Repository path within the registry.
+	Repository string
+
+	// Tag (if specified or defaulted).
+	Tag string
+
+	// Digest (if specified).
+	Digest string
+}
+
+// Parse an image reference such as 'registry:5000/org/image:tag' or 'image@sha256:...'.
+func ParseReference(image string) (*Reference, error) {
+	if image == "" || strings.ContainsAny(image, " \t\n") {
+		return nil, fmt.Errorf("invalid image reference: '%s'", image)
+	}
+
+	ref := &Reference{}
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		ref.Digest = name[i+1:]
+		name = name[:i]
+		if !strings.Contains(ref.Digest, ":") {
+			return nil, fmt.Errorf("invalid digest in image reference: '%s'", image)
+		}
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		ref.Tag = name[i+1:]
+		name = name[:i]
+	}
+	if name == "" {
+		return nil, fmt.Errorf("invalid image reference: '%s'", image)
+	}
+	ref.Name = name
+
+	// First component is a registry host if it looks like one.
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		ref.Registry = parts[0]
+		ref.Repository = parts[1]
+	} else {
+		ref.Registry = DEFAULT_REGISTRY
+		ref.Repository = name
+	}
+	if ref.Registry == DEFAULT_REGISTRY && !strings.Contains(ref.Repository, "/") {
+		ref.Repository = path.Join("library", ref.Repository)
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = DEFAULT_TAG
+	}
+	return ref, nil
+}
+
+// Get the reference in its original form (with tag or digest).
+func (ref *Reference) String() string {
+	if ref.Digest != "" {
+		return ref.WithDigest(ref.Digest)
+	}
+	return fmt.Sprintf("%s:%s", ref.Name, ref.Tag)
+}
+
+// Get the fully-qualified repository (registry host and repository path).
+func (ref *Reference) FullRepository() string {
+	return path.Join(ref.Registry, ref.Repository)
+}
+
+// Get the reference pinned to the given digest.
+func (ref *Reference) WithDigest(digest string) string {
+	return fmt.Sprintf("%s@%s", ref.Name, digest)
+}
+
+// Get host serving the registry API.
+func (ref *Reference) apiHost() string {
+	if ref.Registry == DEFAULT_REGISTRY {
+		return DOCKER_HUB_API_HOST
+	}
+	return ref.Registry
+}
+
+// Get tag or digest used to look up the image manifest.
+func (ref *Reference) manifestReference() string {
+	if ref.Digest != "" {
+		return ref.Digest
+	}
+	return ref.Tag
+}
+
+// Indicates whether a pattern matches a value. Patterns ending in '/*' match any
+// value with the given prefix, '*' matches everything.
+func matches(pattern string, value string) bool {
+	if pattern == "*" {
+		return true
+	}
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(value, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == value
+}
+
+// Indicates whether an image is allowed by lists of registries and repositories. An empty
+// allow-list places no restriction. Repositories are matched in 'registry/repository' form.
+func IsAllowed(ref *Reference, registries []string, repositories []string) bool {
+	if len(registries) > 0 {
+		allowed := false
+		for _, registry := range registries {
+			if matches(registry, ref.Registry) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	if len(repositories) > 0 {
+		for _, repository := range repositories {
+			if matches(repository, ref.FullRepository()) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
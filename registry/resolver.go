@@ -0,0 +1,298 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	HEADER_CONTENT_DIGEST = "Docker-Content-Digest"
+	HEADER_AUTHENTICATE   = "WWW-Authenticate"
+
+	// Timeout applied to registry requests if no client is provided.
+	DEFAULT_TIMEOUT = 30 * time.Second
+)
+
+// Manifest media types accepted when resolving digests.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// Credentials used to authenticate with a registry
+type Credential struct {
+	Username string
+	Password string
+}
+
+// Resolves image tags to content digests using the OCI distribution API
+type Resolver struct {
+	// HTTP client used for registry requests.
+	Client *http.Client
+
+	// Credentials keyed by registry host.
+	Credentials map[string]Credential
+
+	// Registry hosts accessed over plain HTTP.
+	Insecure map[string]bool
+}
+
+// Create a resolver with the given credentials.
+func NewResolver(credentials map[string]Credential) *Resolver {
+	return &Resolver{
+		Client:      &http.Client{Timeout: DEFAULT_TIMEOUT},
+		Credentials: credentials,
+		Insecure:    make(map[string]bool),
+	}
+}
+
+// Resolve the digest of the manifest referenced by an image.
+func (r *Resolver) Resolve(ctx context.Context, ref *Reference) (string, error) {
+	scheme := "https"
+	if r.Insecure[ref.Registry] {
+		scheme = "http"
+	}
+	manifest := fmt.Sprintf("%s://%s/v2/%s/manifests/%s", scheme, ref.apiHost(), ref.Repository,
+		ref.manifestReference())
+
+	// Try a HEAD request first since it does not count against pull limits.
+	resp, err := r.do(ctx, http.MethodHead, manifest, ref)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		if digest := resp.Header.Get(HEADER_CONTENT_DIGEST); digest != "" {
+			return digest, nil
+		}
+	} else if resp.StatusCode != http.StatusMethodNotAllowed {
+		return "", fmt.Errorf("unable to resolve '%s': registry returned %s", ref.String(), resp.Status)
+	}
+
+	// Fall back to computing the digest from the manifest content.
+	resp, err = r.do(ctx, http.MethodGet, manifest, ref)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unable to resolve '%s': registry returned %s", ref.String(), resp.Status)
+	}
+	if digest := resp.Header.Get(HEADER_CONTENT_DIGEST); digest != "" {
+		return digest, nil
+	}
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(content)), nil
+}
+
+// Execute a manifest request, authenticating if challenged by the registry.
+func (r *Resolver) do(ctx context.Context, method string, manifest string, ref *Reference) (*http.Response, error) {
+	resp, err := r.send(ctx, method, manifest, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	challenge := resp.Header.Get(HEADER_AUTHENTICATE)
+	auth, err := r.authorize(ctx, challenge, ref)
+	if err != nil {
+		return nil, err
+	}
+	return r.send(ctx, method, manifest, auth)
+}
+
+// Send a request to the registry.
+func (r *Resolver) send(ctx context.Context, method string, target string, auth string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	return r.client().Do(req)
+}
+
+// Get the HTTP client used for requests.
+func (r *Resolver) client() *http.Client {
+	if r.Client != nil {
+		return r.Client
+	}
+	return http.DefaultClient
+}
+
+// Get the basic authorization header for a registry (empty if no credentials).
+func (r *Resolver) basicAuth(registry string) string {
+	cred, found := r.Credentials[registry]
+	if !found {
+		return ""
+	}
+	encoded := base64.StdEncoding.EncodeToString([]byte(cred.Username + ":" + cred.Password))
+	return "Basic " + encoded
+}
+
+// Get an authorization header satisfying a registry authentication challenge.
+func (r *Resolver) authorize(ctx context.Context, challenge string, ref *Reference) (string, error) {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if auth := r.basicAuth(ref.Registry); auth != "" {
+			return auth, nil
+		}
+		return "", fmt.Errorf("registry '%s' requires credentials", ref.Registry)
+	case "bearer":
+		return r.fetchToken(ctx, params, ref)
+	}
+	return "", fmt.Errorf("unsupported authentication challenge from registry '%s': '%s'", ref.Registry, challenge)
+}
+
+// Fetch a bearer token with pull access to the referenced repository.
+func (r *Resolver) fetchToken(ctx context.Context, params map[string]string, ref *Reference) (string, error) {
+	realm, found := params["realm"]
+	if !found {
+		return "", fmt.Errorf("registry '%s' did not provide a token realm", ref.Registry)
+	}
+	query := url.Values{}
+	if service, found := params["service"]; found {
+		query.Set("service", service)
+	}
+	query.Set("scope", fmt.Sprintf("repository:%s:pull", ref.Repository))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if auth := r.basicAuth(ref.Registry); auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	resp, err := r.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unable to obtain token for '%s': %s", ref.FullRepository(), resp.Status)
+	}
+
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return "", fmt.Errorf("empty token returned for '%s'", ref.FullRepository())
+	}
+	return "Bearer " + token.Token, nil
+}
+
+// Parse a WWW-Authenticate header into its scheme and parameters.
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := make(map[string]string)
+	challenge = strings.TrimSpace(challenge)
+	i := strings.Index(challenge, " ")
+	if i < 0 {
+		return challenge, params
+	}
+	scheme := challenge[:i]
+	rest := challenge[i+1:]
+	for rest != "" {
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = strings.TrimSpace(rest[eq+1:])
+		var value string
+		if strings.HasPrefix(rest, "\"") {
+			end := strings.Index(rest[1:], "\"")
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if comma := strings.Index(rest, ","); comma >= 0 {
+			value, rest = rest[:comma], rest[comma:]
+		} else {
+			value, rest = rest, ""
+		}
+		params[key] = value
+		rest = strings.TrimPrefix(strings.TrimSpace(rest), ",")
+	}
+	return scheme, params
+}
+
+// Parse registry credentials from the content of a '.dockerconfigjson' secret.
+func ParseDockerConfig(content []byte) (map[string]Credential, error) {
+	config := struct {
+		Auths map[string]struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			Auth     string `json:"auth"`
+		} `json:"auths"`
+	}{}
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, err
+	}
+
+	creds := make(map[string]Credential)
+	for host, auth := range config.Auths {
+		cred := Credential{Username: auth.Username, Password: auth.Password}
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return nil, err
+			}
+			parts := strings.SplitN(string(decoded), ":", 2)
+			if len(parts) == 2 {
+				cred = Credential{Username: parts[0], Password: parts[1]}
+			}
+		}
+
+		// Normalize hosts specified as URLs.
+		host = strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://")
+		host = strings.SplitN(host, "/", 2)[0]
+		if host == "index.docker.io" {
+			host = DEFAULT_REGISTRY
+		}
+		creds[host] = cred
+	}
+	return creds, nil
+}
@@ -0,0 +1,136 @@
+/**
+ * Copyright © 2022 DeviceChain
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testDigest = "sha256:4b825dc642cb6eb9a060e54bf8d69288fbee4904b825dc642cb6eb9a060e54b"
+
+// Start a registry stand-in which requires a bearer token for manifest requests.
+func startRegistry(t *testing.T) (*httptest.Server, *Reference) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			if r.URL.Query().Get("scope") != "repository:devicechain/event-sources:pull" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, `{"token": "abc"}`)
+		case r.URL.Path == "/v2/devicechain/event-sources/manifests/1.0.0":
+			if r.Header.Get("Authorization") != "Bearer abc" {
+				w.Header().Set(HEADER_AUTHENTICATE,
+					fmt.Sprintf(`Bearer realm="%s/token",service="registry"`, server.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set(HEADER_CONTENT_DIGEST, testDigest)
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	ref, err := ParseReference(host + "/devicechain/event-sources:1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, ref
+}
+
+func TestResolveWithTokenAuth(t *testing.T) {
+	server, ref := startRegistry(t)
+
+	resolver := NewResolver(nil)
+	resolver.Insecure[ref.Registry] = true
+	resolver.Client = server.Client()
+
+	digest, err := resolver.Resolve(context.Background(), ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != testDigest {
+		t.Fatalf("expected digest %s, got %s", testDigest, digest)
+	}
+	if pinned := ref.WithDigest(digest); pinned != ref.Name+"@"+testDigest {
+		t.Fatalf("unexpected pinned reference: %s", pinned)
+	}
+}
+
+func TestResolveUnknownTag(t *testing.T) {
+	server, ref := startRegistry(t)
+	ref.Tag = "missing"
+
+	resolver := NewResolver(nil)
+	resolver.Insecure[ref.Registry] = true
+	resolver.Client = server.Client()
+
+	if _, err := resolver.Resolve(context.Background(), ref); err == nil {
+		t.Fatal("expected error resolving unknown tag")
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	cases := []struct {
+		image      string
+		registry   string
+		repository string
+		tag        string
+	}{
+		{"nginx", "docker.io", "library/nginx", "latest"},
+		{"devicechain/event-sources:1.0.0", "docker.io", "devicechain/event-sources", "1.0.0"},
+		{"localhost:5000/event-sources:1.0.0", "localhost:5000", "event-sources", "1.0.0"},
+		{"ghcr.io/devicechain-io/event-sources", "ghcr.io", "devicechain-io/event-sources", "latest"},
+	}
+	for _, c := range cases {
+		ref, err := ParseReference(c.image)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ref.Registry != c.registry || ref.Repository != c.repository || ref.Tag != c.tag {
+			t.Errorf("%s: unexpected reference %+v", c.image, ref)
+		}
+	}
+}
+
+func TestIsAllowed(t *testing.T) {
+	ref, err := ParseReference("ghcr.io/devicechain-io/event-sources:1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsAllowed(ref, nil, nil) {
+		t.Error("expected image to be allowed without policy")
+	}
+	if !IsAllowed(ref, []string{"ghcr.io"}, []string{"ghcr.io/devicechain-io/*"}) {
+		t.Error("expected image to be allowed by registry and repository")
+	}
+	if IsAllowed(ref, []string{"docker.io"}, nil) {
+		t.Error("expected image to be rejected by registry")
+	}
+	if IsAllowed(ref, nil, []string{"ghcr.io/other/*"}) {
+		t.Error("expected image to be rejected by repository")
+	}
+}